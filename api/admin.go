@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/integrity"
+	"github.com/openrelayxyz/cardinal-flume/snapshot"
+)
+
+// verifyLimit is the most blocks admin_verify checks in one call.
+const verifyLimit = 10000
+
+// AdminAPI exposes operational methods. It is only served by ServeAdmin,
+// which main runs when adminApi is set, and snapshots are only written to the
+// configured snapshot directory.
+type AdminAPI struct {
+	db           *sql.DB
+	cfg          *config.Config
+	mut          *sync.RWMutex
+	prunedTables map[string]string
+	stopIndexing func()
+	restart      chan<- struct{}
+	snapping     sync.Mutex
+	rollingBack  sync.Mutex
+}
+
+// NewAdminAPI returns the admin API. prunedTables maps plugin tables to their
+// block columns, for rollbacks. stopIndexing stops the data feed, and restart
+// is signalled once a rollback has finished so that main can shut down and
+// exit for the process to be restarted.
+func NewAdminAPI(db *sql.DB, cfg *config.Config, mut *sync.RWMutex, prunedTables map[string]string, stopIndexing func(), restart chan<- struct{}) *AdminAPI {
+	return &AdminAPI{
+		db:           db,
+		cfg:          cfg,
+		mut:          mut,
+		prunedTables: prunedTables,
+		stopIndexing: stopIndexing,
+		restart:      restart,
+	}
+}
+
+// Snapshot copies the databases into a new timestamped directory under the
+// configured snapshot directory while indexing continues, returning the
+// snapshot's manifest.
+func (api *AdminAPI) Snapshot(ctx context.Context) (*snapshot.Manifest, error) {
+	if !api.snapping.TryLock() {
+		return nil, rpc.NewRPCError(-32000, "a snapshot is already in progress")
+	}
+	defer api.snapping.Unlock()
+	if api.cfg.SnapshotDir == "" {
+		return nil, rpc.NewRPCError(-32000, "no snapshot directory is configured")
+	}
+	// The copy outlives the request's deadline, so it is not tied to ctx.
+	dir := filepath.Join(api.cfg.SnapshotDir, time.Now().UTC().Format("20060102T150405"))
+	manifest, err := snapshot.Create(context.Background(), api.db, api.mut.RLocker(), snapshot.Databases(api.cfg.Databases), dir)
+	if err != nil {
+		log.Error("Error creating snapshot", "dir", dir, "err", err.Error())
+		return nil, rpc.NewRPCError(-32000, "snapshot failed")
+	}
+	return manifest, nil
+}
+
+// Verify checks blocks from through to against the transaction and receipt
+// roots and parent hashes in their headers, optionally queueing mismatched
+// blocks to be re-indexed by the gap auditor.
+func (api *AdminAPI) Verify(ctx context.Context, from, to hexutil.Uint64, queue *bool) (*integrity.Report, error) {
+	if to < from {
+		return nil, rpc.NewRPCError(-32602, "to must not be before from")
+	}
+	if to-from >= verifyLimit {
+		return nil, rpc.NewRPCError(-32602, fmt.Sprintf("at most %v blocks can be verified per call", verifyLimit))
+	}
+	report, err := integrity.NewVerifier(api.db, api.cfg).Verify(ctx, uint64(from), uint64(to), queue != nil && *queue)
+	if err != nil {
+		log.Error("Error verifying blocks", "from", from, "to", to, "err", err.Error())
+		return nil, rpc.NewRPCError(-32000, "verification failed")
+	}
+	return report, nil
+}
+
+// Rollback removes block number and everything after it from every table and
+// rewinds the stored resumption to the block before it. head must be the hash
+// of the current head block, guarding against rolling back a chain the caller
+// has not inspected.
+//
+// The consumer cannot be rewound in place, so indexing is stopped before the
+// rollback, keeping blocks and offsets past the rollback point from being
+// written after it, and main is signalled to shut down afterwards. main exits
+// non-zero once its cleanup has run so that a supervisor restarts the process,
+// which then resumes from the new head.
+func (api *AdminAPI) Rollback(ctx context.Context, number hexutil.Uint64, head types.Hash) (hexutil.Uint64, error) {
+	if !api.rollingBack.TryLock() {
+		return 0, rpc.NewRPCError(-32000, "a rollback is already in progress")
+	}
+	var hash []byte
+	api.mut.RLock()
+	err := api.db.QueryRowContext(ctx, "SELECT hash FROM blocks.blocks ORDER BY number DESC LIMIT 1;").Scan(&hash)
+	api.mut.RUnlock()
+	if err != nil || types.BytesToHash(hash) != head {
+		api.rollingBack.Unlock()
+		return 0, rpc.NewRPCError(-32602, "head does not match the current head block")
+	}
+	// Once indexing has stopped the process has to restart whether or not the
+	// rollback succeeds, so rollingBack is kept locked from here on.
+	api.stopIndexing()
+	defer func() {
+		select {
+		case api.restart <- struct{}{}:
+		default:
+		}
+	}()
+	api.mut.Lock()
+	err = indexer.Rollback(context.Background(), api.db, api.cfg, api.prunedTables, int64(number))
+	api.mut.Unlock()
+	if err != nil {
+		log.Error("Error rolling back, restarting", "block", number, "err", err.Error())
+		return 0, rpc.NewRPCError(-32000, fmt.Sprintf("rollback failed: %v", err.Error()))
+	}
+	log.Warn("Rolled back by admin_rollback, restarting", "head", uint64(number)-1)
+	return number - 1, nil
+}
+
+// ServeAdmin serves the admin namespace over HTTP on addr. It is kept off the
+// public transports, which listen on every interface, so that admin methods
+// are only reachable from where the admin listener is bound.
+func ServeAdmin(addr string, concurrency int, admin *AdminAPI) error {
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           newAdminHandler(concurrency, admin),
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+	log.Info("Serving admin namespace", "addr", addr)
+	return s.ListenAndServe()
+}
+
+// newAdminHandler returns an HTTP handler for single and batched JSON-RPC
+// calls to the admin namespace only.
+func newAdminHandler(concurrency int, admin *AdminAPI) http.Handler {
+	registry := rpc.NewRegistry(concurrency)
+	registry.Register("admin", admin)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handle := func(call *rpc.Call) *rpc.Response {
+			response := &rpc.Response{Version: "2.0", ID: call.ID}
+			result, rpcErr, _ := registry.Call(r.Context(), call.Method, call.Params, nil, -1)
+			if rpcErr == nil {
+				response.Result = result
+			} else {
+				response.Error = rpcErr
+			}
+			return response
+		}
+		var result interface{}
+		call := &rpc.Call{}
+		calls := []rpc.Call{}
+		if err := json.Unmarshal(body, call); err == nil {
+			result = handle(call)
+		} else if err := json.Unmarshal(body, &calls); err == nil {
+			responses := make([]*rpc.Response, len(calls))
+			for i := range calls {
+				responses[i] = handle(&calls[i])
+			}
+			result = responses
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			result = &rpc.Response{Version: "2.0", ID: json.RawMessage("-1"), Error: rpc.NewRPCError(-32700, err.Error())}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
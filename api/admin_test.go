@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-rpc"
+)
+
+func TestAdminHandler(t *testing.T) {
+	handler := newAdminHandler(1, NewAdminAPI(nil, nil, nil, nil, nil, nil))
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		// Rejected before the database is used.
+		{`{"jsonrpc":"2.0","id":1,"method":"admin_verify","params":["0x2","0x1"]}`, -32602},
+		// Only the admin namespace is served.
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`, -32601},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(tc.body)))
+		var response struct {
+			Error *rpc.RPCError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("error decoding response %v: %v", w.Body.String(), err.Error())
+		}
+		if response.Error == nil || response.Error.Code() != tc.code {
+			t.Errorf("expected error code %v, got %v", tc.code, w.Body.String())
+		}
+	}
+}
@@ -128,7 +128,7 @@ var (
 
 func (api *BlockAPI) GetBlockByHash(ctx context.Context, blockHash types.Hash, includeTxns bool) (*map[string]interface{}, error) {
 
-	if len(api.cfg.HeavyServer) > 0 && !blockDataPresent(blockHash, api.cfg, api.db) {
+	if len(api.cfg.HeavyServer) > 0 && !blockDataPresent(blockHash, api.cfg, api.db) && !orphanedBlockPresent(blockHash, api.db) {
 		log.Debug("eth_getBlockByHash sent to flume heavy")
 		missMeter.Mark(1)
 		gbbhMissMeter.Mark(1)
@@ -154,6 +154,12 @@ func (api *BlockAPI) GetBlockByHash(ctx context.Context, blockHash types.Hash, i
 	if err != nil {
 		return nil, err
 	}
+	if len(blocks) == 0 && orphanedBlockPresent(blockHash, api.db) {
+		blocks, err = getOrphanedBlocks(ctx, api.db, includeTxns, api.network, "hash = ?", trimPrefix(blockHash.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+	}
 	var blockVal map[string]interface{}
 	if len(blocks) > 0 {
 		blockVal = blocks[0]
@@ -417,7 +423,7 @@ func (api *BlockAPI) GetBlockReceipts(ctx context.Context, input BlockNumberOrHa
 			gbrHitMeter.Mark(1)
 		}
 	
-		receipts, err := getFlumeTransactionReceiptsBlock(ctx, api.db, 0, 100000, api.network, "blocks.hash = ?", trimPrefix(blockHash.Bytes()))
+		receipts, err := getFlumeTransactionReceiptsBlock(ctx, api.db, 0, 100000, api.network, blockHashClause, trimPrefix(blockHash.Bytes()))
 		if err != nil {
 			log.Error("Error getting receipts, eth_getBlockReciepts, blockHash", "err", err)
 			return nil, nil
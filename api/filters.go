@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/plugins"
+)
+
+var (
+	errFilterNotFound = rpc.NewRPCError(-32000, "filter not found")
+
+	filterGauge        = metrics.NewMinorGauge("/flume/filters")
+	filterTimeoutMeter = metrics.NewMinorMeter("/flume/filters/timeout")
+)
+
+type filterType int
+
+const (
+	logsFilter filterType = iota
+	blocksFilter
+)
+
+// filter holds the chain events received since a filter was last polled.
+// lastBlock is the highest block delivered to the client.
+type filter struct {
+	typ       filterType
+	crit      FilterQuery
+	events    []*indexer.ChainEvent
+	lastBlock int64
+	deadline  time.Time
+}
+
+// FilterAPI implements the stateful filter methods. Filters are held in
+// memory, accumulate events from the ChainFeed, and are uninstalled if they
+// are not polled within the configured timeout.
+type FilterAPI struct {
+	db      *sql.DB
+	network uint64
+	cfg     *config.Config
+	logs    *LogsAPI
+	timeout time.Duration
+	filters map[string]*filter
+	lock    sync.Mutex
+}
+
+func NewFilterAPI(db *sql.DB, network uint64, pl *plugins.PluginLoader, cfg *config.Config, chainFeed *indexer.ChainFeed) *FilterAPI {
+	api := &FilterAPI{
+		db:      db,
+		network: network,
+		cfg:     cfg,
+		logs:    NewLogsAPI(db, network, pl, cfg),
+		timeout: time.Duration(cfg.FilterTimeout) * time.Second,
+		filters: make(map[string]*filter),
+	}
+	go api.run(chainFeed)
+	return api
+}
+
+// run delivers chain events to installed filters and removes filters that
+// have timed out. If run falls behind the chain feed, the installed filters
+// have missed events, so they are all removed.
+func (api *FilterAPI) run(chainFeed *indexer.ChainFeed) {
+	ticker := time.NewTicker(api.timeout / 2)
+	defer ticker.Stop()
+	for {
+		events := make(chan *indexer.ChainEvent, subscriptionBuffer)
+		sub := chainFeed.Subscribe(events)
+	loop:
+		for {
+			select {
+			case <-sub.Err():
+				break loop
+			case event := <-events:
+				api.lock.Lock()
+				for _, f := range api.filters {
+					f.events = append(f.events, event)
+				}
+				api.lock.Unlock()
+			case now := <-ticker.C:
+				api.lock.Lock()
+				for id, f := range api.filters {
+					if now.After(f.deadline) {
+						log.Debug("Filter timed out", "id", id)
+						filterTimeoutMeter.Mark(1)
+						delete(api.filters, id)
+					}
+				}
+				filterGauge.Update(int64(len(api.filters)))
+				api.lock.Unlock()
+			}
+		}
+		sub.Unsubscribe()
+		log.Warn("Filters fell behind the chain feed, removing filters")
+		api.lock.Lock()
+		api.filters = make(map[string]*filter)
+		filterGauge.Update(0)
+		api.lock.Unlock()
+	}
+}
+
+func newFilterID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hexutil.Encode(id), nil
+}
+
+func (api *FilterAPI) install(ctx context.Context, f *filter) (string, error) {
+	id, err := newFilterID()
+	if err != nil {
+		return "", err
+	}
+	latestBlock, err := getLatestBlock(ctx, api.db)
+	if err != nil {
+		log.Error("Error retrieving latest block, eth_newFilter", "err", err.Error())
+		return "", err
+	}
+	f.lastBlock = latestBlock
+	f.events = []*indexer.ChainEvent{}
+	f.deadline = time.Now().Add(api.timeout)
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	api.filters[id] = f
+	filterGauge.Update(int64(len(api.filters)))
+	return id, nil
+}
+
+func (api *FilterAPI) NewFilter(ctx context.Context, crit FilterQuery) (string, error) {
+	if crit.BlockHash != nil {
+		return "", errors.New("blockHash is not supported by eth_newFilter")
+	}
+	return api.install(ctx, &filter{typ: logsFilter, crit: crit})
+}
+
+func (api *FilterAPI) NewBlockFilter(ctx context.Context) (string, error) {
+	return api.install(ctx, &filter{typ: blocksFilter})
+}
+
+func (api *FilterAPI) UninstallFilter(ctx context.Context, id string) (bool, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	_, ok := api.filters[id]
+	delete(api.filters, id)
+	filterGauge.Update(int64(len(api.filters)))
+	return ok, nil
+}
+
+// GetFilterChanges returns the block hashes or logs for the chain events since
+// the filter was last polled. For log filters, logs from blocks removed by a
+// reorg are returned with removed set.
+func (api *FilterAPI) GetFilterChanges(ctx context.Context, id string) (interface{}, error) {
+	api.lock.Lock()
+	f, ok := api.filters[id]
+	if !ok {
+		api.lock.Unlock()
+		return nil, errFilterNotFound
+	}
+	events := f.events
+	f.events = []*indexer.ChainEvent{}
+	f.deadline = time.Now().Add(api.timeout)
+	changes, lastBlock := unseen(events, f.lastBlock)
+	api.lock.Unlock()
+
+	switch f.typ {
+	case blocksFilter:
+		hashes := []types.Hash{}
+		for _, event := range changes {
+			for _, block := range event.Added {
+				hashes = append(hashes, block.Hash)
+			}
+		}
+		api.delivered(id, lastBlock)
+		return hashes, nil
+	default:
+		filterClause, filterParams := logsFilterClause(f.crit)
+		results := []*logType{}
+		for _, event := range changes {
+			logs, err := chainEventLogs(ctx, api.db, f.inRange(event), filterClause, filterParams)
+			if err != nil {
+				log.Error("Error getting logs, eth_getFilterChanges", "err", err.Error())
+				api.restore(id, events)
+				return nil, err
+			}
+			results = append(results, logs...)
+		}
+		api.delivered(id, lastBlock)
+		return results, nil
+	}
+}
+
+// GetFilterLogs returns all logs matching a log filter's criteria, falling
+// back to the heavy server as eth_getLogs does when the range reaches below
+// the earliest block held locally.
+func (api *FilterAPI) GetFilterLogs(ctx context.Context, id string) ([]*logType, error) {
+	api.lock.Lock()
+	f, ok := api.filters[id]
+	if ok {
+		f.deadline = time.Now().Add(api.timeout)
+	}
+	api.lock.Unlock()
+	if !ok || f.typ != logsFilter {
+		return nil, errFilterNotFound
+	}
+	return api.logs.GetLogs(ctx, f.crit)
+}
+
+// restore puts events back at the front of a filter's queue after a failed
+// poll, so they are delivered by the next one.
+func (api *FilterAPI) restore(id string, events []*indexer.ChainEvent) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	if f, ok := api.filters[id]; ok {
+		f.events = append(events, f.events...)
+	}
+}
+
+// delivered records lastBlock as the highest block the client has received.
+func (api *FilterAPI) delivered(id string, lastBlock int64) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	if f, ok := api.filters[id]; ok {
+		f.lastBlock = lastBlock
+	}
+}
+
+// unseen reduces events to the changes a client that has received blocks up
+// to lastBlock has not seen: added blocks at or below lastBlock are dropped,
+// as are removed blocks above it. A reorg rewinds lastBlock below the removed
+// blocks, so their replacements are delivered. It returns the remaining
+// events and the new lastBlock.
+func unseen(events []*indexer.ChainEvent, lastBlock int64) ([]*indexer.ChainEvent, int64) {
+	changes := make([]*indexer.ChainEvent, 0, len(events))
+	for _, event := range events {
+		change := &indexer.ChainEvent{Added: []indexer.BlockRef{}, Removed: []indexer.BlockRef{}}
+		seen := lastBlock
+		for _, block := range event.Removed {
+			if block.Number <= seen {
+				change.Removed = append(change.Removed, block)
+				if block.Number-1 < lastBlock {
+					lastBlock = block.Number - 1
+				}
+			}
+		}
+		seen = lastBlock
+		for _, block := range event.Added {
+			if block.Number > seen {
+				change.Added = append(change.Added, block)
+				if block.Number > lastBlock {
+					lastBlock = block.Number
+				}
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, lastBlock
+}
+
+// inRange restricts event to the blocks within the filter's fromBlock and
+// toBlock. Negative block numbers leave that end of the range open.
+func (f *filter) inRange(event *indexer.ChainEvent) *indexer.ChainEvent {
+	inRange := func(blocks []indexer.BlockRef) []indexer.BlockRef {
+		result := []indexer.BlockRef{}
+		for _, block := range blocks {
+			if f.crit.FromBlock != nil && int64(*f.crit.FromBlock) >= 0 && block.Number < int64(*f.crit.FromBlock) {
+				continue
+			}
+			if f.crit.ToBlock != nil && int64(*f.crit.ToBlock) >= 0 && block.Number > int64(*f.crit.ToBlock) {
+				continue
+			}
+			result = append(result, block)
+		}
+		return result
+	}
+	return &indexer.ChainEvent{Added: inRange(event.Added), Removed: inRange(event.Removed)}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-flume/indexer"
+)
+
+func TestFilterRange(t *testing.T) {
+	from, to := rpc.BlockNumber(2), rpc.LatestBlockNumber
+	f := &filter{typ: logsFilter, crit: FilterQuery{FromBlock: &from, ToBlock: &to}}
+	event := f.inRange(&indexer.ChainEvent{
+		Added:   []indexer.BlockRef{{Number: 1, Hash: types.Hash{1}}, {Number: 2, Hash: types.Hash{2}}, {Number: 3, Hash: types.Hash{3}}},
+		Removed: []indexer.BlockRef{{Number: 1, Hash: types.Hash{4}}},
+	})
+	if len(event.Added) != 2 || event.Added[0].Number != 2 || event.Added[1].Number != 3 {
+		t.Errorf("unexpected added blocks %v", event.Added)
+	}
+	if len(event.Removed) != 0 {
+		t.Errorf("unexpected removed blocks %v", event.Removed)
+	}
+}
+
+func TestFilterUnseen(t *testing.T) {
+	events := []*indexer.ChainEvent{
+		// Restored after a failed poll, so block 10 was already delivered.
+		{Added: []indexer.BlockRef{{Number: 10, Hash: types.Hash{10}}}},
+		{Added: []indexer.BlockRef{{Number: 11, Hash: types.Hash{11}}}},
+		// Block 12 was never delivered, so only 11 is reported as removed.
+		{
+			Added:   []indexer.BlockRef{{Number: 11, Hash: types.Hash{111}}, {Number: 12, Hash: types.Hash{112}}},
+			Removed: []indexer.BlockRef{{Number: 11, Hash: types.Hash{11}}, {Number: 12, Hash: types.Hash{12}}},
+		},
+	}
+	changes, lastBlock := unseen(events, 11)
+	if lastBlock != 12 {
+		t.Errorf("unexpected last block %v", lastBlock)
+	}
+	if len(changes[0].Added) != 0 || len(changes[1].Added) != 0 {
+		t.Errorf("delivered blocks were not dropped: %v, %v", changes[0].Added, changes[1].Added)
+	}
+	if len(changes[2].Removed) != 1 || changes[2].Removed[0].Hash != (types.Hash{11}) {
+		t.Errorf("unexpected removed blocks %v", changes[2].Removed)
+	}
+	if len(changes[2].Added) != 2 {
+		t.Errorf("unexpected added blocks %v", changes[2].Added)
+	}
+}
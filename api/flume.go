@@ -3,11 +3,13 @@ package api
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"runtime"
 	"encoding/hex"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-evm/rlp"
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-rpc"
 	"github.com/openrelayxyz/cardinal-types/hexutil"
@@ -15,6 +17,7 @@ import (
 	"github.com/openrelayxyz/cardinal-flume/build"
 	"github.com/openrelayxyz/cardinal-flume/config"
 	"github.com/openrelayxyz/cardinal-flume/heavy"
+	"github.com/openrelayxyz/cardinal-flume/intern"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
 )
 
@@ -66,190 +69,156 @@ func (api *FlumeAPI) HeavyClientVersion(ctx context.Context) (string, error) {
 }
 
 
-func (api *FlumeAPI) GetTransactionsBySender(ctx context.Context, address common.Address, offset *int) (*paginator[map[string]interface{}], error) {
+func (api *FlumeAPI) GetTransactionsBySender(ctx context.Context, address common.Address, token *paginationToken, opts *historyOptions) (*paginator[map[string]interface{}], error) {
+	clause, params := intern.MatchAddress("sender", trimPrefix(address.Bytes()))
+	q, err := api.newHistoryQuery(ctx, opts, clause, params...)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(api.cfg.HeavyServer) > 0 {
-		log.Debug("flume_getTransactionsBySender sent to flume heavy by default")
+	if len(api.cfg.HeavyServer) > 0 && !q.local {
+		log.Debug("flume_getTransactionsBySender sent to flume heavy")
 		missMeter.Mark(1)
-		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionsBySender", address, offset)
+		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionsBySender", heavyHistoryParams(address, token, opts)...)
 		if err != nil {
 			return nil, err
 		}
 		return *tx, nil
 	}
 
-	if offset == nil {
-		offset = new(int)
-	}
-	ctxs, err := getFlumeTransactions(ctx, api.db, *offset, 1000, api.network, "sender = ?", trimPrefix(address.Bytes()))
-	if err != nil {
-		log.Error("Error getting txs", "err", err.Error())
-		return nil, err
+	if len(api.cfg.HeavyServer) > 0 {
+		log.Debug("flume_getTransactionsBySender served from flume light")
+		hitMeter.Mark(1)
 	}
-	txs, err := getPendingTransactions(ctx, api.db, api.mempool, *offset, 1000, api.network, "sender = ?", trimPrefix(address.Bytes()))
+
+	return api.getTransactionsPage(ctx, token, q)
+}
+
+func (api *FlumeAPI) GetTransactionReceiptsBySender(ctx context.Context, address common.Address, token *paginationToken, opts *historyOptions) (*paginator[map[string]interface{}], error) {
+	clause, params := intern.MatchAddress("sender", trimPrefix(address.Bytes()))
+	q, err := api.newHistoryQuery(ctx, opts, clause, params...)
 	if err != nil {
-		log.Error("Error getting pending txs", "err", err.Error())
 		return nil, err
 	}
-	ctxs = append(ctxs, txs...)
-	
-	result := paginator[map[string]interface{}]{Items: ctxs}
-	if len(ctxs) >= 1000 {
-		result.Token = *offset + len(ctxs)
-	}
-	return &result, nil
-}
 
-func (api *FlumeAPI) GetTransactionReceiptsBySender(ctx context.Context, address common.Address, offset *int) (*paginator[map[string]interface{}], error) {
-
-	if len(api.cfg.HeavyServer) > 0 {
-		log.Debug("flume_getTransactionReceiptsBySender sent to flume heavy by default")
+	if len(api.cfg.HeavyServer) > 0 && !q.local {
+		log.Debug("flume_getTransactionReceiptsBySender sent to flume heavy")
 		missMeter.Mark(1)
-		rt, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionReceiptsBySender", address, offset)
+		rt, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionReceiptsBySender", heavyHistoryParams(address, token, opts)...)
 		if err != nil {
 			return nil, err
 		}
 		return *rt, nil
 	}
 
-	if offset == nil {
-		offset = new(int)
-	}
-	receipts, err := getFlumeTransactionReceipts(ctx, api.db, *offset, 1000, api.network, "sender = ?", trimPrefix(address.Bytes()))
-	if err != nil {
-		log.Error("Error getting receipts", "err", err.Error())
-		return nil, err
-	}
-	result := paginator[map[string]interface{}]{Items: receipts}
-	if len(receipts) == 1000 {
-		result.Token = *offset + len(receipts)
+	if len(api.cfg.HeavyServer) > 0 {
+		log.Debug("flume_getTransactionReceiptsBySender served from flume light")
+		hitMeter.Mark(1)
 	}
 
-	return &result, nil
+	return api.getReceiptsPage(ctx, token, q)
 }
 
-func (api *FlumeAPI) GetTransactionsByRecipient(ctx context.Context, address common.Address, offset *int) (*paginator[map[string]interface{}], error) {
+func (api *FlumeAPI) GetTransactionsByRecipient(ctx context.Context, address common.Address, token *paginationToken, opts *historyOptions) (*paginator[map[string]interface{}], error) {
+	clause, params := intern.MatchAddress("recipient", trimPrefix(address.Bytes()))
+	q, err := api.newHistoryQuery(ctx, opts, clause, params...)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(api.cfg.HeavyServer) > 0 {
-		log.Debug("flume_getTransactionsByRecipient sent to flume heavy by default")
+	if len(api.cfg.HeavyServer) > 0 && !q.local {
+		log.Debug("flume_getTransactionsByRecipient sent to flume heavy")
 		missMeter.Mark(1)
-		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionsByRecipient", address, offset)
+		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionsByRecipient", heavyHistoryParams(address, token, opts)...)
 		if err != nil {
 			return nil, err
 		}
 		return *tx, nil
 	}
 
-	if offset == nil {
-		offset = new(int)
-	}
-	ctxs, err := getFlumeTransactions(ctx, api.db, *offset, 1000, api.network, "recipient = ?", trimPrefix(address.Bytes()))
-	if err != nil {
-		log.Error("Error getting txs", "err", err.Error())
-		return nil, err
+	if len(api.cfg.HeavyServer) > 0 {
+		log.Debug("flume_getTransactionsByRecipient served from flume light")
+		hitMeter.Mark(1)
 	}
-	txs, err := getPendingTransactions(ctx, api.db, api.mempool, *offset, 1000, api.network, "recipient = ?", trimPrefix(address.Bytes()))
+
+	return api.getTransactionsPage(ctx, token, q)
+}
+
+func (api *FlumeAPI) GetTransactionReceiptsByRecipient(ctx context.Context, address common.Address, token *paginationToken, opts *historyOptions) (*paginator[map[string]interface{}], error) {
+	clause, params := intern.MatchAddress("recipient", trimPrefix(address.Bytes()))
+	q, err := api.newHistoryQuery(ctx, opts, clause, params...)
 	if err != nil {
-		log.Error("Error getting pending txs", "err", err.Error())
 		return nil, err
 	}
-	ctxs = append(ctxs, txs...)
-	
-	result := paginator[map[string]interface{}]{Items: ctxs}
-	if len(ctxs) >= 1000 {
-		result.Token = *offset + len(ctxs)
-	}
-	return &result, nil
-}
-
-func (api *FlumeAPI) GetTransactionReceiptsByRecipient(ctx context.Context, address common.Address, offset *int) (*paginator[map[string]interface{}], error) {
 
-	if len(api.cfg.HeavyServer) > 0 {
-		log.Debug("flume_getTransactionReceiptsByRecipient sent to flume heavy by default")
+	if len(api.cfg.HeavyServer) > 0 && !q.local {
+		log.Debug("flume_getTransactionReceiptsByRecipient sent to flume heavy")
 		missMeter.Mark(1)
-		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionReceiptsByRecipient", address, offset)
+		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionReceiptsByRecipient", heavyHistoryParams(address, token, opts)...)
 		if err != nil {
 			return nil, err
 		}
 		return *tx, nil
 	}
 
-	if offset == nil {
-		offset = new(int)
+	if len(api.cfg.HeavyServer) > 0 {
+		log.Debug("flume_getTransactionReceiptsByRecipient served from flume light")
+		hitMeter.Mark(1)
 	}
-	receipts, err := getFlumeTransactionReceipts(ctx, api.db, *offset, 1000, api.network, "recipient = ?", trimPrefix(address.Bytes()))
+
+	return api.getReceiptsPage(ctx, token, q)
+}
+
+func (api *FlumeAPI) GetTransactionsByParticipant(ctx context.Context, address common.Address, token *paginationToken, opts *historyOptions) (*paginator[map[string]interface{}], error) {
+	senderClause, params := intern.MatchAddress("sender", trimPrefix(address.Bytes()))
+	recipientClause, recipientParams := intern.MatchAddress("recipient", trimPrefix(address.Bytes()))
+	q, err := api.newHistoryQuery(ctx, opts, fmt.Sprintf("%v OR %v", senderClause, recipientClause), append(params, recipientParams...)...)
 	if err != nil {
-		log.Error("Error getting receipts", "err", err.Error())
 		return nil, err
 	}
-	result := paginator[map[string]interface{}]{Items: receipts}
-	if len(receipts) == 1000 {
-		result.Token = *offset + len(receipts)
-	}
-	return &result, nil
-}
 
-func (api *FlumeAPI) GetTransactionsByParticipant(ctx context.Context, address common.Address, offset *int) (*paginator[map[string]interface{}], error) {
-
-	if len(api.cfg.HeavyServer) > 0 {
-		log.Debug("flume_getTransactionByParticipant sent to flume heavy by default")
+	if len(api.cfg.HeavyServer) > 0 && !q.local {
+		log.Debug("flume_getTransactionByParticipant sent to flume heavy")
 		missMeter.Mark(1)
-		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionsByParticipant", address, offset)
+		tx, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionsByParticipant", heavyHistoryParams(address, token, opts)...)
 		if err != nil {
 			return nil, err
 		}
 		return *tx, nil
 	}
 
-	if offset == nil {
-		offset = new(int)
-	}
-	ctxs, err := getFlumeTransactions(ctx, api.db, *offset, 1000, api.network, "sender = ? OR recipient = ?", trimPrefix(address.Bytes()), trimPrefix(address.Bytes()))
-	if err != nil {
-		log.Error("Error getting txs", "err", err.Error())
-		return nil, err
-	}
-	txs, err := getPendingTransactions(ctx, api.db, api.mempool, *offset, 1000, api.network, "sender = ? OR recipient = ?", trimPrefix(address.Bytes()), trimPrefix(address.Bytes()))
-	if err != nil {
-		log.Error("Error getting pending txs", "err", err.Error())
-		return nil, err
-	}
-	ctxs = append(ctxs, txs...)
-	
-	result := paginator[map[string]interface{}]{Items: ctxs}
-	if len(ctxs) >= 1000 {
-		result.Token = *offset + len(ctxs)
+	if len(api.cfg.HeavyServer) > 0 {
+		log.Debug("flume_getTransactionsByParticipant served from flume light")
+		hitMeter.Mark(1)
 	}
 
-	return &result, nil
+	return api.getTransactionsPage(ctx, token, q)
 }
 
-func (api *FlumeAPI) GetTransactionReceiptsByParticipant(ctx context.Context, address common.Address, offset *int) (*paginator[map[string]interface{}], error) {
+func (api *FlumeAPI) GetTransactionReceiptsByParticipant(ctx context.Context, address common.Address, token *paginationToken, opts *historyOptions) (*paginator[map[string]interface{}], error) {
+	senderClause, params := intern.MatchAddress("sender", trimPrefix(address.Bytes()))
+	recipientClause, recipientParams := intern.MatchAddress("recipient", trimPrefix(address.Bytes()))
+	q, err := api.newHistoryQuery(ctx, opts, fmt.Sprintf("%v OR %v", senderClause, recipientClause), append(params, recipientParams...)...)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(api.cfg.HeavyServer) > 0 {
-		log.Debug("flume_getTransactionReceiptsByParticipant sent to flume heavy by default")
+	if len(api.cfg.HeavyServer) > 0 && !q.local {
+		log.Debug("flume_getTransactionReceiptsByParticipant sent to flume heavy")
 		missMeter.Mark(1)
-		rt, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionReceiptsByParticipant", address, offset)
+		rt, err := heavy.CallHeavy[*paginator[map[string]interface{}]](ctx, api.cfg.HeavyServer, "flume_getTransactionReceiptsByParticipant", heavyHistoryParams(address, token, opts)...)
 		if err != nil {
 			return nil, err
 		}
 		return *rt, nil
 	}
 
-	if offset == nil {
-		offset = new(int)
-	}
-	receipts, err := getFlumeTransactionReceipts(ctx, api.db, *offset, 1000, api.network, "sender = ? OR recipient = ?", trimPrefix(address.Bytes()), trimPrefix(address.Bytes()))
-	if err != nil {
-		log.Error("Error getting receipts", "err", err.Error())
-		return nil, err
-	}
-	result := paginator[map[string]interface{}]{Items: receipts}
-	if len(receipts) == 1000 {
-		result.Token = *offset + len(receipts)
+	if len(api.cfg.HeavyServer) > 0 {
+		log.Debug("flume_getTransactionReceiptsByParticipant served from flume light")
+		hitMeter.Mark(1)
 	}
 
-	return &result, nil
+	return api.getReceiptsPage(ctx, token, q)
 }
 
 var (
@@ -276,7 +245,7 @@ func (api *FlumeAPI) GetTransactionReceiptsByBlockHash(ctx context.Context, bloc
 		gtrbhHitMeter.Mark(1)
 	}
 
-	receipts, err := getFlumeTransactionReceiptsBlock(ctx, api.db, 0, 100000, api.network, "blocks.hash = ?", trimPrefix(blockHash.Bytes()))
+	receipts, err := getFlumeTransactionReceiptsBlock(ctx, api.db, 0, 100000, api.network, blockHashClause, trimPrefix(blockHash.Bytes()))
 	if err != nil {
 		log.Error("Error getting receipts, flume_getTransactionReceiptsByBlockHash", "err", err)
 		return nil, err
@@ -352,7 +321,7 @@ func (api *FlumeAPI) GetBlockByTransactionHash(ctx context.Context, txHash types
 	}
 
 	var blockNumber int64
-	if err := api.db.QueryRowContext(ctx, "SELECT block FROM transactions.transactions WHERE hash = ?;", txHash).Scan(&blockNumber); err != nil {
+	if err := api.db.QueryRowContext(ctx, fmt.Sprintf("SELECT block FROM %v WHERE hash = ?;", allBlocks.transactions()), txHash).Scan(&blockNumber); err != nil {
 		log.Error("GetBlockByTransactionHash returned an error", "err", err.Error())
 	}
 
@@ -476,7 +445,7 @@ func (api *FlumeAPI) TransactionHashesWithPrefix(ctx context.Context, partialHex
 			result = append(result, hexutil.Encode(hashBytes))
 		}
 	}
-	txStatement := "SELECT hash FROM transactions.transactions WHERE hash > ? AND hash < ? AND LENGTH(hash) = ? LIMIT 20"
+	txStatement := fmt.Sprintf("SELECT hash FROM %v WHERE hash > ? AND hash < ? AND LENGTH(hash) = ? LIMIT 20", allBlocks.transactions())
 	
 	txRows, err := api.db.QueryContext(ctx, txStatement, bytes, augmentedBytes, 32 - zeros)
 	if err != nil {
@@ -530,8 +499,10 @@ func (api *FlumeAPI) AddressWithPrefix(ctx context.Context, partialHexString str
 
 	augmentedBytes := incrementLastByte(bytes)
 
-	statement := "SELECT DISTINCT(address) FROM event_logs WHERE address > ? AND address < ? AND LENGTH(address) = ? LIMIT 20"
-	rows, err := api.db.QueryContext(ctx, statement, bytes, augmentedBytes, 20 - zeros)
+	// Interned addresses are ids in the logs, so they are matched in the
+	// intern table instead.
+	statement := fmt.Sprintf("SELECT DISTINCT(address) FROM %v WHERE address > ? AND address < ? AND LENGTH(address) = ? UNION SELECT value FROM %v WHERE value > ? AND value < ? AND LENGTH(value) = ? LIMIT 20", allBlocks.logs(""), intern.Addresses)
+	rows, err := api.db.QueryContext(ctx, statement, bytes, augmentedBytes, 20 - zeros, bytes, augmentedBytes, 20 - zeros)
 	if err != nil {
 		log.Error("Error returned from query in flume_addressWithPrefix", "err", err)
 		return nil, nil
@@ -586,4 +557,68 @@ func (api *FlumeAPI) ResolvePrefix(ctx context.Context, partialHexString string)
 	}
 
 	return result, nil
-}
\ No newline at end of file
+}
+
+// reorgRecord is a reorg journaled by the indexer, as returned by GetReorgs.
+type reorgRecord struct {
+	Block    hexutil.Uint64 `json:"block"`
+	Depth    hexutil.Uint64 `json:"depth"`
+	OldHead  types.Hash     `json:"oldHead"`
+	NewHead  types.Hash     `json:"newHead"`
+	Replaced []types.Hash   `json:"replaced"`
+	Time     hexutil.Uint64 `json:"time"`
+}
+
+// GetReorgs returns the reorgs observed by this instance whose first replaced
+// block falls within the given range. Reorgs are journaled locally, so this is
+// never sent to flume heavy.
+func (api *FlumeAPI) GetReorgs(ctx context.Context, fromBlock, toBlock rpc.BlockNumber) ([]*reorgRecord, error) {
+	if int64(fromBlock) < 0 || int64(toBlock) < 0 {
+		latestBlock, err := getLatestBlock(ctx, api.db)
+		if err != nil {
+			return nil, err
+		}
+		if int64(fromBlock) < 0 {
+			fromBlock = rpc.BlockNumber(latestBlock)
+		}
+		if int64(toBlock) < 0 {
+			toBlock = rpc.BlockNumber(latestBlock)
+		}
+	}
+	if fromBlock > toBlock {
+		return nil, rpc.NewRPCError(-32602, "fromBlock must not be greater than toBlock")
+	}
+
+	rows, err := api.db.QueryContext(ctx, "SELECT block, depth, oldHead, newHead, replaced, time FROM blocks.reorgs WHERE block >= ? AND block <= ? ORDER BY id;", int64(fromBlock), int64(toBlock))
+	if err != nil {
+		log.Error("Error querying reorgs, flume_getReorgs", "err", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*reorgRecord{}
+	for rows.Next() {
+		var block, depth, reorgTime uint64
+		var oldHead, newHead, replacedRLP []byte
+		if err := rows.Scan(&block, &depth, &oldHead, &newHead, &replacedRLP, &reorgTime); err != nil {
+			log.Error("Error scanning reorgs, flume_getReorgs", "err", err.Error())
+			return nil, err
+		}
+		replaced := []types.Hash{}
+		if err := rlp.DecodeBytes(replacedRLP, &replaced); err != nil {
+			log.Error("Error decoding replaced hashes, flume_getReorgs", "err", err.Error())
+			return nil, err
+		}
+		results = append(results, &reorgRecord{
+			Block:    hexutil.Uint64(block),
+			Depth:    hexutil.Uint64(depth),
+			OldHead:  bytesToHash(oldHead),
+			NewHead:  bytesToHash(newHead),
+			Replaced: replaced,
+			Time:     hexutil.Uint64(reorgTime),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-flume/config"
 	"github.com/openrelayxyz/cardinal-flume/heavy"
+	"github.com/openrelayxyz/cardinal-flume/intern"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
 )
 
@@ -50,7 +51,8 @@ func (api *FlumeTokensAPI) Erc20ByAccount(ctx context.Context, addr common.Addre
 	defer cancel()
 
 	topic0 := types.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
-	rows, err := api.db.QueryContext(tctx, `SELECT distinct(address) FROM event_logs INDEXED BY topic2_partial WHERE topic0 = ? AND topic2 = ? AND topic3 IS NULL LIMIT 1000 OFFSET ?;`, trimPrefix(topic0.Bytes()), trimPrefix(addr.Bytes()), offset)
+	topicClause, params := intern.MatchTopic("topic0", trimPrefix(topic0.Bytes()))
+	rows, err := api.db.QueryContext(tctx, fmt.Sprintf(`SELECT distinct(%v) FROM %v WHERE %v AND topic2 = ? AND topic3 IS NULL LIMIT 1000 OFFSET ?;`, intern.Address("address"), allBlocks.logs("INDEXED BY topic2_partial"), topicClause), append(params, trimPrefix(addr.Bytes()), offset)...)
 	if err != nil {
 		log.Error("Error getting account addresses", "err", err.Error())
 		return nil, err
@@ -99,7 +101,10 @@ func (api *FlumeTokensAPI) Erc20Holders(ctx context.Context, addr common.Address
 
 	topic0 := types.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
 	// topic0 must match ERC20, topic3 must be empty (to exclude ERC721) and topic2 is the recipient address
-	rows, err := api.db.QueryContext(tctx, `SELECT distinct(topic2) FROM logs.event_logs INDEXED BY address_compound WHERE topic0 = ? AND address = ? AND topic3 IS NULL LIMIT 1000 OFFSET ?;`, trimPrefix(topic0.Bytes()), trimPrefix(addr.Bytes()), offset)
+	topicClause, params := intern.MatchTopic("topic0", trimPrefix(topic0.Bytes()))
+	addressClause, addressParams := intern.MatchAddress("address", trimPrefix(addr.Bytes()))
+	params = append(params, addressParams...)
+	rows, err := api.db.QueryContext(tctx, fmt.Sprintf(`SELECT distinct(topic2) FROM %v WHERE %v AND %v AND topic3 IS NULL LIMIT 1000 OFFSET ?;`, allBlocks.logs("INDEXED BY address_compound"), topicClause, addressClause), append(params, offset)...)
 	if err != nil {
 		log.Error("Error getting account addresses", "err", err.Error())
 		return nil, err
@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"math"
 	"context"
 	"database/sql"
 	"math/big"
@@ -16,6 +17,7 @@ import (
 	eh "github.com/openrelayxyz/cardinal-flume/errhandle"
 	"github.com/openrelayxyz/cardinal-flume/heavy"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 )
 
 type GasAPI struct {
@@ -44,7 +46,11 @@ func (api *GasAPI) gasTip(ctx context.Context) (*big.Int, error) {
 	if err != nil {
 		return nil, err
 	}
-	rows, err := api.db.QueryContext(ctx, "SELECT gasPrice, baseFee from transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number WHERE blocks.number > ?;", latestBlock-20)
+	recent := blockRange{0, math.MaxUint64}
+	if latestBlock > 20 {
+		recent.from = uint64(latestBlock - 19)
+	}
+	rows, err := api.db.QueryContext(ctx, fmt.Sprintf("SELECT gasPrice, baseFee from %v INNER JOIN blocks.blocks ON transactions.block = blocks.number WHERE blocks.number > ?;", recent.transactions()), latestBlock-20)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +76,7 @@ func (api *GasAPI) gasTip(ctx context.Context) (*big.Int, error) {
 	}
 	var gasPrice int64
 	var baseFeeBytes []byte
-	err = api.db.QueryRowContext(ctx, "SELECT gasPrice, baseFee from transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number WHERE 1 ORDER BY id DESC LIMIT 1;").Scan(&gasPrice, &baseFeeBytes)
+	err = api.db.QueryRowContext(ctx, fmt.Sprintf("SELECT gasPrice, baseFee from %v INNER JOIN blocks.blocks ON transactions.block = blocks.number WHERE 1 ORDER BY id DESC LIMIT 1;", shards.Table("transactions", "transactions", math.MaxUint64))).Scan(&gasPrice, &baseFeeBytes)
 	return new(big.Int).Sub(big.NewInt(gasPrice), new(big.Int).SetBytes(baseFeeBytes)), err
 }
 
@@ -113,7 +119,7 @@ func (api *GasAPI) GasPrice(ctx context.Context) (string, error) {
 		return "", err
 	}
 	earliestRequiredBlock := latestBlock - 20
-	if earliestRequiredBlock < int64(api.cfg.EarliestBlock) {
+	if earliestRequiredBlock < int64(api.cfg.GetEarliestBlock()) {
 		log.Debug("eth_gasPrince sent to flume heavy")
 		missMeter.Mark(1)
 		gpMissMeter.Mark(1)
@@ -157,7 +163,7 @@ func (api *GasAPI) MaxPriorityFeePerGas(ctx context.Context) (res string, err er
 	}
 	earliestRequiredBlock := latestBlock - 20
 
-	if earliestRequiredBlock < int64(api.cfg.EarliestBlock) {
+	if earliestRequiredBlock < int64(api.cfg.GetEarliestBlock()) {
 		log.Debug("eth_MaxPriorityFeePerGas sent to flume heavy")
 		missMeter.Mark(1)
 		mpfgMissMeter.Mark(1)
@@ -232,7 +238,7 @@ func (api *GasAPI) FeeHistory(ctx context.Context, blockCount DecimalOrHex, term
 
 	earliestBlockInCall := (int64(lastBlock) - int64(blockCount) + 1)
 
-	if (earliestBlockInCall) < int64(api.cfg.EarliestBlock) {
+	if (earliestBlockInCall) < int64(api.cfg.GetEarliestBlock()) {
 		log.Debug("eth_feeHistory sent to flume heavy")
 		missMeter.Mark(1)
 		gfhMissMeter.Mark(1)
@@ -277,7 +283,7 @@ func (api *GasAPI) FeeHistory(ctx context.Context, blockCount DecimalOrHex, term
 		lastGasLimit = gasLimit.Int64
 		if len(rewardPercentiles) > 0 {
 			tips := sortGasAndReward{}
-			txRows := eh.CheckAndAssign(api.db.QueryContext(ctx, "SELECT gasPrice, gasUsed FROM transactions.transactions WHERE block = ?;", number))
+			txRows := eh.CheckAndAssign(api.db.QueryContext(ctx, fmt.Sprintf("SELECT gasPrice, gasUsed FROM %v WHERE block = ?;", shards.Table("transactions", "transactions", number)), number))
 			for txRows.Next() {
 				var gasPrice, txGasUsed uint64
 				eh.Check(txRows.Scan(&gasPrice, &txGasUsed))
@@ -202,6 +202,19 @@ func TestCallHeavy(t *testing.T) {
 		t.Fatal("GetLogs did not return expected parameter address, heavy test", "err", err.Error())
 	}
 
+	splitFilterQuery := FilterQuery{
+		FromBlock: &testBlockNumber,
+		Addresses: []common.Address{testAddress},
+	}
+
+	_, err = l.GetLogs(context.Background(), splitFilterQuery)
+	if err == nil {
+		t.Fatal("GetLogs did not return expected error for split range, heavy test")
+	}
+	if toBlock := err.(*heavy.MockError).Params[0].(FilterQuery).ToBlock; toBlock == nil || uint64(*toBlock) != cfg.GetEarliestBlock()-1 {
+		t.Fatal("GetLogs did not limit the heavy range to blocks before EarliestBlock, heavy test", "err", err.Error())
+	}
+
 	g := NewGasAPI(db, 1, pl, cfg, mempool)
 
 	var blockCount DecimalOrHex = 0x1
@@ -228,7 +241,7 @@ func TestCallHeavy(t *testing.T) {
 
 	f := NewFlumeAPI(db, 1, pl, cfg, mempool)
 
-	_, err = f.GetTransactionsBySender(context.Background(), testAddress, nil)
+	_, err = f.GetTransactionsBySender(context.Background(), testAddress, nil, nil)
 	if err == nil {
 		t.Fatal("GetTransactionsBySender did not return expected error, heavy test", "err", err.Error())
 	}
@@ -238,11 +251,11 @@ func TestCallHeavy(t *testing.T) {
 	if err.(*heavy.MockError).Params[0].(common.Address) != testAddress {
 		t.Fatal("GetTransactionsBySender did not return expected parameter address, heavy test", "err", err.Error())
 	}
-	if err.(*heavy.MockError).Params[1].(*int) != nil {
+	if err.(*heavy.MockError).Params[1].(*paginationToken) != nil {
 		t.Fatal("GetTransactionsBySender did not return expected parameter offset, heavy test", "err", err.Error())
 	}
 
-	_, err = f.GetTransactionReceiptsBySender(context.Background(), testAddress, nil)
+	_, err = f.GetTransactionReceiptsBySender(context.Background(), testAddress, nil, nil)
 	if err == nil {
 		t.Fatal("GetTransactionReceiptsBySender did not return expected error, heavy test", "err", err.Error())
 	}
@@ -252,11 +265,11 @@ func TestCallHeavy(t *testing.T) {
 	if err.(*heavy.MockError).Params[0].(common.Address) != testAddress {
 		t.Fatal("GetTransactionReceiptsBySender did not return expected parameter address, heavy test", "err", err.Error())
 	}
-	if err.(*heavy.MockError).Params[1].(*int) != nil {
+	if err.(*heavy.MockError).Params[1].(*paginationToken) != nil {
 		t.Fatal("GetTransactionReceiptsBySender did not return expected parameter offset, heavy test", "err", err.Error())
 	}
 
-	_, err = f.GetTransactionsByRecipient(context.Background(), testAddress, nil)
+	_, err = f.GetTransactionsByRecipient(context.Background(), testAddress, nil, nil)
 	if err == nil {
 		t.Fatal("GetTransactionsByRecipient did not return expected error, heavy test", "err", err.Error())
 	}
@@ -266,11 +279,11 @@ func TestCallHeavy(t *testing.T) {
 	if err.(*heavy.MockError).Params[0].(common.Address) != testAddress {
 		t.Fatal("GetTransactionsByRecipient did not return expected parameter address, heavy test", "err", err.Error())
 	}
-	if err.(*heavy.MockError).Params[1].(*int) != nil {
+	if err.(*heavy.MockError).Params[1].(*paginationToken) != nil {
 		t.Fatal("GetTransactionsByRecipient did not return expected parameter offset, heavy test", "err", err.Error())
 	}
 
-	_, err = f.GetTransactionReceiptsByRecipient(context.Background(), testAddress, nil)
+	_, err = f.GetTransactionReceiptsByRecipient(context.Background(), testAddress, nil, nil)
 	if err == nil {
 		t.Fatal("GetTransactionReceiptsByRecipient did not return expected error, heavy test", "err", err.Error())
 	}
@@ -280,11 +293,11 @@ func TestCallHeavy(t *testing.T) {
 	if err.(*heavy.MockError).Params[0].(common.Address) != testAddress {
 		t.Fatal("GetTransactionReceiptsByRecipient did not return expected parameter address, heavy test", "err", err.Error())
 	}
-	if err.(*heavy.MockError).Params[1].(*int) != nil {
+	if err.(*heavy.MockError).Params[1].(*paginationToken) != nil {
 		t.Fatal("GetTransactionReceiptsByRecipient did not return expected parameter offset, heavy test", "err", err.Error())
 	}
 
-	_, err = f.GetTransactionsByParticipant(context.Background(), testAddress, nil)
+	_, err = f.GetTransactionsByParticipant(context.Background(), testAddress, nil, nil)
 	if err == nil {
 		t.Fatal("GetTransactionsByParticipant did not return expected error, heavy test", "err", err.Error())
 	}
@@ -294,11 +307,11 @@ func TestCallHeavy(t *testing.T) {
 	if err.(*heavy.MockError).Params[0].(common.Address) != testAddress {
 		t.Fatal("GetTransactionsByParticipant did not return expected parameter address, heavy test", "err", err.Error())
 	}
-	if err.(*heavy.MockError).Params[1].(*int) != nil {
+	if err.(*heavy.MockError).Params[1].(*paginationToken) != nil {
 		t.Fatal("GetTransactionsByParticipant did not return expected parameter offset, heavy test", "err", err.Error())
 	}
 
-	_, err = f.GetTransactionReceiptsByParticipant(context.Background(), testAddress, nil)
+	_, err = f.GetTransactionReceiptsByParticipant(context.Background(), testAddress, nil, nil)
 	if err == nil {
 		t.Fatal("GetTransactionReceiptsByParticipant did not return expected error, heavy test", "err", err.Error())
 	}
@@ -308,7 +321,7 @@ func TestCallHeavy(t *testing.T) {
 	if err.(*heavy.MockError).Params[0].(common.Address) != testAddress {
 		t.Fatal("GetTransactionReceiptsByParticipant did not return expected parameter address, heavy test", "err", err.Error())
 	}
-	if err.(*heavy.MockError).Params[1].(*int) != nil {
+	if err.(*heavy.MockError).Params[1].(*paginationToken) != nil {
 		t.Fatal("GetTransactionReceiptsByParticipant did not return expected parameter offset, heavy test", "err", err.Error())
 	}
 
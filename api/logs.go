@@ -9,11 +9,13 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-rpc"
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-types/hexutil"
 	"github.com/openrelayxyz/cardinal-types/metrics"
 	"github.com/openrelayxyz/cardinal-flume/config"
 	"github.com/openrelayxyz/cardinal-flume/heavy"
+	"github.com/openrelayxyz/cardinal-flume/intern"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
 )
 
@@ -34,8 +36,9 @@ func NewLogsAPI(db *sql.DB, network uint64, pl *plugins.PluginLoader, cfg *confi
 }
 
 var (
-	glgHitMeter  = metrics.NewMinorMeter("/flume/glg/hit")
-	glgMissMeter = metrics.NewMinorMeter("/flume/glg/miss")
+	glgHitMeter   = metrics.NewMinorMeter("/flume/glg/hit")
+	glgMissMeter  = metrics.NewMinorMeter("/flume/glg/miss")
+	glgSplitMeter = metrics.NewMinorMeter("/flume/glg/split")
 )
 
 func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType, error) {
@@ -52,26 +55,49 @@ func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType,
 	indexClause := ""
 	params := []interface{}{}
 	var goHeavy bool
+	var heavyLogs []*logType
+	blocks := allBlocks
 	if crit.BlockHash != nil {
 		var num int64
 		api.db.QueryRowContext(ctx, "SELECT number FROM blocks WHERE hash = ?", crit.BlockHash.Bytes()).Scan(&num)
 		whereClause = append(whereClause, "blockHash = ? AND block = ?")
 		goHeavy = (num == 0)
 		params = append(params, trimPrefix(crit.BlockHash.Bytes()), num)
+		blocks = blockRange{uint64(num), uint64(num)}
 	} else {
 		var fromBlock, toBlock int64
+		// The pruner may advance the earliest block, so the split point is
+		// read once for the whole request.
+		earliest := api.cfg.GetEarliestBlock()
 		if crit.FromBlock == nil || int64(*crit.FromBlock) < 0 {
 			fromBlock = latestBlock
 		} else {
 			fromBlock = int64(*crit.FromBlock)
 		}
-		goHeavy = (uint64(fromBlock) < api.cfg.EarliestBlock)
+		goHeavy = (uint64(fromBlock) < earliest)
 
 		if crit.ToBlock == nil || int64(*crit.ToBlock) < 0 {
 			toBlock = latestBlock
 		} else {
 			toBlock = int64(*crit.ToBlock)
 		}
+		if goHeavy && len(api.cfg.HeavyServer) > 0 && uint64(toBlock) >= earliest {
+			// Only the part of the range older than EarliestBlock is fetched
+			// from the heavy server, the rest is served locally.
+			log.Debug("eth_getLogs split between flume heavy and flume light", "earliest", earliest)
+			glgSplitMeter.Mark(1)
+			heavyCrit := crit
+			heavyTo := rpc.BlockNumber(earliest - 1)
+			heavyCrit.ToBlock = &heavyTo
+			logs, err := heavy.CallHeavy[[]*logType](ctx, api.cfg.HeavyServer, "eth_getLogs", heavyCrit)
+			if err != nil {
+				return nil, err
+			}
+			heavyLogs = *logs
+			fromBlock = int64(earliest)
+			goHeavy = false
+		}
+		blocks = blockRange{uint64(fromBlock), uint64(toBlock)}
 		if fromBlock == toBlock {
 			whereClause = append(whereClause, "block = ?")
 			params = append(params, fromBlock)
@@ -98,10 +124,49 @@ func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType,
 		glgHitMeter.Mark(1)
 	}
 
+	filterClause, filterParams := logsFilterClause(crit)
+	whereClause = append(whereClause, filterClause...)
+	params = append(params, filterParams...)
+	query := fmt.Sprintf("SELECT %v FROM %v WHERE %v;", logColumns(), blocks.logs(indexClause), strings.Join(whereClause, " AND "))
+	pluginMethods := api.pl.Lookup("AppendBorLogs", func(v interface{}) bool {
+		_, ok := v.(func(string, string, []interface{}) (string, []interface{}))
+		return ok
+	})
+	for _, fni := range pluginMethods {
+		fn := fni.(func(string, string, []interface{}) (string, []interface{}))
+		borQuery, borParams := fn(indexClause, strings.Join(whereClause, " AND "), params) 
+			query = borQuery
+			params = borParams
+	}
+	logs, err := queryLogs(ctx, api.db, query, params, false)
+	if err != nil || heavyLogs == nil {
+		return logs, err
+	}
+	// The heavy results all precede EarliestBlock, so appending the local
+	// results keeps them ordered.
+	logs = append(heavyLogs, logs...)
+	if len(logs) > 10000 && len(heavyLogs) > 0 && len(heavyLogs) < len(logs) {
+		return nil, fmt.Errorf("query returned more than 10,000 results spanning multiple blocks")
+	}
+	return logs, nil
+}
+
+// logColumns returns the columns queryLogs reads from event_logs. It is built
+// per query, as interning is only configured once the databases are open.
+func logColumns() string {
+	return fmt.Sprintf("%v, %v, topic1, topic2, topic3, data, block, transactionHash, transactionIndex, blockHash, logIndex", intern.Address("address"), intern.Topic("topic0"))
+}
+
+// logsFilterClause returns the conditions on event_logs matching the address
+// and topic criteria of crit.
+func logsFilterClause(crit FilterQuery) ([]string, []interface{}) {
+	whereClause := []string{}
+	params := []interface{}{}
 	addressClause := []string{}
 	for _, address := range crit.Addresses {
-		addressClause = append(addressClause, fmt.Sprintf("%vaddress = ?", badAddressValues[address]))
-		params = append(params, trimPrefix(address.Bytes()))
+		clause, clauseParams := intern.MatchAddress(badAddressValues[address]+"address", trimPrefix(address.Bytes()))
+		addressClause = append(addressClause, clause)
+		params = append(params, clauseParams...)
 	}
 	if len(addressClause) > 0 {
 		whereClause = append(whereClause, fmt.Sprintf("(%v)", strings.Join(addressClause, " OR ")))
@@ -110,8 +175,14 @@ func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType,
 	for i, topics := range crit.Topics {
 		topicClause := []string{}
 		for _, topic := range topics {
-			topicClause = append(topicClause, fmt.Sprintf("topic%v = ?", i))
-			params = append(params, trimPrefix(topic.Bytes()))
+			if i == 0 {
+				clause, clauseParams := intern.MatchTopic("topic0", trimPrefix(topic.Bytes()))
+				topicClause = append(topicClause, clause)
+				params = append(params, clauseParams...)
+			} else {
+				topicClause = append(topicClause, fmt.Sprintf("topic%v = ?", i))
+				params = append(params, trimPrefix(topic.Bytes()))
+			}
 		}
 		if len(topicClause) > 0 {
 			topicsClause = append(topicsClause, fmt.Sprintf("(%v)", strings.Join(topicClause, " OR ")))
@@ -122,17 +193,14 @@ func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType,
 	if len(topicsClause) > 0 {
 		whereClause = append(whereClause, fmt.Sprintf("(%v)", strings.Join(topicsClause, " AND ")))
 	}
-	query := fmt.Sprintf("SELECT address, topic0, topic1, topic2, topic3, data, block, transactionHash, transactionIndex, blockHash, logIndex FROM event_logs %v WHERE %v;", indexClause, strings.Join(whereClause, " AND "))
-	pluginMethods := api.pl.Lookup("AppendBorLogs", func(v interface{}) bool {
-		_, ok := v.(func(string, string, []interface{}) (string, []interface{}))
-		return ok
-	})
-	for _, fni := range pluginMethods {
-		fn := fni.(func(string, string, []interface{}) (string, []interface{}))
-		borQuery, borParams := fn(indexClause, strings.Join(whereClause, " AND "), params) 
-			query = borQuery
-			params = borParams
-	}
+	return whereClause, params
+}
+
+// queryLogs runs a query selecting address, topic0, topic1, topic2, topic3,
+// data, block, transactionHash, transactionIndex, blockHash and logIndex, and
+// returns the resulting logs in order. removed marks logs from blocks that are
+// no longer canonical.
+func queryLogs(ctx context.Context, db *sql.DB, query string, params []interface{}, removed bool) ([]*logType, error) {
 	doneCh := make(chan struct{})
 	defer func() { close(doneCh) }()
 	go func() {
@@ -142,7 +210,7 @@ func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType,
 			log.Warn("Query taking > 5 seconds", "query", query, "params", params)
 		}
 	}()
-	rows, err := api.db.QueryContext(ctx, query, params...)
+	rows, err := db.QueryContext(ctx, query, params...)
 	if err != nil {
 		log.Error("Error selecting query", "query", query, "err", err.Error())
 		return nil, err
@@ -189,6 +257,7 @@ func (api *LogsAPI) GetLogs(ctx context.Context, crit FilterQuery) ([]*logType,
 			TxIndex:     hexutil.Uint(transactionIndex),
 			BlockHash:   bytesToHash(blockHash),
 			Index:       hexutil.Uint(logIndex),
+			Removed:     removed,
 		})
 		if len(logs) > 10000 && len(blockNumbersInResponse) > 1 {
 			// handleError("query returned more than 10,000 results spanning multiple blocks", call.ID, 413)
@@ -0,0 +1,410 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+)
+
+const (
+	pageSize      = 1000
+	cursorVersion = 1
+)
+
+var errInvalidToken = errors.New("invalid pagination token")
+
+// paginationToken is the token accepted by the paginated flume methods. It is
+// either an opaque cursor string issued by a previous page, or the integer
+// offset issued by earlier versions of flume.
+type paginationToken struct {
+	offset *int
+	cursor *txCursor
+}
+
+// txCursor identifies the last transaction returned. Confirmed transactions
+// are paged in (block, transactionIndex) order and pending transactions in
+// hash order. Pending transactions follow the confirmed transactions when
+// listing oldest first, and precede them when listing newest first.
+type txCursor struct {
+	block   int64
+	index   int64
+	pending *types.Hash
+}
+
+func (c *txCursor) String() string {
+	data := make([]byte, 17, 49)
+	data[0] = cursorVersion
+	binary.BigEndian.PutUint64(data[1:9], uint64(c.block))
+	binary.BigEndian.PutUint64(data[9:17], uint64(c.index))
+	if c.pending != nil {
+		data = append(data, c.pending.Bytes()...)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseCursor(token string) (*txCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || (len(data) != 17 && len(data) != 49) || data[0] != cursorVersion {
+		return nil, errInvalidToken
+	}
+	c := &txCursor{
+		block: int64(binary.BigEndian.Uint64(data[1:9])),
+		index: int64(binary.BigEndian.Uint64(data[9:17])),
+	}
+	if len(data) == 49 {
+		hash := types.BytesToHash(data[17:])
+		c.pending = &hash
+	}
+	return c, nil
+}
+
+func (t *paginationToken) UnmarshalJSON(input []byte) error {
+	var offset int
+	if err := json.Unmarshal(input, &offset); err == nil {
+		t.offset = &offset
+		return nil
+	}
+	var token string
+	if err := json.Unmarshal(input, &token); err != nil {
+		return errInvalidToken
+	}
+	c, err := parseCursor(token)
+	if err != nil {
+		return err
+	}
+	t.cursor = c
+	return nil
+}
+
+func (t *paginationToken) MarshalJSON() ([]byte, error) {
+	if t.offset != nil {
+		return json.Marshal(*t.offset)
+	}
+	if t.cursor != nil {
+		return json.Marshal(t.cursor.String())
+	}
+	return []byte("null"), nil
+}
+
+// txPosition returns the block number and transaction index of a confirmed
+// transaction or receipt.
+func txPosition(item map[string]interface{}) (int64, int64, error) {
+	var block, index int64
+	switch v := item["blockNumber"].(type) {
+	case *hexutil.Big:
+		block = v.ToInt().Int64()
+	case hexutil.Uint64:
+		block = int64(v)
+	default:
+		return 0, 0, fmt.Errorf("unexpected blockNumber type %T", v)
+	}
+	switch v := item["transactionIndex"].(type) {
+	case *hexutil.Uint64:
+		index = int64(*v)
+	case hexutil.Uint64:
+		index = int64(v)
+	default:
+		return 0, 0, fmt.Errorf("unexpected transactionIndex type %T", v)
+	}
+	return block, index, nil
+}
+
+// historyOptions are the optional filters accepted by the address history
+// methods.
+type historyOptions struct {
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+	Order     string           `json:"order"`  // "asc" (default) or "desc"
+	Status    string           `json:"status"` // "success" or "failed"
+	PageSize  int              `json:"pageSize"`
+}
+
+// historyQuery is an address history request resolved against the local
+// database.
+type historyQuery struct {
+	whereClause   string
+	params        []interface{}
+	pendingClause string
+	pendingParams []interface{}
+	desc          bool
+	pageSize      int
+	pending       bool
+	local         bool
+	blocks        blockRange
+}
+
+func (api *FlumeAPI) newHistoryQuery(ctx context.Context, opts *historyOptions, whereClause string, params ...interface{}) (*historyQuery, error) {
+	q := &historyQuery{
+		pendingClause: fmt.Sprintf("(%v)", whereClause),
+		pendingParams: params,
+		pageSize:      pageSize,
+		pending:       true,
+		blocks:        allBlocks,
+	}
+	if opts == nil {
+		opts = &historyOptions{}
+	}
+	clauses := []string{fmt.Sprintf("(%v)", whereClause)}
+	q.params = append(q.params, params...)
+	switch strings.ToLower(opts.Order) {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %v", opts.Order)
+	}
+	switch strings.ToLower(opts.Status) {
+	case "":
+	case "success":
+		clauses = append(clauses, "transactions.status = 1")
+		q.pending = false
+	case "failed":
+		clauses = append(clauses, "transactions.status = 0")
+		q.pending = false
+	default:
+		return nil, fmt.Errorf("invalid status %v", opts.Status)
+	}
+	if opts.PageSize < 0 || opts.PageSize > pageSize {
+		return nil, fmt.Errorf("pageSize must be between 1 and %v", pageSize)
+	} else if opts.PageSize > 0 {
+		q.pageSize = opts.PageSize
+	}
+	var latestBlock int64
+	if (opts.FromBlock != nil && *opts.FromBlock < 0) || (opts.ToBlock != nil && *opts.ToBlock < 0) {
+		var err error
+		if latestBlock, err = getLatestBlock(ctx, api.db); err != nil {
+			log.Error("Error retrieving latest block", "err", err.Error())
+			return nil, err
+		}
+	}
+	if opts.FromBlock != nil {
+		fromBlock := int64(*opts.FromBlock)
+		if fromBlock < 0 {
+			fromBlock = latestBlock
+		}
+		clauses = append(clauses, "transactions.block >= ?")
+		q.params = append(q.params, fromBlock)
+		q.local = uint64(fromBlock) >= api.cfg.GetEarliestBlock()
+		q.blocks.from = uint64(fromBlock)
+	}
+	if opts.ToBlock != nil && *opts.ToBlock >= 0 {
+		clauses = append(clauses, "transactions.block <= ?")
+		q.params = append(q.params, int64(*opts.ToBlock))
+		q.blocks.to = uint64(*opts.ToBlock)
+		q.pending = false
+	}
+	q.whereClause = strings.Join(clauses, " AND ")
+	return q, nil
+}
+
+func (q *historyQuery) order() string {
+	if q.desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// confirmed returns the condition and parameters selecting confirmed
+// transactions after c in the query's order.
+func (q *historyQuery) confirmed(c *txCursor) (string, []interface{}) {
+	comparison := ">"
+	if q.desc {
+		comparison = "<"
+	}
+	return fmt.Sprintf("%v AND (transactions.block, transactions.transactionIndex) %v (?, ?)", q.whereClause, comparison), append(append([]interface{}{}, q.params...), c.block, c.index)
+}
+
+// remaining returns the blocks left to read after c in the query's order.
+func (q *historyQuery) remaining(c *txCursor) blockRange {
+	r := q.blocks
+	if q.desc {
+		if c.block >= 0 && uint64(c.block) < r.to {
+			r.to = uint64(c.block)
+		}
+	} else if c.block > 0 && uint64(c.block) > r.from {
+		r.from = uint64(c.block)
+	}
+	return r
+}
+
+func (q *historyQuery) cursor(token *paginationToken) *txCursor {
+	if token != nil && token.cursor != nil {
+		return token.cursor
+	}
+	if q.desc {
+		return &txCursor{block: math.MaxInt64, index: math.MaxInt64}
+	}
+	return &txCursor{block: -1, index: -1}
+}
+
+// heavyHistoryParams returns the parameters to forward an address history
+// request to the heavy server, omitting the options when none were given.
+func heavyHistoryParams(address common.Address, token *paginationToken, opts *historyOptions) []interface{} {
+	if opts == nil {
+		return []interface{}{address, token}
+	}
+	return []interface{}{address, token, opts}
+}
+
+func reverseItems(items []map[string]interface{}) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
+
+// getConfirmedPage returns up to limit confirmed transactions after c in
+// the query's order, along with the cursor of the last one.
+func (api *FlumeAPI) getConfirmedPage(ctx context.Context, q *historyQuery, c *txCursor, limit int) ([]map[string]interface{}, *txCursor, error) {
+	whereClause, params := q.confirmed(c)
+	txs, err := getFlumeTransactions(ctx, api.db, q.remaining(c), 0, limit, q.order(), api.network, whereClause, params...)
+	if err != nil {
+		log.Error("Error getting txs", "err", err.Error())
+		return nil, nil, err
+	}
+	if q.desc {
+		reverseItems(txs)
+	}
+	next := &txCursor{block: c.block, index: c.index, pending: c.pending}
+	if len(txs) > 0 {
+		if next.block, next.index, err = txPosition(txs[len(txs)-1]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return txs, next, nil
+}
+
+// getPendingPage returns up to limit pending transactions after c, along
+// with the cursor of the last one.
+func (api *FlumeAPI) getPendingPage(ctx context.Context, q *historyQuery, c *txCursor, limit int) ([]map[string]interface{}, *txCursor, error) {
+	whereClause, params := q.pendingClause, q.pendingParams
+	if c.pending != nil {
+		whereClause += " AND transactions.hash > ?"
+		params = append(append([]interface{}{}, params...), trimPrefix(c.pending.Bytes()))
+	}
+	txs, err := getPendingTransactions(ctx, api.db, api.mempool, 0, limit, api.network, whereClause, params...)
+	if err != nil {
+		log.Error("Error getting pending txs", "err", err.Error())
+		return nil, nil, err
+	}
+	next := &txCursor{block: c.block, index: c.index, pending: c.pending}
+	if len(txs) > 0 {
+		hash, ok := txs[len(txs)-1]["hash"].(types.Hash)
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected pending transaction hash")
+		}
+		next.pending = &hash
+	}
+	return txs, next, nil
+}
+
+// getTransactionsPage returns a page of the confirmed and pending
+// transactions matching q, starting after token.
+func (api *FlumeAPI) getTransactionsPage(ctx context.Context, token *paginationToken, q *historyQuery) (*paginator[map[string]interface{}], error) {
+	if token != nil && token.offset != nil {
+		return api.getTransactionsOffset(ctx, *token.offset, q)
+	}
+	c := q.cursor(token)
+	result := paginator[map[string]interface{}]{Items: []map[string]interface{}{}}
+	// When listing newest first, pending transactions are listed until the
+	// first confirmed transaction has been returned.
+	if q.desc && q.pending && c.block == math.MaxInt64 {
+		txs, next, err := api.getPendingPage(ctx, q, c, q.pageSize)
+		if err != nil {
+			return nil, err
+		}
+		result.Items, c = append(result.Items, txs...), next
+	}
+	if remaining := q.pageSize - len(result.Items); remaining > 0 {
+		txs, next, err := api.getConfirmedPage(ctx, q, c, remaining)
+		if err != nil {
+			return nil, err
+		}
+		result.Items, c = append(result.Items, txs...), next
+	}
+	if remaining := q.pageSize - len(result.Items); remaining > 0 && !q.desc && q.pending {
+		txs, next, err := api.getPendingPage(ctx, q, c, remaining)
+		if err != nil {
+			return nil, err
+		}
+		result.Items, c = append(result.Items, txs...), next
+	}
+	if len(result.Items) >= q.pageSize {
+		result.Token = c.String()
+	}
+	return &result, nil
+}
+
+// getTransactionsOffset serves requests made with a legacy integer token.
+func (api *FlumeAPI) getTransactionsOffset(ctx context.Context, offset int, q *historyQuery) (*paginator[map[string]interface{}], error) {
+	ctxs, err := getFlumeTransactions(ctx, api.db, q.blocks, offset, q.pageSize, q.order(), api.network, q.whereClause, q.params...)
+	if err != nil {
+		log.Error("Error getting txs", "err", err.Error())
+		return nil, err
+	}
+	if q.desc {
+		reverseItems(ctxs)
+	}
+	if q.pending {
+		txs, err := getPendingTransactions(ctx, api.db, api.mempool, offset, q.pageSize, api.network, q.pendingClause, q.pendingParams...)
+		if err != nil {
+			log.Error("Error getting pending txs", "err", err.Error())
+			return nil, err
+		}
+		ctxs = append(ctxs, txs...)
+	}
+	result := paginator[map[string]interface{}]{Items: ctxs}
+	if len(ctxs) >= q.pageSize {
+		result.Token = offset + len(ctxs)
+	}
+	return &result, nil
+}
+
+// getReceiptsPage returns a page of the receipts matching q, starting after
+// token.
+func (api *FlumeAPI) getReceiptsPage(ctx context.Context, token *paginationToken, q *historyQuery) (*paginator[map[string]interface{}], error) {
+	if token != nil && token.offset != nil {
+		receipts, err := getFlumeTransactionReceipts(ctx, api.db, q.blocks, *token.offset, q.pageSize, q.order(), api.network, q.whereClause, q.params...)
+		if err != nil {
+			log.Error("Error getting receipts", "err", err.Error())
+			return nil, err
+		}
+		if q.desc {
+			reverseItems(receipts)
+		}
+		result := paginator[map[string]interface{}]{Items: receipts}
+		if len(receipts) == q.pageSize {
+			result.Token = *token.offset + len(receipts)
+		}
+		return &result, nil
+	}
+	c := q.cursor(token)
+	whereClause, params := q.confirmed(c)
+	receipts, err := getFlumeTransactionReceipts(ctx, api.db, q.remaining(c), 0, q.pageSize, q.order(), api.network, whereClause, params...)
+	if err != nil {
+		log.Error("Error getting receipts", "err", err.Error())
+		return nil, err
+	}
+	if q.desc {
+		reverseItems(receipts)
+	}
+	result := paginator[map[string]interface{}]{Items: receipts}
+	if len(receipts) == q.pageSize {
+		next := &txCursor{}
+		if next.block, next.index, err = txPosition(receipts[len(receipts)-1]); err != nil {
+			return nil, err
+		}
+		result.Token = next.String()
+	}
+	return &result, nil
+}
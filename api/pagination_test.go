@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-flume/config"
+)
+
+func TestPaginationToken(t *testing.T) {
+	var legacy paginationToken
+	if err := json.Unmarshal([]byte("1000"), &legacy); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if legacy.offset == nil || *legacy.offset != 1000 || legacy.cursor != nil {
+		t.Errorf("unexpected legacy token %+v", legacy)
+	}
+	hash := types.HexToHash("0x01")
+	for _, c := range []*txCursor{{block: 12, index: 3}, {block: -1, index: -1, pending: &hash}} {
+		data, err := json.Marshal(c.String())
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		var token paginationToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if token.cursor == nil || token.cursor.block != c.block || token.cursor.index != c.index || (c.pending != nil) != (token.cursor.pending != nil) {
+			t.Errorf("cursor %s did not round trip, got %+v", data, token.cursor)
+		}
+		if c.pending != nil && *token.cursor.pending != *c.pending {
+			t.Errorf("unexpected pending hash %v", token.cursor.pending)
+		}
+	}
+	var invalid paginationToken
+	if err := json.Unmarshal([]byte(`"abc"`), &invalid); err == nil {
+		t.Errorf("expected invalid token error")
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	api := &FlumeAPI{cfg: &config.Config{EarliestBlock: 100}}
+	from, to := rpc.BlockNumber(150), rpc.BlockNumber(200)
+	q, err := api.newHistoryQuery(context.Background(), &historyOptions{FromBlock: &from, ToBlock: &to, Order: "desc", Status: "failed", PageSize: 10}, "sender = ?", "a")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if q.whereClause != "(sender = ?) AND transactions.status = 0 AND transactions.block >= ? AND transactions.block <= ?" || len(q.params) != 3 {
+		t.Errorf("unexpected where clause %v %v", q.whereClause, q.params)
+	}
+	if !q.local || !q.desc || q.pending || q.pageSize != 10 || q.order() != "DESC" {
+		t.Errorf("unexpected query %+v", q)
+	}
+	from = 50
+	if q, err = api.newHistoryQuery(context.Background(), &historyOptions{FromBlock: &from}, "sender = ?", "a"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if q.local || !q.pending || q.pageSize != pageSize {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if q, _ := api.newHistoryQuery(context.Background(), nil, "sender = ?", "a"); q.local {
+		t.Errorf("queries without a fromBlock should not be served locally")
+	}
+	if _, err := api.newHistoryQuery(context.Background(), &historyOptions{Order: "sideways"}, "sender = ?", "a"); err == nil {
+		t.Errorf("expected error for invalid order")
+	}
+}
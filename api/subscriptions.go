@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	evm "github.com/openrelayxyz/cardinal-evm/types"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-flume/txfeed"
+)
+
+// subscriptionBuffer is the number of notifications held for a subscriber
+// before it is considered too slow and the subscription is closed.
+const subscriptionBuffer = 256
+
+var (
+	subscriptionMeter     = metrics.NewMinorMeter("/flume/subscription/notifications")
+	subscriptionDropMeter = metrics.NewMinorMeter("/flume/subscription/dropped")
+)
+
+type SubscriptionAPI struct {
+	db        *sql.DB
+	network   uint64
+	cfg       *config.Config
+	chainFeed *indexer.ChainFeed
+	txFeed    *txfeed.TxFeed
+
+	lock        sync.Mutex
+	subscribers map[*chainSubscriber]struct{}
+}
+
+// chainSubscriber is a newHeads subscription if heads is set, and a logs
+// subscription matching crit otherwise.
+type chainSubscriber struct {
+	heads chan map[string]interface{}
+	logs  chan *logType
+	crit  FilterQuery
+	done  chan struct{}
+}
+
+func NewSubscriptionAPI(db *sql.DB, network uint64, cfg *config.Config, chainFeed *indexer.ChainFeed, txFeed *txfeed.TxFeed) *SubscriptionAPI {
+	api := &SubscriptionAPI{
+		db:          db,
+		network:     network,
+		cfg:         cfg,
+		chainFeed:   chainFeed,
+		txFeed:      txFeed,
+		subscribers: make(map[*chainSubscriber]struct{}),
+	}
+	go api.run()
+	return api
+}
+
+// notify delivers item to a subscriber without blocking, so that a slow
+// client cannot hold up delivery to other subscribers. It returns false if
+// the subscriber has fallen too far behind.
+func notify[T any](ch chan T, item T) bool {
+	select {
+	case ch <- item:
+		subscriptionMeter.Mark(1)
+		return true
+	default:
+		subscriptionDropMeter.Mark(1)
+		log.Warn("Subscriber too slow, closing subscription")
+		return false
+	}
+}
+
+// run forwards chain events to subscribers. If run itself falls behind the
+// chain feed, every subscriber is closed, as each of them has missed events.
+func (api *SubscriptionAPI) run() {
+	for {
+		events := make(chan *indexer.ChainEvent, subscriptionBuffer)
+		sub := api.chainFeed.Subscribe(events)
+	loop:
+		for {
+			select {
+			case <-sub.Err():
+				break loop
+			case event := <-events:
+				api.dispatch(event)
+			}
+		}
+		sub.Unsubscribe()
+		log.Warn("Subscriptions fell behind the chain feed, closing subscriptions")
+		api.lock.Lock()
+		for s := range api.subscribers {
+			api.remove(s)
+		}
+		api.lock.Unlock()
+	}
+}
+
+// dispatch looks up the headers and logs for event once, and delivers them to
+// every subscriber. Subscribers that have fallen behind are closed.
+func (api *SubscriptionAPI) dispatch(event *indexer.ChainEvent) {
+	var wantHeads, wantLogs bool
+	api.lock.Lock()
+	for s := range api.subscribers {
+		if s.heads != nil {
+			wantHeads = true
+		} else {
+			wantLogs = true
+		}
+	}
+	api.lock.Unlock()
+	ctx := context.Background()
+	headers := []map[string]interface{}{}
+	if wantHeads {
+		for _, block := range event.Added {
+			blocks, err := getBlocks(ctx, api.db, false, api.network, "hash = ?", trimPrefix(block.Hash.Bytes()))
+			if err != nil {
+				log.Error("Error getting block, eth_subscribe newHeads", "err", err.Error())
+				continue
+			}
+			for _, header := range blocks {
+				for _, key := range []string{"transactions", "uncles", "withdrawals", "size", "totalDifficulty"} {
+					delete(header, key)
+				}
+				headers = append(headers, header)
+			}
+		}
+	}
+	logs := []*logType{}
+	if wantLogs {
+		var err error
+		logs, err = chainEventLogs(ctx, api.db, event, nil, nil)
+		if err != nil {
+			log.Error("Error getting logs, eth_subscribe logs", "err", err.Error())
+		}
+	}
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	for s := range api.subscribers {
+		if s.heads != nil {
+			for _, header := range headers {
+				if !notify(s.heads, header) {
+					api.remove(s)
+					break
+				}
+			}
+			continue
+		}
+		for _, item := range logs {
+			if matchesLogFilter(item, s.crit) && !notify(s.logs, item) {
+				api.remove(s)
+				break
+			}
+		}
+	}
+}
+
+// subscribe adds s to the subscribers until ctx is cancelled.
+func (api *SubscriptionAPI) subscribe(ctx context.Context, s *chainSubscriber) {
+	s.done = make(chan struct{})
+	api.lock.Lock()
+	api.subscribers[s] = struct{}{}
+	api.lock.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			api.lock.Lock()
+			api.remove(s)
+			api.lock.Unlock()
+		case <-s.done:
+		}
+	}()
+}
+
+// remove closes s and drops it from the subscribers. The caller must hold
+// api.lock.
+func (api *SubscriptionAPI) remove(s *chainSubscriber) {
+	if _, ok := api.subscribers[s]; !ok {
+		return
+	}
+	delete(api.subscribers, s)
+	close(s.done)
+	if s.heads != nil {
+		close(s.heads)
+	} else {
+		close(s.logs)
+	}
+}
+
+func (api *SubscriptionAPI) NewHeads(ctx context.Context) (<-chan map[string]interface{}, error) {
+	ch := make(chan map[string]interface{}, subscriptionBuffer)
+	api.subscribe(ctx, &chainSubscriber{heads: ch})
+	return ch, nil
+}
+
+func (api *SubscriptionAPI) Logs(ctx context.Context, crit FilterQuery) (<-chan *logType, error) {
+	ch := make(chan *logType, subscriptionBuffer)
+	api.subscribe(ctx, &chainSubscriber{logs: ch, crit: crit})
+	return ch, nil
+}
+
+func (api *SubscriptionAPI) NewPendingTransactions(ctx context.Context) (<-chan types.Hash, error) {
+	if api.txFeed == nil {
+		return nil, errors.New("pending transactions are not available")
+	}
+	txCh := make(chan *evm.Transaction, subscriptionBuffer)
+	sub := api.txFeed.Subscribe(txCh)
+	ch := make(chan types.Hash, subscriptionBuffer)
+	go func() {
+		defer close(ch)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.Err():
+				return
+			case tx := <-txCh:
+				if !notify(ch, tx.Hash()) {
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// chainEventLogs returns the logs matching filterClause for the blocks in
+// event. Logs from removed blocks are read from the orphaned logs and marked
+// removed, and are returned ahead of the logs from added blocks.
+func chainEventLogs(ctx context.Context, db *sql.DB, event *indexer.ChainEvent, filterClause []string, filterParams []interface{}) ([]*logType, error) {
+	results := []*logType{}
+	for _, source := range []struct {
+		table   func(number int64) string
+		blocks  []indexer.BlockRef
+		removed bool
+	}{
+		{func(int64) string { return "logs.orphaned_event_logs" }, event.Removed, true},
+		{func(number int64) string { return shards.Table("logs", "event_logs", uint64(number)) }, event.Added, false},
+	} {
+		for _, block := range source.blocks {
+			whereClause := append([]string{"blockHash = ? AND block = ?"}, filterClause...)
+			params := append([]interface{}{trimPrefix(block.Hash.Bytes()), block.Number}, filterParams...)
+			query := fmt.Sprintf("SELECT %v FROM %v WHERE %v;", logColumns(), source.table(block.Number), strings.Join(whereClause, " AND "))
+			logs, err := queryLogs(ctx, db, query, params, source.removed)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, logs...)
+		}
+	}
+	return results, nil
+}
+
+// matchesLogFilter reports whether item matches the addresses and topics of
+// crit, in the same way as logsFilterClause. An empty topic position matches
+// any log that has a topic in that position.
+func matchesLogFilter(item *logType, crit FilterQuery) bool {
+	if len(crit.Addresses) > 0 {
+		found := false
+		for _, address := range crit.Addresses {
+			if item.Address == address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for i, topics := range crit.Topics {
+		if i >= len(item.Topics) {
+			return false
+		}
+		if len(topics) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range topics {
+			if item.Topics[i] == topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-types"
+)
+
+func TestMatchesLogFilter(t *testing.T) {
+	item := &logType{Address: common.Address{1}, Topics: []types.Hash{{1}, {2}}}
+	for i, tc := range []struct {
+		crit  FilterQuery
+		match bool
+	}{
+		{FilterQuery{}, true},
+		{FilterQuery{Addresses: []common.Address{{2}, {1}}}, true},
+		{FilterQuery{Addresses: []common.Address{{2}}}, false},
+		{FilterQuery{Topics: [][]types.Hash{{{1}}, {{3}, {2}}}}, true},
+		{FilterQuery{Topics: [][]types.Hash{{}, {{3}}}}, false},
+		{FilterQuery{Topics: [][]types.Hash{{}, {}}}, true},
+		// An empty position still requires the log to have a topic there.
+		{FilterQuery{Topics: [][]types.Hash{{}, {}, {}}}, false},
+	} {
+		if got := matchesLogFilter(item, tc.crit); got != tc.match {
+			t.Errorf("case %v: expected %v, got %v", i, tc.match, got)
+		}
+	}
+}
@@ -44,7 +44,7 @@ var (
 
 func (api *TransactionAPI) GetTransactionByHash(ctx context.Context, txHash types.Hash) (*map[string]interface{}, error) {
 
-	if len(api.cfg.HeavyServer) > 0 && !txDataPresent(txHash, api.cfg, api.db, api.mempool) {
+	if len(api.cfg.HeavyServer) > 0 && !txDataPresent(txHash, api.cfg, api.db, api.mempool) && !orphanedTxPresent(txHash, api.db) {
 		log.Debug("eth_getTransactionByHash sent to flume heavy")
 		missMeter.Mark(1)
 		gtbhMissMeter.Mark(1)
@@ -79,6 +79,13 @@ func (api *TransactionAPI) GetTransactionByHash(ctx context.Context, txHash type
 			return nil, nil
 		}
 	}
+	if len(txs) == 0 && orphanedTxPresent(txHash, api.db) {
+		txs, err = getOrphanedTransactionsBlock(ctx, api.db, 0, 1, api.network, "transactions.hash = ?", trimPrefix(txHash.Bytes()))
+		if err != nil {
+			log.Error("Database error, getOrphanedTransactionsBlock, eth_getTransactionByHash", "err", err)
+			return nil, nil
+		}
+	}
 
 	result := returnSingleTransaction(txs)
 
@@ -120,7 +127,7 @@ func (api *TransactionAPI) GetTransactionByBlockHashAndIndex(ctx context.Context
 	}
 
 	var err error
-	txs, err := getTransactionsBlock(ctx, api.db, 0, 1, api.network, "blocks.hash = ? AND transactionIndex = ?", trimPrefix(blockHash.Bytes()), uint64(index))
+	txs, err := getTransactionsBlock(ctx, api.db, 0, 1, api.network, blockHashClause+" AND transactionIndex = ?", trimPrefix(blockHash.Bytes()), uint64(index))
 	if err != nil {
 		return nil, err
 	}
@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/openrelayxyz/cardinal-evm/common"
 	"github.com/openrelayxyz/cardinal-evm/rlp"
@@ -14,11 +15,12 @@ import (
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-rpc"
 	"github.com/openrelayxyz/cardinal-types/hexutil"
+	"github.com/openrelayxyz/cardinal-flume/codec"
 	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/klauspost/compress/zlib"
-	"io"
 	"io/ioutil"
 	"math/big"
 	"os"
@@ -29,11 +31,32 @@ var (
 	zeroInputError = errors.New("Input must contain non zero characters")
 )
 
+// blockRange bounds the blocks a query reads, so that only the shards holding
+// them are read.
+type blockRange struct {
+	from, to uint64
+}
+
+var allBlocks = blockRange{0, math.MaxUint64}
+
+// blockHashClause matches the transactions of the block with a bound hash. It
+// constrains transactions.block rather than joining on blocks.hash so that
+// SQLite applies it to each shard's txblock index.
+const blockHashClause = "transactions.block = (SELECT number FROM blocks.blocks WHERE hash = ?)"
+
+func (r blockRange) transactions() string {
+	return shards.Source("transactions", "transactions", r.from, r.to, "")
+}
+
+func (r blockRange) logs(hint string) string {
+	return shards.Source("logs", "event_logs", r.from, r.to, hint)
+}
+
 func blockDataPresent(input interface{}, cfg *config.Config, db *sql.DB) bool {
 	present := true
 	switch input.(type) {
 	case rpc.BlockNumber:
-		if uint64(input.(rpc.BlockNumber)) < cfg.EarliestBlock {
+		if uint64(input.(rpc.BlockNumber)) < cfg.GetEarliestBlock() {
 			present = false
 			return present
 		}
@@ -50,10 +73,26 @@ func blockDataPresent(input interface{}, cfg *config.Config, db *sql.DB) bool {
 	return present
 }
 
+// orphanedBlockPresent reports whether a block displaced by a reorg is held
+// locally.
+func orphanedBlockPresent(blockHash types.Hash, db *sql.DB) bool {
+	var response int
+	db.QueryRow("SELECT 1 FROM blocks.orphaned_blocks WHERE hash = ?;", trimPrefix(blockHash.Bytes())).Scan(&response)
+	return response != 0
+}
+
+// orphanedTxPresent reports whether a transaction from a block displaced by a
+// reorg is held locally.
+func orphanedTxPresent(txHash types.Hash, db *sql.DB) bool {
+	var response int
+	db.QueryRow("SELECT 1 FROM transactions.orphaned_transactions WHERE hash = ?;", trimPrefix(txHash.Bytes())).Scan(&response)
+	return response != 0
+}
+
 func txDataPresent(txHash types.Hash, cfg *config.Config, db *sql.DB, mempool bool) bool {
 	var present bool
 	var response int
-	txStatement := "SELECT 1 FROM transactions.transactions WHERE hash = ?;"
+	txStatement := fmt.Sprintf("SELECT 1 FROM %v WHERE hash = ?;", allBlocks.transactions())
 	db.QueryRow(txStatement, trimPrefix(txHash.Bytes())).Scan(&response)
 	if response != 0 {
 		present = true
@@ -91,18 +130,7 @@ func testingJson(fileString string) ([]byte, error) {
 }
 
 func decompress(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return data, nil
-	}
-	r, err := zlib.NewReader(bytes.NewBuffer(data))
-	if err != nil {
-		return []byte{}, err
-	}
-	raw, err := ioutil.ReadAll(r)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return raw, nil
-	}
-	return raw, err
+	return codec.Decompress(data)
 }
 
 func trimPrefix(data []byte) []byte {
@@ -280,15 +308,44 @@ func getTransactionsQuery(ctx context.Context, db *sql.DB, offset, limit int, ch
 }
 
 func getTransactionsBlock(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, transactions.recipient, transactions.transactionIndex, transactions.value, transactions.v, transactions.r, transactions.s, transactions.sender, transactions.type, transactions.access_list, blocks.baseFee, transactions.gasFeeCap, transactions.gasTipCap, transactions.maxFeePerBlobGas, transactions.blobVersionedHashes FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %v ORDER BY transactions.transactionIndex LIMIT ? OFFSET ?;", whereClause)
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, %[3]v, transactions.transactionIndex, transactions.value, transactions.v, transactions.r, transactions.s, %[4]v, transactions.type, transactions.access_list, blocks.baseFee, transactions.gasFeeCap, transactions.gasTipCap, transactions.maxFeePerBlobGas, transactions.blobVersionedHashes FROM %[1]v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %[2]v ORDER BY transactions.transactionIndex LIMIT ? OFFSET ?;", allBlocks.transactions(), whereClause, intern.Address("transactions.recipient"), intern.Address("transactions.sender"))
 	return getTransactionsQuery(ctx, db, offset, limit, chainid, query, params...)
 }
 
+// getOrphanedTransactionsBlock returns transactions from blocks displaced by
+// reorgs, marked as non-canonical.
+func getOrphanedTransactionsBlock(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, %[2]v, transactions.transactionIndex, transactions.value, transactions.v, transactions.r, transactions.s, %[3]v, transactions.type, transactions.access_list, blocks.baseFee, transactions.gasFeeCap, transactions.gasTipCap, transactions.maxFeePerBlobGas, transactions.blobVersionedHashes FROM transactions.orphaned_transactions AS transactions INNER JOIN blocks.orphaned_blocks AS blocks ON blocks.hash = transactions.blockHash WHERE %[1]v ORDER BY transactions.transactionIndex LIMIT ? OFFSET ?;", whereClause, intern.Address("transactions.recipient"), intern.Address("transactions.sender"))
+	txs, err := getTransactionsQuery(ctx, db, offset, limit, chainid, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		tx["canonical"] = false
+	}
+	return txs, nil
+}
+
 var emptyStateTrieHash types.Hash = types.HexToHash("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
 
 
 func getBlocks(ctx context.Context, db *sql.DB, includeTxs bool, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT hash, parentHash, uncleHash, coinbase, root, txRoot, receiptRoot, bloom, difficulty, extra, mixDigest, uncles, td, number, gasLimit, gasUsed, time, nonce, size, baseFee, withdrawalHash, blobGasUsed, excessBlobGas, parentBeaconRoot FROM blocks.blocks WHERE %v;", whereClause)
+	return getBlocksQuery(ctx, db, includeTxs, false, chainid, whereClause, params...)
+}
+
+// getOrphanedBlocks returns blocks displaced by reorgs, marked as non-canonical.
+// Their transactions and withdrawals are looked up by block hash, as the block
+// number now belongs to the canonical chain.
+func getOrphanedBlocks(ctx context.Context, db *sql.DB, includeTxs bool, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
+	return getBlocksQuery(ctx, db, includeTxs, true, chainid, whereClause, params...)
+}
+
+func getBlocksQuery(ctx context.Context, db *sql.DB, includeTxs, orphaned bool, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
+	table := "blocks.blocks"
+	if orphaned {
+		table = "blocks.orphaned_blocks"
+	}
+	query := fmt.Sprintf("SELECT hash, parentHash, uncleHash, coinbase, root, txRoot, receiptRoot, bloom, difficulty, extra, mixDigest, uncles, td, number, gasLimit, gasUsed, time, nonce, size, baseFee, withdrawalHash, blobGasUsed, excessBlobGas, parentBeaconRoot FROM %v WHERE %v;", table, whereClause)
 	rows, err := db.QueryContext(ctx, query, params...)
 	if err != nil {
 		return nil, err
@@ -316,6 +373,12 @@ func getBlocks(ctx context.Context, db *sql.DB, includeTxs bool, chainid uint64,
 			// This empty case is used to account for blocks before withdrawals were included
 		case len(withdrawalHashBytes) > 0 && bytesToHash(withdrawalHashBytes) == emptyStateTrieHash:
 			withdrawals = make([]map[string]interface{}, 0)
+		case orphaned:
+			withdrawals, err = getWithdrawalsQuery(ctx, db, "blocks.orphaned_withdrawals", "withdrawals.blockHash = ?", hash)
+			if err != nil {
+				log.Error("Error fetching withdrawals", "err", err.Error())
+				return nil, err
+			}
 		default:
 			withdrawals, err = getWithdrawals(ctx, db, "withdrawals.block = ?", number)
 			if err != nil {
@@ -364,14 +427,24 @@ func getBlocks(ctx context.Context, db *sql.DB, includeTxs bool, chainid uint64,
 		if withdrawals != nil {
 			fields["withdrawals"] = withdrawals
 		}
-		if includeTxs {
+		switch {
+		case includeTxs && orphaned:
+			fields["transactions"], err = getOrphanedTransactionsBlock(ctx, db, 0, 100000, chainid, "transactions.blockHash = ?", hash)
+			if err != nil {
+				return nil, err
+			}
+		case includeTxs:
 			fields["transactions"], err = getTransactionsBlock(ctx, db, 0, 100000, chainid, "transactions.block = ?", number)
 			if err != nil {
 				return nil, err
 			}
-		} else {
+		default:
 			txs := []types.Hash{}
-			txRows, err := db.QueryContext(ctx, "SELECT hash FROM transactions.transactions WHERE block = ? ORDER BY transactionIndex ASC", number)
+			txQuery, txParam := fmt.Sprintf("SELECT hash FROM %v WHERE block = ? ORDER BY transactionIndex ASC", shards.Table("transactions", "transactions", number)), interface{}(number)
+			if orphaned {
+				txQuery, txParam = "SELECT hash FROM transactions.orphaned_transactions WHERE blockHash = ? ORDER BY transactionIndex ASC", hash
+			}
+			txRows, err := db.QueryContext(ctx, txQuery, txParam)
 			if err != nil {
 				return nil, err
 			}
@@ -390,6 +463,9 @@ func getBlocks(ctx context.Context, db *sql.DB, includeTxs bool, chainid uint64,
 		if len(baseFee) > 0 {
 			fields["baseFeePerGas"] = bytesToHexBig(baseFee)
 		}
+		if orphaned {
+			fields["canonical"] = false
+		}
 		results = append(results, fields)
 	}
 	if err := rows.Err(); err != nil {
@@ -403,7 +479,7 @@ func getPendingTransactions(ctx context.Context, db *sql.DB, mempool bool, offse
 	if !mempool {
 		return results, nil
 	} 
-	query := fmt.Sprintf("SELECT transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, transactions.recipient, transactions.value, transactions.v, transactions.r, transactions.s, transactions.sender, transactions.type, transactions.access_list, transactions.gasFeeCap, transactions.gasTipCap FROM mempool.transactions WHERE %v LIMIT ? OFFSET ?;", whereClause)
+	query := fmt.Sprintf("SELECT transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, transactions.recipient, transactions.value, transactions.v, transactions.r, transactions.s, transactions.sender, transactions.type, transactions.access_list, transactions.gasFeeCap, transactions.gasTipCap FROM mempool.transactions WHERE %v ORDER BY transactions.hash LIMIT ? OFFSET ?;", whereClause)
 	rows, err := db.QueryContext(ctx, query, append(params, limit, offset)...)
 	if err != nil {
 		return nil, err
@@ -497,11 +573,6 @@ func getPendingTransactions(ctx context.Context, db *sql.DB, mempool bool, offse
 	return results, nil
 }
 
-func getTransactions(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, transactions.recipient, transactions.transactionIndex, transactions.value, transactions.v, transactions.r, transactions.s, transactions.sender, transactions.type, transactions.access_list, blocks.baseFee, transactions.gasFeeCap, transactions.gasTipCap, transactions.blobVersionedHashes FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE transactions.rowid IN (SELECT transactions.rowid FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number WHERE %v) LIMIT ? OFFSET ?;", whereClause)
-	return getTransactionsQuery(ctx, db, offset, limit, chainid, query, params...)
-}
-
 func getTransactionReceiptsQuery(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, query, logsQuery string, params ...interface{}) ([]map[string]interface{}, error) {
 	logRows, err := db.QueryContext(ctx, logsQuery, params...)
 	if err != nil {
@@ -624,36 +695,24 @@ func getTransactionReceiptsQuery(ctx context.Context, db *sql.DB, offset, limit
 	return results, nil
 }
 
-func getTransactionReceipts(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, transactions.recipient, transactions.transactionIndex, transactions.sender, transactions.contractAddress, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE transactions.rowid IN (SELECT transactions.rowid FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number WHERE %v) LIMIT ? OFFSET ?;", whereClause)
-	logsQuery := fmt.Sprintf(`
-		SELECT transactionHash, block, address, topic0, topic1, topic2, topic3, data, logIndex
-		FROM event_logs
-		WHERE (transactionHash, block) IN (
-			SELECT transactions.hash, transactions.block
-			FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number
-			WHERE %v
-		);`, whereClause)
-	return getTransactionReceiptsQuery(ctx, db, offset, limit, chainid, query, logsQuery, params...)
-}
-
 func getTransactionReceiptsBlock(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, transactions.recipient, transactions.transactionIndex, transactions.sender, transactions.contractAddress, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %v ORDER BY transactions.rowid LIMIT ? OFFSET ?;", whereClause)
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, %[3]v, transactions.transactionIndex, %[4]v, %[5]v, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM %[1]v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %[2]v ORDER BY transactions.id LIMIT ? OFFSET ?;", allBlocks.transactions(), whereClause, intern.Address("transactions.recipient"), intern.Address("transactions.sender"), intern.Address("transactions.contractAddress"))
 	logsQuery := fmt.Sprintf(`
-		SELECT event_logs.transactionHash, event_logs.block, event_logs.address, event_logs.topic0, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.data, event_logs.logIndex
-		FROM event_logs
+		SELECT event_logs.transactionHash, event_logs.block, %v, %v, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.data, event_logs.logIndex
+		FROM %v
 		WHERE (transactionHash, block) IN (
 			SELECT transactions.hash, block
-			FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number
+			FROM %v INNER JOIN blocks.blocks ON transactions.block = blocks.number
 			WHERE %v
-		);`, whereClause)
+		);`, intern.Address("event_logs.address"), intern.Topic("event_logs.topic0"), allBlocks.logs(""), allBlocks.transactions(), whereClause)
 	return getTransactionReceiptsQuery(ctx, db, offset, limit, chainid, query, logsQuery, params...)
 }
 
 func getSenderNonce(ctx context.Context, db *sql.DB, sender common.Address, blockNumber rpc.BlockNumber, pending, mempool bool) (hexutil.Uint64, error) {
 	
 	var count sql.NullInt64
-	if err := db.QueryRowContext(ctx, "SELECT max(nonce) FROM transactions.transactions WHERE sender = ? AND block <= ?", trimPrefix(sender.Bytes()), int64(blockNumber)).Scan(&count); err != nil {
+	senderClause, params := intern.MatchAddress("sender", trimPrefix(sender.Bytes()))
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT max(nonce) FROM %v WHERE %v AND block <= ?", allBlocks.transactions(), senderClause), append(params, int64(blockNumber))...).Scan(&count); err != nil {
 		return 0, err
 	}
 
@@ -689,7 +748,7 @@ func returnSingleTransaction(txs []map[string]interface{}) map[string]interface{
 
 func txCount(ctx context.Context, db *sql.DB, whereClause string, params ...interface{}) (hexutil.Uint64, error) {
 	var count uint64
-	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM transactions.transactions WHERE %v", whereClause), params...).Scan(&count)
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %v WHERE %v", allBlocks.transactions(), whereClause), params...).Scan(&count)
 	return hexutil.Uint64(count), err
 }
 
@@ -703,8 +762,8 @@ func returnSingleReceipt(txs []map[string]interface{}) map[string]interface{} {
 	return result
 }
 
-func getFlumeTransactions(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, blocks.time, transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, transactions.recipient, transactions.transactionIndex, transactions.value, transactions.v, transactions.r, transactions.s, transactions.sender, transactions.type, transactions.access_list, blocks.baseFee, transactions.gasFeeCap, transactions.gasTipCap FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %v LIMIT ? OFFSET ?;", whereClause)
+func getFlumeTransactions(ctx context.Context, db *sql.DB, r blockRange, offset, limit int, order string, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, blocks.time, transactions.gas, transactions.gasPrice, transactions.hash, transactions.input, transactions.nonce, %[4]v, transactions.transactionIndex, transactions.value, transactions.v, transactions.r, transactions.s, %[5]v, transactions.type, transactions.access_list, blocks.baseFee, transactions.gasFeeCap, transactions.gasTipCap FROM %[3]v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %[1]v ORDER BY transactions.block %[2]v, transactions.transactionIndex %[2]v LIMIT ? OFFSET ?;", whereClause, order, r.transactions(), intern.Address("transactions.recipient"), intern.Address("transactions.sender"))
 	return getFlumeTransactionsQuery(ctx, db, offset, limit, chainid, query, params...)
 }
 
@@ -801,16 +860,16 @@ func getFlumeTransactionsQuery(ctx context.Context, db *sql.DB, offset, limit in
 return results, nil
 }
 
-func getFlumeTransactionReceipts(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, blocks.time, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, transactions.recipient, transactions.transactionIndex, transactions.sender, transactions.contractAddress, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %v LIMIT ? OFFSET ?;", whereClause)
+func getFlumeTransactionReceipts(ctx context.Context, db *sql.DB, r blockRange, offset, limit int, order string, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, blocks.time, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, %[4]v, transactions.transactionIndex, %[5]v, %[6]v, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM %[3]v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %[1]v ORDER BY transactions.block %[2]v, transactions.transactionIndex %[2]v LIMIT ? OFFSET ?;", whereClause, order, r.transactions(), intern.Address("transactions.recipient"), intern.Address("transactions.sender"), intern.Address("transactions.contractAddress"))
 	logsQuery := fmt.Sprintf(`
-		SELECT transactionHash, block, address, topic0, topic1, topic2, topic3, data, logIndex
-		FROM event_logs
+		SELECT transactionHash, block, %[5]v, %[6]v, topic1, topic2, topic3, data, logIndex
+		FROM %[4]v
 		WHERE (transactionHash, block) IN (
 			SELECT transactions.hash, transactions.block
-			FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number
-			WHERE %v LIMIT ? OFFSET ?
-		);`, whereClause)
+			FROM %[3]v INNER JOIN blocks.blocks ON transactions.block = blocks.number
+			WHERE %[1]v ORDER BY transactions.block %[2]v, transactions.transactionIndex %[2]v LIMIT ? OFFSET ?
+		);`, whereClause, order, r.transactions(), r.logs(""), intern.Address("address"), intern.Topic("topic0"))
 	return getFlumeTransactionReceiptsQuery(ctx, db, offset, limit, chainid, query, logsQuery, params...)
 }
 
@@ -940,21 +999,25 @@ func getFlumeTransactionReceiptsQuery(ctx context.Context, db *sql.DB, offset, l
 }
 
 func getFlumeTransactionReceiptsBlock(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, blocks.time, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, transactions.recipient, transactions.transactionIndex, transactions.sender, transactions.contractAddress, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %v ORDER BY transactions.rowid LIMIT ? OFFSET ?;", whereClause)
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, blocks.time, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, %[3]v, transactions.transactionIndex, %[4]v, %[5]v, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM %[1]v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %[2]v ORDER BY transactions.id LIMIT ? OFFSET ?;", allBlocks.transactions(), whereClause, intern.Address("transactions.recipient"), intern.Address("transactions.sender"), intern.Address("transactions.contractAddress"))
 	logsQuery := fmt.Sprintf(`
-		SELECT event_logs.transactionHash, event_logs.block, event_logs.address, event_logs.topic0, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.data, event_logs.logIndex
-		FROM event_logs
+		SELECT event_logs.transactionHash, event_logs.block, %v, %v, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.data, event_logs.logIndex
+		FROM %v
 		WHERE (transactionHash, block) IN (
 			SELECT transactions.hash, block
-			FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number
+			FROM %v INNER JOIN blocks.blocks ON transactions.block = blocks.number
 			WHERE %v LIMIT ? OFFSET ?
-		);`, whereClause)
+		);`, intern.Address("event_logs.address"), intern.Topic("event_logs.topic0"), allBlocks.logs(""), allBlocks.transactions(), whereClause)
 	return getFlumeTransactionReceiptsQuery(ctx, db, offset, limit, chainid, query, logsQuery, params...)
 
 }
 
 func getWithdrawals(ctx context.Context, db *sql.DB, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT withdrawals.wtdrlIndex, withdrawals.vldtrIndex, withdrawals.address, withdrawals.amount FROM withdrawals WHERE %v;", whereClause)
+	return getWithdrawalsQuery(ctx, db, "withdrawals", whereClause, params...)
+}
+
+func getWithdrawalsQuery(ctx context.Context, db *sql.DB, table, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT withdrawals.wtdrlIndex, withdrawals.vldtrIndex, withdrawals.address, withdrawals.amount FROM %v AS withdrawals WHERE %v;", table, whereClause)
 	rows, err := db.QueryContext(ctx, query, params...)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/snapshot"
+)
+
+// bootstrap prepares databases restored from a snapshot for a light instance.
+// It checks that they end at the manifest's block, records the manifest's
+// offsets for the consumer to resume from, and trims them to the last blocks
+// blocks, keeping everything when blocks is 0.
+func bootstrap(db *sql.DB, cfg *config.Config, manifest *snapshot.Manifest, blocks uint64, prunedTables map[string]string) error {
+	var number uint64
+	var hash []byte
+	if err := db.QueryRowContext(context.Background(), "SELECT number, hash FROM blocks.blocks ORDER BY number DESC LIMIT 1;").Scan(&number, &hash); err != nil {
+		return fmt.Errorf("reading restored head: %w", err)
+	}
+	if number != manifest.Block || types.BytesToHash(hash) != manifest.Hash {
+		return fmt.Errorf("restored head %v (%#x) does not match manifest block %v (%#x)", number, hash, manifest.Block, manifest.Hash)
+	}
+	for _, o := range manifest.Offsets {
+		if _, err := db.Exec("INSERT OR REPLACE INTO blocks.cardinal_offsets(offset, partition, topic) VALUES (?, ?, ?);", o.Offset, o.Partition, o.Topic); err != nil {
+			return err
+		}
+	}
+	if blocks == 0 || blocks >= number {
+		return nil
+	}
+	cutoff := int64(number - blocks + 1)
+	log.Info("Trimming restored databases", "from", cutoff)
+	if err := indexer.NewPruner(db, cfg, &sync.RWMutex{}, prunedTables).DeleteBefore(context.Background(), cutoff); err != nil {
+		return err
+	}
+	databases := snapshot.Databases(cfg.Databases)
+	for schema := range manifest.Files {
+		if _, ok := databases[schema]; !ok {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("VACUUM %v;", schema)); err != nil {
+			return fmt.Errorf("%v: %w", schema, err)
+		}
+	}
+	return nil
+}
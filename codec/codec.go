@@ -0,0 +1,178 @@
+// Package codec compresses the blobs flume stores, such as transaction input,
+// access lists and log data.
+//
+// Blobs were originally zlib streams. Blobs written by another codec start
+// with that codec's marker byte, which is never the first byte of a zlib
+// stream, so Decompress reads rows written by either.
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// ZstdMarker starts blobs compressed with zstd. The frame records the
+	// dictionary it was compressed with, if any.
+	ZstdMarker byte = 0x01
+)
+
+var (
+	mut      sync.RWMutex
+	useZstd  bool
+	encoders = map[string]*zstd.Encoder{}
+	plain    *zstd.Encoder
+	decoder  *zstd.Decoder
+	dicts    = map[uint32][]byte{}
+)
+
+var zlibPool = sync.Pool{
+	New: func() interface{} {
+		return zlib.NewWriter(nil)
+	},
+}
+
+func init() {
+	var err error
+	if plain, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault)); err != nil {
+		panic(err)
+	}
+	if decoder, err = zstd.NewReader(nil); err != nil {
+		panic(err)
+	}
+}
+
+// Configure selects the codec for new blobs: "zlib", the default, or "zstd".
+func Configure(name string) error {
+	mut.Lock()
+	defer mut.Unlock()
+	switch name {
+	case "", "zlib":
+		useZstd = false
+	case "zstd":
+		useZstd = true
+	default:
+		return fmt.Errorf("unknown compression codec %q", name)
+	}
+	return nil
+}
+
+// Zstd reports whether new blobs are compressed with zstd.
+func Zstd() bool {
+	mut.RLock()
+	defer mut.RUnlock()
+	return useZstd
+}
+
+// Register makes dict available for decompression, and for compressing
+// column when it is not empty.
+func Register(id uint32, column string, dict []byte) error {
+	mut.Lock()
+	defer mut.Unlock()
+	if _, ok := dicts[id]; !ok {
+		dicts[id] = dict
+		options := []zstd.DOption{}
+		for id, dict := range dicts {
+			options = append(options, zstd.WithDecoderDictRaw(id, dict))
+		}
+		d, err := zstd.NewReader(nil, options...)
+		if err != nil {
+			return err
+		}
+		decoder.Close()
+		decoder = d
+	}
+	if column == "" {
+		return nil
+	}
+	// The default level ignores raw dictionaries for small inputs, which most
+	// blobs are.
+	e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderDictRaw(id, dict))
+	if err != nil {
+		return err
+	}
+	encoders[column] = e
+	return nil
+}
+
+// HasDictionary reports whether column has a registered dictionary.
+func HasDictionary(column string) bool {
+	mut.RLock()
+	defer mut.RUnlock()
+	_, ok := encoders[column]
+	return ok
+}
+
+// Compress compresses data stored in column, a qualified name such as
+// transactions.input, using its dictionary when compressing with zstd.
+func Compress(column string, data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	mut.RLock()
+	defer mut.RUnlock()
+	if !useZstd {
+		return CompressZlib(data)
+	}
+	return compressZstd(column, data)
+}
+
+// CompressZstd compresses data with zstd regardless of the configured codec.
+func CompressZstd(column string, data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	mut.RLock()
+	defer mut.RUnlock()
+	return compressZstd(column, data)
+}
+
+func compressZstd(column string, data []byte) []byte {
+	e, ok := encoders[column]
+	if !ok {
+		e = plain
+	}
+	return e.EncodeAll(data, []byte{ZstdMarker})
+}
+
+// CompressZlib compresses data with zlib regardless of the configured codec,
+// for blobs such as blooms that are always stored as zlib.
+func CompressZlib(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	compressor := zlibPool.Get().(*zlib.Writer)
+	compressor.Reset(buf)
+	compressor.Write(data)
+	compressor.Close()
+	zlibPool.Put(compressor)
+	return buf.Bytes()
+}
+
+// Decompress reverses Compress for blobs written by any codec.
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if data[0] == ZstdMarker {
+		mut.RLock()
+		defer mut.RUnlock()
+		return decoder.DecodeAll(data[1:], nil)
+	}
+	r, err := zlib.NewReader(bytes.NewBuffer(data))
+	if err != nil {
+		return []byte{}, err
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return raw, nil
+	}
+	return raw, err
+}
@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// samples share a random template, as calls to the same contract do, and
+// differ in their arguments.
+func samples() [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	template := make([]byte, 256)
+	rng.Read(template)
+	out := [][]byte{}
+	for i := 0; i < 200; i++ {
+		sample := append([]byte{}, template...)
+		binary.BigEndian.PutUint64(sample[64:], rng.Uint64())
+		binary.BigEndian.PutUint64(sample[160:], rng.Uint64())
+		out = append(out, sample)
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	defer Configure("zlib")
+	data := samples()[3]
+	legacy := Compress("transactions.input", data)
+	if legacy[0] == ZstdMarker {
+		t.Fatalf("expected zlib by default")
+	}
+	if err := Configure("zstd"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	plain := Compress("test.plain", data)
+	if plain[0] != ZstdMarker {
+		t.Fatalf("expected the zstd marker, got %x", plain[0])
+	}
+	dict := Train(samples(), DictionarySize)
+	if len(dict) == 0 || len(dict) > DictionarySize {
+		t.Fatalf("unexpected dictionary size %v", len(dict))
+	}
+	if err := Register(DictionaryID(dict), "test.dict", dict); err != nil {
+		t.Fatalf(err.Error())
+	}
+	withDict := Compress("test.dict", data)
+	if len(withDict) >= len(plain) {
+		t.Errorf("expected the dictionary to help: %v >= %v", len(withDict), len(plain))
+	}
+	for name, compressed := range map[string][]byte{"zlib": legacy, "zstd": plain, "dictionary": withDict} {
+		raw, err := Decompress(compressed)
+		if err != nil {
+			t.Errorf("%v: %v", name, err.Error())
+		} else if !bytes.Equal(raw, data) {
+			t.Errorf("%v: round trip mismatch", name)
+		}
+	}
+	if out := Compress("test.dict", nil); len(out) != 0 {
+		t.Errorf("expected empty data to stay empty")
+	}
+	if err := Configure("lz4"); err == nil {
+		t.Errorf("expected unknown codecs to be rejected")
+	}
+}
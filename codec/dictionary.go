@@ -0,0 +1,135 @@
+package codec
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+const (
+	segmentSize   = 16
+	segmentStride = 8
+	// DictionarySize is the default size of trained dictionaries.
+	DictionarySize = 32 * 1024
+)
+
+// Columns are the columns compressed with their own dictionary, and the
+// schema that stores it.
+var Columns = map[string]string{
+	"transactions.input":       "transactions",
+	"transactions.access_list": "transactions",
+	"logs.data":                "logs",
+}
+
+// DictionaryID derives the zstd dictionary ID for dict. zstd reserves IDs
+// below 32768, so IDs are above them.
+func DictionaryID(dict []byte) uint32 {
+	return 32768 + crc32.ChecksumIEEE(dict)%(1<<31-32768)
+}
+
+// Train builds a raw dictionary of at most size bytes from the segments that
+// recur across samples. The most frequent segments go last, where zstd finds
+// them at the shortest offsets, and segments that were adjacent in a sample
+// stay adjacent. It returns nil if nothing recurs.
+func Train(samples [][]byte, size int) []byte {
+	type segment struct {
+		count          int
+		sample, offset int
+	}
+	segments := make(map[string]*segment)
+	for i, sample := range samples {
+		seen := make(map[string]struct{})
+		for j := 0; j+segmentSize <= len(sample); j += segmentStride {
+			key := string(sample[j : j+segmentSize])
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			if s, ok := segments[key]; ok {
+				s.count++
+			} else {
+				segments[key] = &segment{1, i, j}
+			}
+		}
+	}
+	keys := make([]string, 0, len(segments))
+	for key, s := range segments {
+		if s.count > 1 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	less := func(a, b *segment) bool {
+		if a.sample != b.sample {
+			return a.sample < b.sample
+		}
+		return a.offset < b.offset
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := segments[keys[i]], segments[keys[j]]
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		return less(a, b)
+	})
+	if len(keys)*segmentSize > size {
+		keys = keys[:size/segmentSize]
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := segments[keys[i]], segments[keys[j]]
+		if a.count != b.count {
+			return a.count < b.count
+		}
+		return less(a, b)
+	})
+	dict := make([]byte, 0, len(keys)*segmentSize)
+	var previous *segment
+	for _, key := range keys {
+		s := segments[key]
+		if previous != nil && previous.count == s.count && previous.sample == s.sample && previous.offset+segmentStride == s.offset {
+			dict = append(dict, key[segmentSize-segmentStride:]...)
+		} else {
+			dict = append(dict, key...)
+		}
+		previous = s
+	}
+	return dict
+}
+
+// Load registers the dictionaries stored in schema's codec_dictionaries
+// table. The newest dictionary for each column is used to compress it.
+func Load(db *sql.DB, schema string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT id, col, dictionary FROM %v.codec_dictionaries ORDER BY created, id;", schema))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var column string
+		var dict []byte
+		if err := rows.Scan(&id, &column, &dict); err != nil {
+			return err
+		}
+		if err := Register(uint32(id), column, dict); err != nil {
+			return fmt.Errorf("registering dictionary %v for %v: %w", id, column, err)
+		}
+	}
+	return rows.Err()
+}
+
+// Store saves dict as the dictionary for column and registers it.
+func Store(db *sql.DB, column string, dict []byte, created int64) (uint32, error) {
+	schema, ok := Columns[column]
+	if !ok {
+		return 0, fmt.Errorf("unknown column %v", column)
+	}
+	id := DictionaryID(dict)
+	if _, err := db.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %v.codec_dictionaries(id, col, dictionary, created) VALUES (?, ?, ?, ?);", schema), id, column, dict, created); err != nil {
+		return 0, err
+	}
+	return id, Register(id, column, dict)
+}
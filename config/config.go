@@ -8,8 +8,10 @@ import (
 	"math/big"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"sync/atomic"
 	log "github.com/inconshreveable/log15"
 	
+	"github.com/openrelayxyz/cardinal-flume/shards"
 	"github.com/openrelayxyz/cardinal-streams/transports"
 	"github.com/openrelayxyz/cardinal-types"
 )
@@ -42,6 +44,7 @@ type broker struct {
 
 type Config struct {
 	Port            int64             `yaml:"port"`
+	WSPort          int64             `yaml:"wsPort"` // websocket port for eth_subscribe, disabled when unset
 	PprofPort       int               `yaml:"pprofPort"`
 	HealthcheckPort int64             `yaml:"healthcheck"`
 	MinSafeBlock    int               `yaml:"minSafeBlock"`
@@ -49,15 +52,22 @@ type Config struct {
 	Chainid         uint64            `yaml:"chainid"`
 	HomesteadBlock  uint64            `yaml:"homesteadBlock"`
 	Eip155Block     uint64            `yaml:"eip155Block"`
-	TxTopic         string            `yaml:"mempoolTopic"`
+	ByzantiumBlock  uint64            `yaml:"byzantiumBlock"` // receipts before this block commit to a state root rather than a status
+	TxTopic         string            `yaml:"mempoolTopic"` // kafka topic of pending transactions, or the eth_subscribe subscription for websocket brokers
 	WhitelistInternal map[uint64]string `yaml:"whitelist"`
 	KafkaRollback   int64             `yaml:"kafkaRollback"`
 	ReorgThreshold  int64             `yaml:"reorgThreshold"`
 	Databases       map[string]string `yaml:"databases"`
+	Shards          map[string][]shards.Shard `yaml:"shards"` // block range shards of the logs and transactions databases
 	MempoolSlots    int               `yaml:"mempoolSize"`
 	MemTxTimeThreshold int64          `yaml:"mempoolTxTime"` //mempool tx expiration in miuntes
+	MempoolWipe     bool              `yaml:"mempoolWipe"` // empty the mempool on startup rather than keeping it across restarts
 	BlockWaitDuration int64           `yaml:"blockWaitDuration"` // number of miliseconds to wait for a block from charon
 	Concurrency     int               `yaml:"concurrency"`
+	FilterTimeout   int64             `yaml:"filterTimeout"` // number of seconds before an unpolled filter is uninstalled
+	PipelineIndexing bool             `yaml:"pipelineIndexing"` // compute statements for the next update while the current one commits
+	GroupCommitAge  int64             `yaml:"groupCommitAge"` // number of seconds behind the head at which updates are grouped into one transaction
+	GroupCommitSize int               `yaml:"groupCommitSize"` // maximum number of updates in a grouped transaction, 1 disables grouping
 	LogLevel        string            `yaml:"loggingLevel"`
 	Plugins         []string          `yaml:"plugins"`
 	PluginDir       string            `yaml:"pluginPath"`
@@ -65,7 +75,26 @@ type Config struct {
 	BrokerParams    []transports.BrokerParams
 	Statsd          *statsdOpts     `yaml:"statsd"`
 	CloudWatch      *cloudwatchOpts `yaml:"cloudwatch"`
-	HeavyServer   	string `yaml:"heavyserver"`
+	HeavyServer   	HeavyServers `yaml:"heavyserver"`
+	HeavyHealthInterval   int64 `yaml:"heavyHealthInterval"` // number of seconds between heavy backend health checks
+	HeavyFailureThreshold int   `yaml:"heavyFailureThreshold"` // consecutive failures before a heavy backend's circuit opens
+	HeavyCooldown         int64 `yaml:"heavyCooldown"` // number of seconds a heavy backend's circuit stays open
+	HeavyBatchWindow      int64 `yaml:"heavyBatchWindow"` // number of milliseconds heavy calls are collected into a batch, -1 disables batching
+	HeavyBatchSize        int   `yaml:"heavyBatchSize"` // maximum number of calls in a heavy batch request
+	RetentionBlocks uint64          `yaml:"retentionBlocks"` // number of recent blocks to keep, older blocks are pruned
+	RetentionAge    int64           `yaml:"retentionAge"` // number of seconds of recent blocks to keep, older blocks are pruned
+	PruneInterval   int64           `yaml:"pruneInterval"` // number of seconds between pruning passes
+	PruneBatchSize  int64           `yaml:"pruneBatchSize"` // number of blocks deleted per pruning transaction
+	AuditInterval   int64           `yaml:"auditInterval"` // number of seconds between gap audits, disabled when unset
+	SnapshotDir     string          `yaml:"snapshotDir"` // directory admin_snapshot writes to
+	AdminAPI        bool            `yaml:"adminApi"` // serve the admin namespace on the admin listener
+	AdminHost       string          `yaml:"adminHost"` // address the admin listener binds to, localhost by default
+	AdminPort       int64           `yaml:"adminPort"` // port of the admin listener, never one of the public ports
+	Compression     string          `yaml:"compression"` // codec for input, access lists and log data: zlib (default) or zstd
+	RecompressInterval int64        `yaml:"recompressInterval"` // number of seconds between zstd recompression batches, disabled when unset
+	InternLogs      bool            `yaml:"internLogs"` // store log addresses and topic0 values as ids in intern tables
+	InternTransactions bool         `yaml:"internTransactions"` // store transaction senders, recipients and contract addresses as ids in the logs database's intern tables
+	InternInterval  int64           `yaml:"internInterval"` // number of seconds between passes converting existing logs and transactions when interning, each running for up to half the interval
 	EarliestBlock 	uint64 
 	LatestBlock   	uint64
 	BaseFeeChangeBlockHeight uint64
@@ -90,18 +119,22 @@ func LoadConfig(fname string) (*Config, error) {
 	case "mainnet", "eth":
 		cfg.HomesteadBlock = 1150000
 		cfg.Eip155Block = 2675000
+		cfg.ByzantiumBlock = 4370000
 		cfg.Chainid = 1
 	case "classic", "etc":
 		cfg.HomesteadBlock = 1150000
 		cfg.Eip155Block = 3000000
+		cfg.ByzantiumBlock = 8772000
 		cfg.Chainid = 61
 	case "ropsten":
 		cfg.HomesteadBlock = 0
 		cfg.Eip155Block = 10
+		cfg.ByzantiumBlock = 1700000
 		cfg.Chainid = 3
 	case "rinkeby":
 		cfg.HomesteadBlock = 1
 		cfg.Eip155Block = 3
+		cfg.ByzantiumBlock = 1035301
 		cfg.Chainid = 4
 	case "goerli":
 		cfg.HomesteadBlock = 0
@@ -176,6 +209,21 @@ func LoadConfig(fname string) (*Config, error) {
 	if cfg.Concurrency == 0 {
 		cfg.Concurrency = 16
 	}
+	if cfg.HeavyHealthInterval == 0 {
+		cfg.HeavyHealthInterval = 10
+	}
+	if cfg.HeavyFailureThreshold == 0 {
+		cfg.HeavyFailureThreshold = 5
+	}
+	if cfg.HeavyCooldown == 0 {
+		cfg.HeavyCooldown = 30
+	}
+	if cfg.HeavyBatchWindow == 0 {
+		cfg.HeavyBatchWindow = 2
+	}
+	if cfg.HeavyBatchSize == 0 {
+		cfg.HeavyBatchSize = 100
+	}
 	if len(cfg.Brokers) == 0 {
 		return nil, errors.New("Config must specify at least one broker")
 	}
@@ -223,6 +271,56 @@ func LoadConfig(fname string) (*Config, error) {
 		cfg.MemTxTimeThreshold = 60
 	}
 
+	if cfg.GroupCommitAge == 0 {
+		cfg.GroupCommitAge = 300
+	}
+
+	if cfg.GroupCommitSize == 0 {
+		cfg.GroupCommitSize = 100
+	}
+
+	if cfg.FilterTimeout == 0 {
+		cfg.FilterTimeout = 300
+	}
+
+	if cfg.Pruning() && len(cfg.HeavyServer) == 0 {
+		return nil, errors.New("retentionBlocks and retentionAge require a heavyserver to serve pruned blocks")
+	}
+
+	if cfg.PruneInterval == 0 {
+		cfg.PruneInterval = 60
+	}
+
+	if cfg.PruneBatchSize == 0 {
+		cfg.PruneBatchSize = 100
+	}
+
+	if cfg.InternInterval == 0 {
+		cfg.InternInterval = 5
+	}
+
+	if cfg.AdminHost == "" {
+		cfg.AdminHost = "127.0.0.1"
+	}
+	if cfg.AdminPort == 0 {
+		cfg.AdminPort = 8001
+	}
+	if cfg.AdminAPI && (cfg.AdminPort == cfg.Port || cfg.AdminPort == cfg.WSPort || cfg.AdminPort == cfg.HealthcheckPort) {
+		return nil, errors.New("adminPort must not be the port, wsPort or healthcheck port")
+	}
+
+	if _, ok := cfg.Databases["logs"]; cfg.InternTransactions && !ok {
+		return nil, errors.New("internTransactions requires the logs database, which holds the intern tables")
+	}
+
+	switch cfg.Compression {
+	case "":
+		cfg.Compression = "zlib"
+	case "zlib", "zstd":
+	default:
+		return nil, fmt.Errorf("unrecognized compression codec %q", cfg.Compression)
+	}
+
 	if cfg.BlockWaitDuration == 0 {
 		cfg.BlockWaitDuration = 200
 		// this value was calculated as roughly the 95th percentile of block processing times on flume light. Heavey instances
@@ -237,6 +335,43 @@ func LoadConfig(fname string) (*Config, error) {
 	return &cfg, nil
 }
 
+// HeavyServers is the list of heavy backends. It may be configured as a single
+// URL or a list of URLs.
+type HeavyServers []string
+
+func (h *HeavyServers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var servers []string
+	if err := unmarshal(&servers); err == nil {
+		*h = servers
+		return nil
+	}
+	var server string
+	if err := unmarshal(&server); err != nil {
+		return err
+	}
+	if server != "" {
+		*h = HeavyServers{server}
+	}
+	return nil
+}
+
+// GetEarliestBlock returns the earliest block this instance serves. Requests for
+// older blocks are routed to the heavy server. It may be read while the pruner
+// advances it.
+func (cfg *Config) GetEarliestBlock() uint64 {
+	return atomic.LoadUint64(&cfg.EarliestBlock)
+}
+
+// SetEarliestBlock updates the earliest block this instance serves.
+func (cfg *Config) SetEarliestBlock(number uint64) {
+	atomic.StoreUint64(&cfg.EarliestBlock, number)
+}
+
+// Pruning reports whether a retention window is configured.
+func (cfg *Config) Pruning() bool {
+	return cfg.RetentionBlocks > 0 || cfg.RetentionAge > 0
+}
+
 var (
 	preForkDenominator = big.NewInt(8)
 	postForkDenominator = big.NewInt(16)
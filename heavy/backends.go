@@ -0,0 +1,261 @@
+package heavy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+var (
+	healthInterval   = 10 * time.Second
+	failureThreshold = 5
+	cooldown         = 30 * time.Second
+
+	pools     = make(map[string]*pool)
+	poolsLock sync.Mutex
+
+	metricName = regexp.MustCompile("[^a-zA-Z0-9]+")
+)
+
+// nonIdempotent lists the methods that must not be retried on another
+// backend after a failure.
+var nonIdempotent = map[string]struct{}{
+	"eth_sendRawTransaction": {},
+}
+
+// Configure sets the health check interval, the number of consecutive
+// failures that open a backend's circuit, and how long the circuit stays
+// open. It should be called before the first call to CallHeavy.
+func Configure(interval time.Duration, threshold int, circuitCooldown time.Duration) {
+	healthInterval = interval
+	failureThreshold = threshold
+	cooldown = circuitCooldown
+}
+
+type backend struct {
+	url       string
+	lock      sync.Mutex
+	healthy   bool
+	failures  int
+	openUntil time.Time
+	probing   bool
+
+	latency gometrics.Timer
+	errors  gometrics.Meter
+	circuit gometrics.Meter
+}
+
+func newBackend(backendURL string) *backend {
+	name := backendURL
+	if u, err := url.Parse(backendURL); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	name = strings.Trim(metricName.ReplaceAllString(name, "_"), "_")
+	return &backend{
+		url:     backendURL,
+		healthy: true,
+		latency: metrics.NewMinorTimer(fmt.Sprintf("/flume/heavy/%v/latency", name)),
+		errors:  metrics.NewMinorMeter(fmt.Sprintf("/flume/heavy/%v/errors", name)),
+		circuit: metrics.NewMinorMeter(fmt.Sprintf("/flume/heavy/%v/circuit", name)),
+	}
+}
+
+// available reports whether requests may be sent to the backend. Once an open
+// circuit's cooldown has passed, a single request is let through to probe the
+// backend.
+func (b *backend) available(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.healthy {
+		return false
+	}
+	if b.failures < failureThreshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *backend) success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *backend) failure() {
+	b.errors.Mark(1)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= failureThreshold {
+		if b.failures == failureThreshold {
+			log.Warn("Heavy backend circuit opened", "backend", b.url)
+		}
+		b.circuit.Mark(1)
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+func (b *backend) setHealthy(healthy bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.healthy != healthy {
+		log.Info("Heavy backend health changed", "backend", b.url, "healthy", healthy)
+	}
+	b.healthy = healthy
+}
+
+// post sends body to the backend and returns the response body. Errors
+// indicate that the backend could not serve the request.
+func (b *backend) post(ctx context.Context, body []byte) ([]byte, error) {
+	start := time.Now()
+	defer b.latency.UpdateSince(start)
+
+	request, _ := http.NewRequestWithContext(ctx, "POST", b.url, bytes.NewReader(body))
+	request.Header.Add("Content-Type", "application/json")
+
+	log.Debug("call heavy request", "method", "POST", "url", b.url, "headers", request.Header)
+
+	resp, err := client.Do(request)
+	if err != nil {
+		log.Error("callHeavy connection error", "backend", b.url, "err", err)
+		return nil, rpc.NewRPCError(-32503, genericError)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		log.Error("callHeavy backend unavailable", "backend", b.url, "status", resp.StatusCode)
+		return nil, rpc.NewRPCError(-32503, genericError)
+	}
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("callHeavy response read error", "backend", b.url, "err", err)
+		return nil, rpc.NewRPCError(-32504, genericError)
+	}
+	return result, nil
+}
+
+// pool balances requests across a set of heavy backends.
+type pool struct {
+	backends []*backend
+	next     uint32
+	batcher  *batcher
+}
+
+func getPool(backendURLs []string) *pool {
+	key := strings.Join(backendURLs, ",")
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+	if p, ok := pools[key]; ok {
+		return p
+	}
+	p := &pool{}
+	p.batcher = newBatcher(p)
+	for _, backendURL := range backendURLs {
+		p.backends = append(p.backends, newBackend(backendURL))
+	}
+	pools[key] = p
+	if healthInterval > 0 {
+		go p.healthCheck()
+	}
+	return p
+}
+
+// healthCheck periodically confirms that each backend can serve
+// eth_blockNumber. A successful check also closes an open circuit.
+func (p *pool) healthCheck() {
+	call, _ := rpc.NewCall("eth_blockNumber")
+	body, _ := json.Marshal(call)
+	for range time.NewTicker(healthInterval).C {
+		for _, b := range p.backends {
+			ctx, cancel := context.WithTimeout(context.Background(), healthInterval)
+			result, err := b.post(ctx, body)
+			cancel()
+			response := &rpc.RawResponse{}
+			if err == nil {
+				err = json.Unmarshal(result, response)
+			}
+			if err == nil && response.Error != nil {
+				err = response.Error
+			}
+			b.setHealthy(err == nil)
+			if err == nil {
+				b.success()
+			}
+		}
+	}
+}
+
+// pick returns the next available backend in round robin order that has not
+// been tried. If every untried backend is unhealthy or has an open circuit,
+// it returns an untried backend anyway rather than failing outright.
+func (p *pool) pick(tried map[*backend]struct{}) *backend {
+	now := time.Now()
+	start := atomic.AddUint32(&p.next, 1)
+	var fallback *backend
+	for i := 0; i < len(p.backends); i++ {
+		b := p.backends[(int(start)+i)%len(p.backends)]
+		if _, ok := tried[b]; ok {
+			continue
+		}
+		if b.available(now) {
+			return b
+		}
+		if fallback == nil {
+			fallback = b
+		}
+	}
+	return fallback
+}
+
+// do sends body to a backend, retrying idempotent methods on the remaining
+// backends when a backend fails.
+func (p *pool) do(ctx context.Context, method string, body []byte) ([]byte, error) {
+	attempts := len(p.backends)
+	if _, ok := nonIdempotent[method]; ok {
+		attempts = 1
+	}
+	tried := make(map[*backend]struct{})
+	var err error
+	for i := 0; i < attempts; i++ {
+		b := p.pick(tried)
+		if b == nil {
+			break
+		}
+		tried[b] = struct{}{}
+		var result []byte
+		result, err = b.post(ctx, body)
+		if err == nil {
+			b.success()
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		b.failure()
+		log.Debug("Retrying heavy call on another backend", "method", method, "failed", b.url)
+	}
+	if err == nil {
+		err = rpc.NewRPCError(-32503, genericError)
+	}
+	return nil, err
+}
@@ -0,0 +1,94 @@
+package heavy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openrelayxyz/cardinal-rpc"
+)
+
+func TestFailover(t *testing.T) {
+	Configure(0, 2, cooldown)
+	var failed, served int64
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&failed, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&served, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer up.Close()
+
+	backends := []string{down.URL, up.URL}
+	for i := 0; i < 10; i++ {
+		result, err := CallHeavy[string](context.Background(), backends, "eth_blockNumber")
+		if err != nil {
+			t.Fatalf("call %v failed: %v", i, err.Error())
+		}
+		if *result != "0x10" {
+			t.Errorf("unexpected result %v", *result)
+		}
+	}
+	if served != 10 {
+		t.Errorf("expected every call to be served, got %v", served)
+	}
+	if failed != 2 {
+		t.Errorf("expected the circuit to open after 2 failures, got %v", failed)
+	}
+
+	if _, err := CallHeavy[string](context.Background(), []string{down.URL}, "eth_sendRawTransaction", "0x"); err == nil {
+		t.Errorf("expected error from unavailable backend")
+	}
+}
+
+func TestBatching(t *testing.T) {
+	Configure(0, failureThreshold, cooldown)
+	ConfigureBatching(20*time.Millisecond, 100)
+	var requests, calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		batch := []*rpc.Call{}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		responses := []*rpc.RawResponse{}
+		for _, call := range batch {
+			atomic.AddInt64(&calls, 1)
+			responses = append(responses, &rpc.RawResponse{Version: "2.0", ID: call.ID, Result: call.Params[0]})
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := CallHeavy[int](context.Background(), []string{server.URL}, "test_echo", i%5)
+			if err != nil {
+				t.Errorf(err.Error())
+				return
+			}
+			if *result != i%5 {
+				t.Errorf("expected %v, got %v", i%5, *result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if requests != 1 {
+		t.Errorf("expected a single batch request, got %v", requests)
+	}
+	if calls != 5 {
+		t.Errorf("expected identical calls to be coalesced, got %v calls", calls)
+	}
+}
@@ -0,0 +1,176 @@
+package heavy
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-rpc"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var (
+	batchWindow = 2 * time.Millisecond
+	batchSize   = 100
+
+	// batchTimeout bounds a batch request, which is not tied to the context
+	// of any one caller.
+	batchTimeout = time.Minute
+
+	batchSizeHist  = metrics.NewMinorHistogram("/flume/heavy/batch/size")
+	coalescedMeter = metrics.NewMinorMeter("/flume/heavy/coalesced")
+)
+
+// ConfigureBatching sets how long calls are collected before being sent as a
+// single batch request, and the largest batch that will be sent. A window of
+// zero sends each call as soon as it is made.
+func ConfigureBatching(window time.Duration, size int) {
+	batchWindow = window
+	batchSize = size
+}
+
+// flight is a call that has been queued or sent to the heavy server. Callers
+// making an identical call while it is in flight share its response.
+type flight struct {
+	call     *rpc.Call
+	key      string
+	done     chan struct{}
+	response *rpc.RawResponse
+	err      error
+}
+
+// batcher coalesces the calls made to a pool within batchWindow into JSON-RPC
+// batch requests.
+type batcher struct {
+	pool    *pool
+	lock    sync.Mutex
+	pending []*flight
+	timer   *time.Timer
+	flights map[string]*flight
+}
+
+func newBatcher(p *pool) *batcher {
+	return &batcher{
+		pool:    p,
+		flights: make(map[string]*flight),
+	}
+}
+
+// call returns the response to call, waiting for it to be sent in a batch or
+// for an identical in-flight call to complete.
+func (b *batcher) call(ctx context.Context, call *rpc.Call) (*rpc.RawResponse, error) {
+	params, _ := json.Marshal(call.Params)
+	key := call.Method + string(params)
+
+	b.lock.Lock()
+	f, ok := b.flights[key]
+	if ok {
+		coalescedMeter.Mark(1)
+	} else {
+		f = &flight{call: call, key: key, done: make(chan struct{})}
+		b.flights[key] = f
+		b.pending = append(b.pending, f)
+		if len(b.pending) >= batchSize || batchWindow <= 0 {
+			b.flushLocked()
+		} else if b.timer == nil {
+			b.timer = time.AfterFunc(batchWindow, b.flush)
+		}
+	}
+	b.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-f.done:
+		return f.response, f.err
+	}
+}
+
+func (b *batcher) flush() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.flushLocked()
+}
+
+func (b *batcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	go b.send(b.pending)
+	b.pending = nil
+}
+
+// send issues flights as a single call or a batch, and delivers each
+// response to the callers waiting on it.
+func (b *batcher) send(flights []*flight) {
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+	batchSizeHist.Update(int64(len(flights)))
+
+	responses, err := b.post(ctx, flights)
+	b.lock.Lock()
+	for _, f := range flights {
+		delete(b.flights, f.key)
+	}
+	b.lock.Unlock()
+	for _, f := range flights {
+		if err != nil {
+			f.err = err
+		} else if response, ok := responses[string(f.call.ID)]; ok {
+			f.response = response
+		} else {
+			log.Error("callHeavy batch response missing", "method", f.call.Method)
+			f.err = rpc.NewRPCError(-32500, genericError)
+		}
+		close(f.done)
+	}
+}
+
+func (b *batcher) post(ctx context.Context, flights []*flight) (map[string]*rpc.RawResponse, error) {
+	var body []byte
+	if len(flights) == 1 {
+		body, _ = json.Marshal(flights[0].call)
+	} else {
+		calls := make([]*rpc.Call, len(flights))
+		for i, f := range flights {
+			calls[i] = f.call
+		}
+		body, _ = json.Marshal(calls)
+	}
+	result, err := b.pool.do(ctx, flights[0].call.Method, body)
+	if err != nil {
+		return nil, err
+	}
+	responses := []*rpc.RawResponse{}
+	if len(flights) == 1 {
+		response := &rpc.RawResponse{}
+		if err := json.Unmarshal(result, response); err != nil {
+			log.Error("callHeavy result unmarshalling error", "err", err)
+			return nil, rpc.NewRPCError(-32500, genericError)
+		}
+		// Some servers omit the id on errors, so a lone response is matched
+		// to the lone call regardless.
+		response.ID = flights[0].call.ID
+		responses = append(responses, response)
+	} else if err := json.Unmarshal(result, &responses); err != nil {
+		// A server that rejects the whole batch responds with a single error.
+		response := &rpc.RawResponse{}
+		if json.Unmarshal(result, response) == nil && response.Error != nil {
+			log.Error("callHeavy batch response error", "err", response.Error)
+			return nil, response.Error
+		}
+		log.Error("callHeavy batch result unmarshalling error", "err", err)
+		return nil, rpc.NewRPCError(-32500, genericError)
+	}
+	byID := make(map[string]*rpc.RawResponse, len(responses))
+	for _, response := range responses {
+		byID[string(response.ID)] = response
+	}
+	return byID, nil
+}
@@ -1,10 +1,8 @@
 package heavy
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"time"
@@ -38,9 +36,9 @@ var client = &http.Client{Transport: &http.Transport{
 	ExpectContinueTimeout: 1 * time.Second,
 }}
 
-func CallHeavy[T any](ctx context.Context, backendURL string, method string, params ...interface{}) (*T, error) {
+func CallHeavy[T any](ctx context.Context, backendURLs []string, method string, params ...interface{}) (*T, error) {
 
-	if backendURL == "mock" {
+	if len(backendURLs) > 0 && backendURLs[0] == "mock" {
 		return nil, &MockError{
 			err:    "mock response",
 			Method: method,
@@ -51,28 +49,25 @@ func CallHeavy[T any](ctx context.Context, backendURL string, method string, par
 	log.Debug("call heavy arg params", "params", params)
 
 	call, _ := rpc.NewCall(method, params...)
-	callBytes, _ := json.Marshal(call)
 
-	request, _ := http.NewRequestWithContext(ctx, "POST", backendURL, bytes.NewReader(callBytes))
-	request.Header.Add("Content-Type", "application/json")
-
-	log.Debug("call heavy request", "method", "POST", "url", backendURL, "headers", request.Header)
-
-	resp, err := client.Do(request)
-	if err != nil {
-		log.Error("callHeavy connection error", "err", err)
-		return nil, rpc.NewRPCError(-32503, genericError)
-	}
-	defer resp.Body.Close()
-	result, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error("callHeavy response read error", "err", err)
-		return nil, rpc.NewRPCError(-32504, genericError)
-	}
-	response := &rpc.RawResponse{}
-	if err := json.Unmarshal(result, &response); err != nil {
-		log.Error("callHeavy result unmarshalling error", "err", err)
-		return nil, rpc.NewRPCError(-32500, genericError)
+	p := getPool(backendURLs)
+	var response *rpc.RawResponse
+	if _, ok := nonIdempotent[method]; ok {
+		callBytes, _ := json.Marshal(call)
+		result, err := p.do(ctx, method, callBytes)
+		if err != nil {
+			return nil, err
+		}
+		response = &rpc.RawResponse{}
+		if err := json.Unmarshal(result, response); err != nil {
+			log.Error("callHeavy result unmarshalling error", "err", err)
+			return nil, rpc.NewRPCError(-32500, genericError)
+		}
+	} else {
+		var err error
+		if response, err = p.batcher.call(ctx, call); err != nil {
+			return nil, err
+		}
 	}
 	if response.Error != nil {
 		log.Error("callHeavy response error", "err", response.Error)
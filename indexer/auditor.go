@@ -0,0 +1,396 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var (
+	auditGapsGauge     = metrics.NewMinorGauge("/flume/audit/gaps")
+	auditCheckedGauge  = metrics.NewMinorGauge("/flume/audit/checked")
+	auditBackfillMeter = metrics.NewMinorMeter("/flume/audit/backfilled")
+	auditFailureMeter  = metrics.NewMinorMeter("/flume/audit/failed")
+)
+
+const (
+	// auditWindow is the number of blocks scanned for gaps per pass.
+	auditWindow = 10000
+	// backfillBatch is the number of missing blocks committed per transaction.
+	backfillBatch = 100
+)
+
+// rangeDelete matches the statements indexers emit to clear a block and
+// everything after it before inserting it.
+var rangeDelete = regexp.MustCompile(`(?i)^(\s*DELETE FROM \S+ WHERE \w+) >= `)
+
+// Auditor periodically scans the indexed blocks for gaps: missing blocks, and
+// blocks that used gas or have a non-empty bloom but hold no transactions or
+// logs. Missing blocks are fetched with cardinal_streamsBlock from the
+// websocket broker and run through the live indexers.
+//
+// The audit starts from the earliest block and advances a window at a time,
+// stopping short of the reorg threshold so it never races the data feed.
+// A window is only passed once all of its gaps have been filled.
+//
+// Each pass first re-indexes blocks queued in blocks.reindex_queue, such as
+// those that failed verification against their headers.
+type Auditor struct {
+	db         *sql.DB
+	cfg        *config.Config
+	mut        *sync.RWMutex
+	indexers   []Indexer
+	fetch      func(context.Context, int64) (*delivery.PendingBatch, error)
+	next       int64
+	hasTx      bool
+	hasLogs    bool
+	hasQueue   bool
+	emptyBloom []byte
+}
+
+// NewAuditor returns an auditor that commits backfilled blocks under mut, the
+// lock held by ProcessDataFeed. The indexers run alongside the data feed, so
+// they must be safe for concurrent use, as the core indexers are. It fails if
+// no websocket broker is configured.
+func NewAuditor(db *sql.DB, cfg *config.Config, mut *sync.RWMutex, indexers []Indexer) (*Auditor, error) {
+	wsURL := websocketBroker(cfg)
+	if wsURL == "" {
+		return nil, fmt.Errorf("the gap auditor requires a websocket broker")
+	}
+	a := newAuditor(db, cfg, mut, indexers)
+	a.fetch = (&streamsClient{url: wsURL}).fetch
+	return a, nil
+}
+
+func newAuditor(db *sql.DB, cfg *config.Config, mut *sync.RWMutex, indexers []Indexer) *Auditor {
+	return &Auditor{
+		db:         db,
+		cfg:        cfg,
+		mut:        mut,
+		indexers:   indexers,
+		hasTx:      hasTable(db, "transactions", "transactions"),
+		hasLogs:    hasTable(db, "logs", "event_logs"),
+		hasQueue:   hasTable(db, "blocks", "reindex_queue"),
+		emptyBloom: compress(make([]byte, 256)),
+	}
+}
+
+// Run audits every interval until ctx is cancelled.
+func (a *Auditor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.audit(ctx); err != nil && ctx.Err() == nil {
+				auditFailureMeter.Mark(1)
+				log.Warn("Gap audit failed", "from", a.next, "err", err.Error())
+			}
+		}
+	}
+}
+
+func (a *Auditor) audit(ctx context.Context) error {
+	var head sql.NullInt64
+	if err := a.db.QueryRowContext(ctx, "SELECT max(number) FROM blocks.blocks;").Scan(&head); err != nil {
+		return err
+	}
+	if !head.Valid {
+		return nil
+	}
+	if a.hasQueue {
+		if err := a.drainQueue(ctx); err != nil && ctx.Err() == nil {
+			auditFailureMeter.Mark(1)
+			log.Warn("Re-indexing queued blocks failed", "err", err.Error())
+		}
+	}
+	if earliest := int64(a.cfg.GetEarliestBlock()); a.next < earliest {
+		a.next = earliest
+	}
+	to := head.Int64 - a.cfg.ReorgThreshold
+	if to > a.next+auditWindow-1 {
+		to = a.next + auditWindow - 1
+	}
+	if to < a.next {
+		return nil
+	}
+	missing, err := a.gaps(ctx, a.next, to)
+	if err != nil {
+		return err
+	}
+	auditGapsGauge.Update(int64(len(missing)))
+	if len(missing) > 0 {
+		log.Info("Gaps found", "from", a.next, "to", to, "blocks", len(missing), "first", missing[0])
+	}
+	for len(missing) > 0 {
+		n := backfillBatch
+		if n > len(missing) {
+			n = len(missing)
+		}
+		if err := a.backfill(ctx, missing[:n], false); err != nil {
+			return err
+		}
+		auditBackfillMeter.Mark(int64(n))
+		missing = missing[n:]
+	}
+	a.next = to + 1
+	auditCheckedGauge.Update(to)
+	return nil
+}
+
+// gaps returns the blocks from through to that are missing from blocks,
+// transactions or logs, in ascending order.
+func (a *Auditor) gaps(ctx context.Context, from, to int64) ([]int64, error) {
+	found := make(map[int64]struct{})
+	rows, err := a.db.QueryContext(ctx, "SELECT number FROM blocks.blocks WHERE number >= ? AND number <= ? ORDER BY number;", from, to)
+	if err != nil {
+		return nil, err
+	}
+	expected := from
+	for rows.Next() {
+		var number int64
+		if err := rows.Scan(&number); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for ; expected < number; expected++ {
+			found[expected] = struct{}{}
+		}
+		expected = number + 1
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for ; expected <= to; expected++ {
+		found[expected] = struct{}{}
+	}
+	type gapQuery struct {
+		query string
+		args  []interface{}
+	}
+	queries := []gapQuery{}
+	if a.hasTx {
+		queries = append(queries, gapQuery{
+			fmt.Sprintf("SELECT number FROM blocks.blocks WHERE number >= ? AND number <= ? AND gasUsed > 0 AND NOT EXISTS (SELECT 1 FROM %v WHERE block = blocks.number);", shards.Source("transactions", "transactions", uint64(from), uint64(to), "")),
+			[]interface{}{from, to},
+		})
+	}
+	if a.hasLogs {
+		queries = append(queries, gapQuery{
+			fmt.Sprintf("SELECT number FROM blocks.blocks WHERE number >= ? AND number <= ? AND bloom != ? AND NOT EXISTS (SELECT 1 FROM %v WHERE block = blocks.number);", shards.Source("logs", "event_logs", uint64(from), uint64(to), "")),
+			[]interface{}{from, to, a.emptyBloom},
+		})
+	}
+	for _, q := range queries {
+		rows, err := a.db.QueryContext(ctx, q.query, q.args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var number int64
+			if err := rows.Scan(&number); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found[number] = struct{}{}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	missing := make([]int64, 0, len(found))
+	for number := range found {
+		missing = append(missing, number)
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	return missing, nil
+}
+
+// backfillStatements adapts the statements an indexer emits for pb, which
+// clear pb's block and every block after it, to clear only pb's block.
+func backfillStatements(statements []Statement) []Statement {
+	for i, s := range statements {
+		statements[i].Query = rangeDelete.ReplaceAllString(s.Query, "${1} = ")
+	}
+	return statements
+}
+
+// checkLink verifies that pb is the parent of the stored next block and,
+// unless replace is set, matches the stored block of the same number, if
+// either is present.
+func checkLink(ctx context.Context, db *sql.DB, pb *delivery.PendingBatch, replace bool) error {
+	var hash, parentHash []byte
+	if !replace {
+		db.QueryRowContext(ctx, "SELECT hash FROM blocks.blocks WHERE number = ?;", pb.Number).Scan(&hash)
+	}
+	if len(hash) > 0 && types.BytesToHash(hash) != pb.Hash {
+		return fmt.Errorf("block %v from the broker has hash %#x, indexed block has %#x", pb.Number, pb.Hash, hash)
+	}
+	db.QueryRowContext(ctx, "SELECT parentHash FROM blocks.blocks WHERE number = ?;", pb.Number+1).Scan(&parentHash)
+	if len(parentHash) > 0 && types.BytesToHash(parentHash) != pb.Hash {
+		return fmt.Errorf("block %v from the broker is not the parent of indexed block %v", pb.Number, pb.Number+1)
+	}
+	return nil
+}
+
+// fetchStatements fetches number and returns the statements indexers emit for
+// it, adapted to replace only that block. replace allows the stored block to
+// have a different hash.
+func fetchStatements(ctx context.Context, db *sql.DB, fetch func(context.Context, int64) (*delivery.PendingBatch, error), indexers []Indexer, number int64, replace bool) ([]Statement, error) {
+	pb, err := fetch(ctx, number)
+	if err != nil {
+		return nil, fmt.Errorf("fetching block %v: %w", number, err)
+	}
+	if err := checkLink(ctx, db, pb, replace); err != nil {
+		return nil, err
+	}
+	statements, err := indexBatch(indexers, pb)
+	if err != nil {
+		return nil, fmt.Errorf("indexing block %v: %w", number, err)
+	}
+	return backfillStatements(statements), nil
+}
+
+// drainQueue re-indexes a batch of queued blocks. The stored copies are
+// suspect, so they are replaced even if their hashes differ.
+func (a *Auditor) drainQueue(ctx context.Context) error {
+	rows, err := a.db.QueryContext(ctx, "SELECT number FROM blocks.reindex_queue ORDER BY number LIMIT ?;", backfillBatch)
+	if err != nil {
+		return err
+	}
+	queued := []int64{}
+	for rows.Next() {
+		var number int64
+		if err := rows.Scan(&number); err != nil {
+			rows.Close()
+			return err
+		}
+		queued = append(queued, number)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	if err := a.backfill(ctx, queued, true); err != nil {
+		return err
+	}
+	auditBackfillMeter.Mark(int64(len(queued)))
+	return nil
+}
+
+// backfill fetches and indexes numbers, committing them in one transaction.
+// When queued is set they replace the stored blocks and leave the queue.
+func (a *Auditor) backfill(ctx context.Context, numbers []int64, queued bool) error {
+	statements := []Statement{}
+	for _, number := range numbers {
+		s, err := fetchStatements(ctx, a.db, a.fetch, a.indexers, number, queued)
+		if err != nil {
+			return err
+		}
+		statements = append(statements, s...)
+		if queued {
+			statements = append(statements, NewStatement("DELETE FROM blocks.reindex_queue WHERE number = ?", number))
+		}
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	dbtx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer dbtx.Rollback()
+	sc := NewStatementCache(a.db)
+	defer sc.Close()
+	if err := sc.Exec(ctx, dbtx, statements); err != nil {
+		return err
+	}
+	if err := dbtx.Commit(); err != nil {
+		return err
+	}
+	log.Info("Backfilled blocks", "from", numbers[0], "to", numbers[len(numbers)-1], "count", len(numbers))
+	return nil
+}
+
+// websocketBroker returns the URL of the first websocket broker, if any.
+func websocketBroker(cfg *config.Config) string {
+	for _, broker := range cfg.BrokerParams {
+		if strings.HasPrefix(broker.URL, "ws://") || strings.HasPrefix(broker.URL, "wss://") {
+			return broker.URL
+		}
+	}
+	return ""
+}
+
+// streamsClient fetches blocks with cardinal_streamsBlock, holding a websocket
+// connection open between calls.
+type streamsClient struct {
+	url  string
+	conn *websocket.Conn
+	id   int
+}
+
+func (c *streamsClient) fetch(ctx context.Context, number int64) (*delivery.PendingBatch, error) {
+	if c.conn == nil {
+		dialer := &websocket.Dialer{
+			EnableCompression: true,
+			Proxy:             http.ProxyFromEnvironment,
+			HandshakeTimeout:  45 * time.Second,
+		}
+		conn, _, err := dialer.DialContext(ctx, c.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	c.id++
+	msg, err := json.Marshal(message{
+		Id:     c.id,
+		Method: "cardinal_streamsBlock",
+		Params: []string{hexutil.EncodeUint64(uint64(number))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetReadDeadline(deadline)
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(time.Minute))
+	}
+	var result outerResult
+	err = c.conn.WriteMessage(websocket.TextMessage, msg)
+	if err == nil {
+		err = c.conn.ReadJSON(&result)
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	if result.Result == nil || result.Result.Batch == nil {
+		return nil, fmt.Errorf("block not available from the broker")
+	}
+	return result.Result.Batch.ToPendingBatch(), nil
+}
@@ -0,0 +1,113 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types"
+
+	"github.com/openrelayxyz/cardinal-flume/config"
+)
+
+type auditTestIndexer struct{}
+
+func (auditTestIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
+	return nil, nil
+}
+
+func (auditTestIndexer) IndexStatements(pb *delivery.PendingBatch) ([]Statement, error) {
+	return []Statement{
+		NewStatement("DELETE FROM blocks.blocks WHERE number >= ?", pb.Number),
+		NewStatement("INSERT INTO blocks.blocks(number, hash, parentHash) VALUES (?, ?, ?)", pb.Number, pb.Hash, pb.ParentHash),
+	}, nil
+}
+
+func auditTestHash(number int64) types.Hash {
+	return types.HexToHash(fmt.Sprintf("%x", number+1))
+}
+
+func TestAuditor(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openControlDatabase(map[string]string{
+		"control": filepath.Join(dir, "auditor.sqlite"),
+		"blocks":  filepath.Join(dir, "blocks.sqlite"),
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE blocks.blocks (number BIGINT PRIMARY KEY, hash varchar(32), parentHash varchar(32))"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := int64(1); i <= 20; i++ {
+		if i == 5 || i == 6 || i == 12 {
+			continue
+		}
+		h, p := auditTestHash(i), auditTestHash(i-1)
+		if _, err := db.Exec("INSERT INTO blocks.blocks(number, hash, parentHash) VALUES (?, ?, ?)", i, h.Bytes(), p.Bytes()); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	cfg := &config.Config{ReorgThreshold: 2, EarliestBlock: 1}
+	auditor := newAuditor(db, cfg, &sync.RWMutex{}, []Indexer{auditTestIndexer{}})
+	fetched := []int64{}
+	auditor.fetch = func(ctx context.Context, number int64) (*delivery.PendingBatch, error) {
+		fetched = append(fetched, number)
+		hash := auditTestHash(number)
+		if number == 12 && len(fetched) < 4 {
+			hash = types.HexToHash("ff")
+		}
+		return &delivery.PendingBatch{Number: number, Hash: hash, ParentHash: auditTestHash(number - 1)}, nil
+	}
+
+	if err := auditor.audit(context.Background()); err == nil {
+		t.Errorf("expected an error backfilling a block that does not link to its child")
+	}
+	if auditor.next != 1 {
+		t.Errorf("audit advanced to %v despite failing", auditor.next)
+	}
+	if err := auditor.audit(context.Background()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if auditor.next != 19 {
+		t.Errorf("expected audit to continue from 19, got %v", auditor.next)
+	}
+	var count, max int64
+	if err := db.QueryRow("SELECT count(*), max(number) FROM blocks.blocks").Scan(&count, &max); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if count != 20 || max != 20 {
+		t.Errorf("expected blocks 1-20 after backfill, got %v blocks up to %v", count, max)
+	}
+
+	if _, err := db.Exec("CREATE TABLE blocks.reindex_queue (number BIGINT PRIMARY KEY)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	auditor.hasQueue = true
+	if _, err := db.Exec("UPDATE blocks.blocks SET hash = ? WHERE number = 3", types.HexToHash("ff").Bytes()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("INSERT INTO blocks.reindex_queue(number) VALUES (3)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := auditor.audit(context.Background()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var hash []byte
+	if err := db.QueryRow("SELECT hash FROM blocks.blocks WHERE number = 3").Scan(&hash); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if types.BytesToHash(hash) != auditTestHash(3) {
+		t.Errorf("queued block was not replaced, hash %#x", hash)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM blocks.reindex_queue").Scan(&count); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if count != 0 {
+		t.Errorf("expected the queue to be drained, %v blocks remain", count)
+	}
+}
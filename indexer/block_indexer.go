@@ -56,7 +56,30 @@ func NewBlockIndexer(chainid uint64) Indexer {
 	return &BlockIndexer{chainid: chainid}
 }
 
+// batchTime returns the timestamp from the header in pb, or nil if the batch
+// does not carry a header.
+func batchTime(pb *delivery.PendingBatch, chainid uint64) *time.Time {
+	headerBytes, ok := pb.Values[fmt.Sprintf("c/%x/b/%x/h", chainid, pb.Hash.Bytes())]
+	if !ok {
+		return nil
+	}
+	header := &evm.Header{}
+	if err := rlp.DecodeBytes(headerBytes, &header); err != nil {
+		return nil
+	}
+	bt := time.Unix(int64(header.Time), 0)
+	return &bt
+}
+
 func (indexer *BlockIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
+	statements, err := indexer.IndexStatements(pb)
+	if err != nil {
+		return nil, err
+	}
+	return RenderStatements(statements), nil
+}
+
+func (indexer *BlockIndexer) IndexStatements(pb *delivery.PendingBatch) ([]Statement, error) {
 	var withdrawals evm.Withdrawals 
     if withdrawalBytes, ok := pb.Values[fmt.Sprintf("c/%x/b/%x/w", indexer.chainid, pb.Hash.Bytes())]; ok {
 		if err := rlp.DecodeBytes(withdrawalBytes, &withdrawals); err != nil {
@@ -71,9 +94,6 @@ func (indexer *BlockIndexer) Index(pb *delivery.PendingBatch) ([]string, error)
 	if err := rlp.DecodeBytes(headerBytes, &header); err != nil {
 		return nil, err
 	}
-	bt := time.Unix(int64(header.Time), 0)
-	blockTime = &bt
-
 	eblock := &extblock{
 		Header: header,
 		Txs:    []evm.Transaction{},
@@ -127,15 +147,15 @@ func (indexer *BlockIndexer) Index(pb *delivery.PendingBatch) ([]string, error)
 		uncles[int(i)] = v
 	}
 	uncleRLP, _ := rlp.EncodeToBytes(uncles)
-	statements := []string{
-		ApplyParameters("DELETE FROM blocks WHERE number >= %v", pb.Number), 
-		ApplyParameters("DELETE FROM withdrawals WHERE block >= %v", pb.Number),
+	statements := []Statement{
+		NewStatement("DELETE FROM blocks WHERE number >= ?", pb.Number), 
+		NewStatement("DELETE FROM withdrawals WHERE block >= ?", pb.Number),
 	}
 	
 	if withdrawals.Len() > 0 {
 		for _, wtdrl := range withdrawals {
-			statements = append(statements, ApplyParameters(
-			"INSERT INTO withdrawals(wtdrlIndex, vldtrIndex, address, amount, block, blockHash) VALUES (%v, %v, %v, %v, %v, %v)",
+			statements = append(statements, NewStatement(
+			"INSERT INTO withdrawals(wtdrlIndex, vldtrIndex, address, amount, block, blockHash) VALUES (?, ?, ?, ?, ?, ?)",
 			wtdrl.Index,
 			wtdrl.Validator,
 			trimPrefix(wtdrl.Address[:]),
@@ -144,8 +164,8 @@ func (indexer *BlockIndexer) Index(pb *delivery.PendingBatch) ([]string, error)
 			pb.Hash,))
 		}
 	}
-	statements = append(statements, ApplyParameters(
-		"INSERT INTO blocks(number, hash, parentHash, uncleHash, coinbase, root, txRoot, receiptRoot, bloom, difficulty, gasLimit, gasUsed, `time`, extra, mixDigest, nonce, uncles, size, td, baseFee, withdrawalHash, blobGasUsed, excessBlobGas, parentBeaconRoot) VALUES (%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v)",
+	statements = append(statements, NewStatement(
+		"INSERT INTO blocks(number, hash, parentHash, uncleHash, coinbase, root, txRoot, receiptRoot, bloom, difficulty, gasLimit, gasUsed, `time`, extra, mixDigest, nonce, uncles, size, td, baseFee, withdrawalHash, blobGasUsed, excessBlobGas, parentBeaconRoot) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		pb.Number,
 		pb.Hash,
 		pb.ParentHash,
@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var chainFeedDropMeter = metrics.NewMinorMeter("/flume/chainfeed/dropped")
+
+// ErrChainFeedLagging is delivered on the Err channel of a subscription that
+// was dropped because its channel was full when an event was sent.
+var ErrChainFeedLagging = errors.New("chain feed subscriber fell behind")
+
+// BlockRef identifies a block by number and hash.
+type BlockRef struct {
+	Number int64
+	Hash   types.Hash
+}
+
+// ChainEvent describes the blocks a committed update added to and removed
+// from the canonical chain. Blocks that were added and then replaced within
+// the same commit appear in neither list.
+type ChainEvent struct {
+	Added   []BlockRef
+	Removed []BlockRef
+}
+
+// ChainFeed delivers a ChainEvent to subscribers after each commit in
+// ProcessDataFeed. Delivery never blocks the commit loop: a subscriber whose
+// channel is full misses the event, so it is unsubscribed and receives
+// ErrChainFeedLagging on its Err channel.
+type ChainFeed struct {
+	lock sync.Mutex
+	subs map[*chainSubscription]struct{}
+}
+
+type chainSubscription struct {
+	feed *ChainFeed
+	ch   chan<- *ChainEvent
+	err  chan error
+	once sync.Once
+}
+
+func (s *chainSubscription) Err() <-chan error {
+	return s.err
+}
+
+func (s *chainSubscription) Unsubscribe() {
+	s.close(nil)
+}
+
+func (s *chainSubscription) close(err error) {
+	s.once.Do(func() {
+		s.feed.lock.Lock()
+		delete(s.feed.subs, s)
+		s.feed.lock.Unlock()
+		if err != nil {
+			s.err <- err
+		}
+		close(s.err)
+	})
+}
+
+func (f *ChainFeed) Subscribe(ch chan *ChainEvent) types.Subscription {
+	sub := &chainSubscription{feed: f, ch: ch, err: make(chan error, 1)}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[*chainSubscription]struct{})
+	}
+	f.subs[sub] = struct{}{}
+	return sub
+}
+
+func (f *ChainFeed) send(event *ChainEvent) {
+	if f == nil || (len(event.Added) == 0 && len(event.Removed) == 0) {
+		return
+	}
+	lagging := []*chainSubscription{}
+	f.lock.Lock()
+	for sub := range f.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			lagging = append(lagging, sub)
+		}
+	}
+	f.lock.Unlock()
+	for _, sub := range lagging {
+		chainFeedDropMeter.Mark(1)
+		log.Warn("Chain feed subscriber too slow, unsubscribing")
+		sub.close(ErrChainFeedLagging)
+	}
+}
+
+func newChainEvent(added []BlockRef, reorgs []*reorgRecord) *ChainEvent {
+	removed := []BlockRef{}
+	for _, reorg := range reorgs {
+		for i, hash := range reorg.replaced {
+			removed = append(removed, BlockRef{Number: reorg.block + int64(i), Hash: hash})
+		}
+	}
+	addedHashes := make(map[types.Hash]struct{})
+	for _, block := range added {
+		addedHashes[block.Hash] = struct{}{}
+	}
+	removedHashes := make(map[types.Hash]struct{})
+	event := &ChainEvent{Added: []BlockRef{}, Removed: []BlockRef{}}
+	for _, block := range removed {
+		removedHashes[block.Hash] = struct{}{}
+		if _, ok := addedHashes[block.Hash]; !ok {
+			event.Removed = append(event.Removed, block)
+		}
+	}
+	for _, block := range added {
+		if _, ok := removedHashes[block.Hash]; !ok {
+			event.Added = append(event.Added, block)
+		}
+	}
+	return event
+}
@@ -0,0 +1,46 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-types"
+)
+
+func TestNewChainEvent(t *testing.T) {
+	hash := func(b byte) types.Hash { return types.BytesToHash([]byte{b}) }
+	added := []BlockRef{{2, hash(2)}, {3, hash(0x13)}, {4, hash(0x14)}}
+	reorgs := []*reorgRecord{{block: 2, replaced: []types.Hash{hash(2), hash(3)}}}
+	event := newChainEvent(added, reorgs)
+	if len(event.Removed) != 1 || event.Removed[0] != (BlockRef{3, hash(3)}) {
+		t.Errorf("unexpected removed blocks %v", event.Removed)
+	}
+	if len(event.Added) != 2 || event.Added[0] != (BlockRef{3, hash(0x13)}) || event.Added[1] != (BlockRef{4, hash(0x14)}) {
+		t.Errorf("unexpected added blocks %v", event.Added)
+	}
+}
+
+func TestChainFeedLagging(t *testing.T) {
+	feed := &ChainFeed{}
+	fast, slow := make(chan *ChainEvent, 2), make(chan *ChainEvent, 1)
+	fastSub, slowSub := feed.Subscribe(fast), feed.Subscribe(slow)
+	defer fastSub.Unsubscribe()
+	event := &ChainEvent{Added: []BlockRef{{Number: 1}}}
+	// The second event does not fit in the slow subscriber's channel, which
+	// would block the sender.
+	feed.send(event)
+	feed.send(event)
+	if len(fast) != 2 {
+		t.Errorf("expected both events delivered, got %v", len(fast))
+	}
+	if err := <-slowSub.Err(); err != ErrChainFeedLagging {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, ok := <-slowSub.Err(); ok {
+		t.Errorf("expected the lagging subscription's Err channel to be closed")
+	}
+	feed.send(event)
+	if len(slow) != 1 {
+		t.Errorf("event delivered after the subscriber was dropped")
+	}
+	slowSub.Unsubscribe()
+}
@@ -97,10 +97,10 @@ func IndexGenesis(cfg *config.Config, db *sql.DB, indexers []Indexer, mut *sync.
 
 	pb := or.Result.Batch
 
-	genesisStatements := []string{}
+	genesisStatements := []Statement{}
 
 	for _, indexer := range indexers {
-		statements, err := indexer.Index(pb.ToPendingBatch())
+		statements, err := BatchStatements(indexer, pb.ToPendingBatch())
 		if err != nil {
 			log.Error("Error generating statement genesis indexer, on indexer", indexer, "err", err.Error())
 			return err
@@ -114,7 +114,9 @@ func IndexGenesis(cfg *config.Config, db *sql.DB, indexers []Indexer, mut *sync.
 		log.Error("Error creating database transaction genesis indexer", "err", err.Error())
 		return err
 	}
-	if _, err := dbtx.Exec(strings.Join(genesisStatements, " ; ")); err != nil {
+	sc := NewStatementCache(db)
+	defer sc.Close()
+	if err := sc.Exec(context.Background(), dbtx, genesisStatements); err != nil {
 		log.Error("Failed to execute statement genesis indexer", "err", err.Error())
 		return err
 	}
@@ -14,10 +14,10 @@ import (
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-types/hexutil"
 	"github.com/openrelayxyz/cardinal-types/metrics"
+	"github.com/openrelayxyz/cardinal-flume/codec"
 	"github.com/openrelayxyz/cardinal-flume/txfeed"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/klauspost/compress/zlib"
 	"math/big"
 	"os"
 	"strconv"
@@ -37,24 +37,12 @@ func trimPrefix(data []byte) []byte {
 	return v
 }
 
-var compressor *zlib.Writer
-var compressionBuffer = bytes.NewBuffer(make([]byte, 0, 5*1024*1024))
 var blockAgeTimer = metrics.NewMajorTimer("/flume/age")
-var blockTime *time.Time
 
+// compress zlib compresses blobs, such as blooms, that are not stored through
+// a codec column.
 func compress(data []byte) []byte {
-	if len(data) == 0 {
-		return data
-	}
-	compressionBuffer.Reset()
-	if compressor == nil {
-		compressor = zlib.NewWriter(compressionBuffer)
-	} else {
-		compressor.Reset(compressionBuffer)
-	}
-	compressor.Write(data)
-	compressor.Close()
-	return compressionBuffer.Bytes()
+	return codec.CompressZlib(data)
 }
 
 func getCopy(in []byte) []byte {
@@ -81,10 +69,12 @@ type bytesable interface {
 	Bytes() []byte
 }
 
-// applyParameters applies a set of parameters into a SQL statement in a manner
+// ApplyParameters applies a set of parameters into a SQL statement in a manner
 // that will be safe for execution. Note that this should only be used in the
 // context of blocks, transactions, and logs - beyond the datatypes used in
-// those datatypes, safety is not guaranteed.
+// those datatypes, safety is not guaranteed. It is retained for plugins that
+// return raw SQL; new indexers should emit Statements built by NewStatement
+// instead.
 func ApplyParameters(query string, params ...interface{}) string {
 	preparedParams := make([]interface{}, len(params))
 	for i, param := range params {
@@ -189,11 +179,197 @@ func (hc *HealthCheck) Healthy() rpc.HealthStatus {
 	return rpc.Healthy
 }
 
-func ProcessDataFeed(csConsumer transports.Consumer, txFeed *txfeed.TxFeed, db *sql.DB, quit <-chan struct{}, eip155Block, homesteadBlock uint64, mut *sync.RWMutex, mempoolSlots int, indexers []Indexer, hc *HealthCheck, memTxThreshold int64, rhf chan *rpc.HeightRecord, chainid uint64) {
-	heightGauge := metrics.NewMajorGauge("/flume/height")
-	blockTimer  := metrics.NewMajorTimer("/flume/blockProcessingTime")
+// preparedUpdate holds the statements computed for a ChainUpdate, ready to be
+// committed in a single transaction.
+type preparedUpdate struct {
+	statements   []Statement
+	lastBatch    *delivery.PendingBatch
+	blockTime    *time.Time
+	safeNum      *big.Int
+	finalizedNum *big.Int
+	reorgChecks  []*reorgCheck
+	added        []BlockRef
+}
+
+// merge appends next to update, so that both are committed in the same
+// transaction. Offsets from next are written after those from update, leaving
+// cardinal_offsets at the latest resumption point.
+func (update *preparedUpdate) merge(next *preparedUpdate) {
+	for _, check := range next.reorgChecks {
+		check.index += len(update.statements)
+		update.reorgChecks = append(update.reorgChecks, check)
+	}
+	update.statements = append(update.statements, next.statements...)
+	update.added = append(update.added, next.added...)
+	if next.lastBatch != nil {
+		update.lastBatch = next.lastBatch
+		update.blockTime = next.blockTime
+	}
+	if next.safeNum != nil {
+		update.safeNum = next.safeNum
+	}
+	if next.finalizedNum != nil {
+		update.finalizedNum = next.finalizedNum
+	}
+}
+
+// behind reports whether the update is older than maxAge, meaning the feed is
+// still catching up to the head of the chain.
+func (update *preparedUpdate) behind(maxAge time.Duration) bool {
+	return update.blockTime != nil && time.Since(*update.blockTime) > maxAge
+}
+
+// groupCommitWait bounds how long a catch-up group waits for the next update
+// before committing what it has.
+const groupCommitWait = time.Second
+
+// groupUpdates merges subsequent updates into update while the feed is more
+// than maxAge behind the head, up to maxSize updates. next should return nil
+// if no update arrives within groupCommitWait. It returns the number of updates
+// merged.
+func groupUpdates(update *preparedUpdate, next func() *preparedUpdate, maxAge time.Duration, maxSize int) int {
+	count := 1
+	for count < maxSize && update.behind(maxAge) {
+		n := next()
+		if n == nil {
+			break
+		}
+		update.merge(n)
+		count++
+	}
+	return count
+}
+
+// indexBatch runs each indexer against pb concurrently. Statements are returned
+// in the order of the indexers slice regardless of which indexer finishes
+// first, and a given indexer is never run concurrently with itself.
+func indexBatch(indexers []Indexer, pb *delivery.PendingBatch) ([]Statement, error) {
+	results := make([][]Statement, len(indexers))
+	errs := make([]error, len(indexers))
+	var wg sync.WaitGroup
+	for i, idx := range indexers {
+		wg.Add(1)
+		go func(i int, idx Indexer) {
+			defer wg.Done()
+			results[i], errs[i] = BatchStatements(idx, pb)
+			log.Debug("inside indexer loop", "idx", idx, "len", len(results[i]))
+		}(i, idx)
+	}
+	wg.Wait()
+	statements := []Statement{}
+	for i := range indexers {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		statements = append(statements, results[i]...)
+	}
+	return statements, nil
+}
+
+func offsetStatements(pb *delivery.PendingBatch) []Statement {
+	return resumptionStatements(pb.Resumption())
+}
+
+// resumptionStatements records each topic:partition=offset token of
+// resumption in cardinal_offsets.
+func resumptionStatements(resumption string) []Statement {
+	statements := []Statement{}
+	if resumption == "" {
+		return statements
+	}
+	for _, token := range strings.Split(resumption, ";") {
+		parts := strings.Split(token, "=")
+		source, offsetS := parts[0], parts[1]
+		parts = strings.Split(source, ":")
+		topic, partitionS := parts[0], parts[1]
+		offset, err := strconv.Atoi(offsetS)
+		if err != nil {
+			log.Error("offset error", "err", err.Error())
+			continue
+		}
+		partition, err := strconv.Atoi(partitionS)
+		if err != nil {
+			log.Error("partition error", "err", err.Error())
+			continue
+		}
+		statements = append(statements, NewStatement("INSERT OR REPLACE INTO cardinal_offsets(offset, partition, topic) VALUES (?, ?, ?)", offset, partition, topic))
+	}
+	return statements
+}
+
+func prepareUpdate(chainUpdate *delivery.ChainUpdate, indexers []Indexer, chainid uint64) (*preparedUpdate, error) {
 	safeNumKey := fmt.Sprintf("c/%x/n/safe", chainid)
 	finalizedNumKey := fmt.Sprintf("c/%x/n/finalized", chainid)
+	update := &preparedUpdate{statements: []Statement{}}
+	if check := newReorgCheck(0, chainUpdate.Added()); check != nil {
+		update.reorgChecks = append(update.reorgChecks, check)
+	}
+	for _, pb := range chainUpdate.Added() {
+		if v, ok := pb.Values[safeNumKey]; ok {
+			update.safeNum = new(big.Int).SetBytes(v)
+		}
+		if v, ok := pb.Values[finalizedNumKey]; ok {
+			update.finalizedNum = new(big.Int).SetBytes(v)
+		}
+		s, err := indexBatch(indexers, pb)
+		if err != nil {
+			return nil, err
+		}
+		update.statements = append(update.statements, s...)
+		update.lastBatch = pb
+		update.added = append(update.added, BlockRef{Number: pb.Number, Hash: pb.Hash})
+		update.blockTime = batchTime(pb, chainid)
+		if update.blockTime != nil { blockAgeTimer.UpdateSince(*update.blockTime) }
+		update.statements = append(update.statements, offsetStatements(pb)...)
+	}
+	return update, nil
+}
+
+// mustPrepareUpdate retries prepareUpdate until it succeeds, as the update
+// cannot be skipped without leaving a gap in the index.
+func mustPrepareUpdate(chainUpdate *delivery.ChainUpdate, indexers []Indexer, chainid uint64) *preparedUpdate {
+	for {
+		update, err := prepareUpdate(chainUpdate, indexers, chainid)
+		if err == nil {
+			return update
+		}
+		log.Error("Error computing updates", "err", err.Error())
+	}
+}
+
+// pipelineUpdates prepares chain updates ahead of the commit loop, so that the
+// statements for one update are computed while the previous one is committed.
+// Updates are delivered in the order they were received.
+func pipelineUpdates(csCh <-chan *delivery.ChainUpdate, preparedCh chan<- *preparedUpdate, indexers []Indexer, chainid uint64, quit <-chan struct{}) {
+	for {
+		select {
+		case <-quit:
+			return
+		case chainUpdate := <-csCh:
+			update := mustPrepareUpdate(chainUpdate, indexers, chainid)
+			select {
+			case <-quit:
+				return
+			case preparedCh <- update:
+			}
+		}
+	}
+}
+
+// FeedOptions configures the optional behaviour of ProcessDataFeed. The zero
+// value commits each update as it arrives, without pipelining or grouping.
+type FeedOptions struct {
+	WipeMempool     bool          // empty the mempool on startup rather than restoring it
+	Pipeline        bool          // prepare the next update while the current one commits
+	GroupCommitAge  time.Duration // age behind the head at which updates are grouped
+	GroupCommitSize int           // maximum number of updates in a group
+	ReorgThreshold  int64         // number of blocks behind the head that orphaned data is kept
+	ChainFeed       *ChainFeed    // notified after each commit, if set
+}
+
+func ProcessDataFeed(csConsumer transports.Consumer, txFeed *txfeed.TxFeed, db *sql.DB, quit <-chan struct{}, eip155Block, homesteadBlock uint64, mut *sync.RWMutex, mempoolSlots int, indexers []Indexer, hc *HealthCheck, memTxThreshold int64, rhf chan *rpc.HeightRecord, chainid uint64, opts FeedOptions) {
+	heightGauge := metrics.NewMajorGauge("/flume/height")
+	blockTimer  := metrics.NewMajorTimer("/flume/blockProcessingTime")
 	var safeNum, finalizedNum *big.Int
 
 	log.Info("Processing data feed")
@@ -210,11 +386,103 @@ func ProcessDataFeed(csConsumer transports.Consumer, txFeed *txfeed.TxFeed, db *
 	for _, idx := range indexers {
 		log.Debug("got indexer", "indexer", idx)
 	}
+	// When pipelining, chain updates are read and prepared by pipelineUpdates
+	// and this loop only commits them, so csCh is not selected on here.
+	updateCh := csCh
+	var preparedCh chan *preparedUpdate
+	if opts.Pipeline {
+		updateCh = nil
+		preparedCh = make(chan *preparedUpdate)
+		go pipelineUpdates(csCh, preparedCh, indexers, chainid, quit)
+	}
 	processed := false
 	pruneTicker := time.NewTicker(5 * time.Second)
-	txDedup := make(map[types.Hash]struct{})
+	txDedup := restoreMempool(db, memTxThreshold, opts.WipeMempool)
 	defer txSub.Unsubscribe()
-	db.Exec("DELETE FROM mempool.transactions WHERE 1;")
+	sc := NewStatementCache(db)
+	defer sc.Close()
+	journal := newReorgJournal(db, opts.ReorgThreshold)
+	nextUpdate := func() *preparedUpdate {
+		timer := time.NewTimer(groupCommitWait)
+		defer timer.Stop()
+		select {
+		case chainUpdate := <-updateCh:
+			return mustPrepareUpdate(chainUpdate, indexers, chainid)
+		case update := <-preparedCh:
+			return update
+		case <-quit:
+			return nil
+		case <-timer.C:
+			return nil
+		}
+	}
+	commit := func(update *preparedUpdate) {
+		grouped := groupUpdates(update, nextUpdate, opts.GroupCommitAge, opts.GroupCommitSize)
+		if update.safeNum != nil {
+			safeNum = update.safeNum
+		}
+		if update.finalizedNum != nil {
+			finalizedNum = update.finalizedNum
+		}
+		lastBatch := update.lastBatch
+		for {
+			mut.Lock()
+			start := time.Now()
+			dbtx, err := db.BeginTx(context.Background(), nil)
+			if err != nil {
+				log.Error("Error creating a transaction", "err", err.Error())
+				mut.Unlock()
+				continue
+			}
+			reorgs, err := execUpdate(context.Background(), sc, dbtx, update, journal)
+			if err != nil {
+				dbtx.Rollback()
+				stats := db.Stats()
+				log.Warn("Failed to execute statement", "err", err.Error())
+				log.Info("SQLite Pool", "Open", stats.OpenConnections, "InUse", stats.InUse, "Idle", stats.Idle)
+				mut.Unlock()
+				continue
+			}
+			if err := dbtx.Commit(); err != nil {
+				stats := db.Stats()
+				log.Warn("Failed to commit", "err", err.Error())
+				log.Info("SQLite Pool", "Open", stats.OpenConnections, "InUse", stats.InUse, "Idle", stats.Idle)
+				mut.Unlock()
+				continue
+			}
+			mut.Unlock()
+			for _, reorg := range reorgs {
+				reorgMeter.Mark(1)
+				reorgDepthHist.Update(reorg.depth)
+				log.Warn("Reorg recorded", "block", reorg.block, "depth", reorg.depth)
+			}
+			processed = true
+			hc.lastBlockTime = time.Now()
+			// add condition to check safe or finalized
+			heightRecord := &rpc.HeightRecord{
+				Latest: lastBatch.Number,
+			}
+			if safeNum != nil{
+				i := safeNum.Int64()
+				heightRecord.Safe = &i
+			}
+			if finalizedNum != nil {
+				i := finalizedNum.Int64()
+				heightRecord.Finalized = &i
+			}
+			rhf <- heightRecord
+			opts.ChainFeed.send(newChainEvent(update.added, reorgs))
+			hc.processedCount++
+			heightGauge.Update(lastBatch.Number)
+			blockTimer.UpdateSince(start)
+			if update.blockTime != nil && time.Since(*update.blockTime) > time.Minute {
+				log.Info("Committed Block", "number", uint64(lastBatch.Number), "hash", hexutil.Bytes(lastBatch.Hash.Bytes()), "in", time.Since(start), "age", time.Since(*update.blockTime), "updates", grouped)
+				return
+			}
+			log.Info("Committed Block", "number", uint64(lastBatch.Number), "hash", hexutil.Bytes(lastBatch.Hash.Bytes()), "in", time.Since(start)) 
+			return
+		}
+	}
 	for {
 		select {
 		case <-quit:
@@ -228,103 +496,11 @@ func ProcessDataFeed(csConsumer transports.Consumer, txFeed *txfeed.TxFeed, db *
 		case <-pruneTicker.C:
 			prune_mempool(db, mempoolSlots, txDedup, memTxThreshold)
 		case tx := <-txCh:
-			mempool_indexer(db, mempoolSlots, txDedup, tx)
-		case chainUpdate := <-csCh:
-			var lastBatch *delivery.PendingBatch
-		UPDATELOOP:
-			for {
-				megaStatement := []string{}
-				megaParameters := []interface{}{}
-				// var ok bool
-				for _, pb := range chainUpdate.Added() {
-					if v, ok := pb.Values[safeNumKey]; ok {
-						safeNum = new(big.Int).SetBytes(v)
-					}
-					if v, ok := pb.Values[finalizedNumKey]; ok {
-						finalizedNum = new(big.Int).SetBytes(v)
-					}
-					for _, indexer := range indexers {
-						s, err := indexer.Index(pb)
-						log.Debug("inside indexer loop", "idx", indexer, "len", len(s))
-						if err != nil {
-							log.Error("Error computing updates", "err", err.Error())
-							continue UPDATELOOP
-						}
-						megaStatement = append(megaStatement, s...)
-					}
-					lastBatch = pb
-					if blockTime != nil { blockAgeTimer.UpdateSince(*blockTime) }
-					resumption := pb.Resumption()
-					if resumption != "" {
-						tokens := strings.Split(resumption, ";")
-						for _, token := range tokens {
-							parts := strings.Split(token, "=")
-							source, offsetS := parts[0], parts[1]
-							parts = strings.Split(source, ":")
-							topic, partitionS := parts[0], parts[1]
-							offset, err := strconv.Atoi(offsetS)
-							if err != nil {
-								log.Error("offset error", "err", err.Error())
-								continue
-							}
-							partition, err := strconv.Atoi(partitionS)
-							if err != nil {
-								log.Error("partition error", "err", err.Error())
-								continue
-							}
-							megaStatement = append(megaStatement, "INSERT OR REPLACE INTO cardinal_offsets(offset, partition, topic) VALUES (?, ?, ?)")
-							megaParameters = append(megaParameters, offset, partition, topic)
-						}
-					}
-				}
-				mut.Lock()
-				start := time.Now()
-				dbtx, err := db.BeginTx(context.Background(), nil)
-				if err != nil {
-					log.Error("Error creating a transaction", "err", err.Error())
-					continue
-				}
-				if _, err := dbtx.Exec(strings.Join(megaStatement, " ; "), megaParameters...); err != nil {
-					dbtx.Rollback()
-					stats := db.Stats()
-					log.Warn("Failed to execute statement", "err", err.Error(), "sql", strings.Join(megaStatement, " ; "))
-					log.Info("SQLite Pool", "Open", stats.OpenConnections, "InUse", stats.InUse, "Idle", stats.Idle)
-					mut.Unlock()
-					continue
-				}
-				if err := dbtx.Commit(); err != nil {
-					stats := db.Stats()
-					log.Warn("Failed to commit", "err", err.Error())
-					log.Info("SQLite Pool", "Open", stats.OpenConnections, "InUse", stats.InUse, "Idle", stats.Idle)
-					mut.Unlock()
-					continue
-				}
-				mut.Unlock()
-				processed = true
-				hc.lastBlockTime = time.Now()
-				// add condition to check safe or finalized
-				heightRecord := &rpc.HeightRecord{
-					Latest: lastBatch.Number,
-				}
-				if safeNum != nil{
-					i := safeNum.Int64()
-					heightRecord.Safe = &i
-				}
-				if finalizedNum != nil {
-					i := finalizedNum.Int64()
-					heightRecord.Finalized = &i
-				}
-				rhf <- heightRecord
-				hc.processedCount++
-				heightGauge.Update(lastBatch.Number)
-				blockTimer.UpdateSince(start)
-				if blockTime != nil && time.Since(*blockTime) > time.Minute {
-					log.Info("Committed Block", "number", uint64(lastBatch.Number), "hash", hexutil.Bytes(lastBatch.Hash.Bytes()), "in", time.Since(start), "age", time.Since(*blockTime))
-					break
-				}
-				log.Info("Committed Block", "number", uint64(lastBatch.Number), "hash", hexutil.Bytes(lastBatch.Hash.Bytes()), "in", time.Since(start)) 
-				break
-			}
+			mempool_indexer(db, sc, mempoolSlots, txDedup, tx)
+		case chainUpdate := <-updateCh:
+			commit(mustPrepareUpdate(chainUpdate, indexers, chainid))
+		case update := <-preparedCh:
+			commit(update)
 		}
 	}
 }
@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+)
+
+type delayedIndexer struct {
+	name  string
+	delay time.Duration
+}
+
+func (d *delayedIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
+	time.Sleep(d.delay)
+	return []string{fmt.Sprintf("%v-0", d.name), fmt.Sprintf("%v-1", d.name)}, nil
+}
+
+func TestIndexBatchOrder(t *testing.T) {
+	indexers := []Indexer{
+		&delayedIndexer{"a", 30 * time.Millisecond},
+		&delayedIndexer{"b", 0},
+		&delayedIndexer{"c", 15 * time.Millisecond},
+	}
+	statements, err := indexBatch(indexers, &delivery.PendingBatch{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{"a-0", "a-1", "b-0", "b-1", "c-0", "c-1"}
+	if len(statements) != len(expected) {
+		t.Fatalf("expected %v statements, got %v", len(expected), len(statements))
+	}
+	for i, s := range statements {
+		if s.Query != expected[i] {
+			t.Errorf("statement %v: expected %v, got %v", i, expected[i], s.Query)
+		}
+	}
+}
+
+func TestGroupUpdates(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	recent := time.Now()
+	queue := []*preparedUpdate{
+		{statements: []Statement{{Query: "1"}}, lastBatch: &delivery.PendingBatch{Number: 2}, blockTime: &old},
+		{statements: []Statement{{Query: "2"}}, lastBatch: &delivery.PendingBatch{Number: 3}, blockTime: &recent},
+		{statements: []Statement{{Query: "3"}}, lastBatch: &delivery.PendingBatch{Number: 4}, blockTime: &recent},
+	}
+	next := func() *preparedUpdate {
+		if len(queue) == 0 {
+			return nil
+		}
+		n := queue[0]
+		queue = queue[1:]
+		return n
+	}
+	update := &preparedUpdate{statements: []Statement{{Query: "0"}}, lastBatch: &delivery.PendingBatch{Number: 1}, blockTime: &old}
+	if count := groupUpdates(update, next, time.Minute, 10); count != 3 {
+		t.Fatalf("expected 3 updates grouped, got %v", count)
+	}
+	if update.lastBatch.Number != 3 {
+		t.Errorf("unexpected last batch %v", update.lastBatch.Number)
+	}
+	for i, s := range update.statements {
+		if s.Query != fmt.Sprintf("%v", i) {
+			t.Errorf("statement %v out of order: %v", i, s.Query)
+		}
+	}
+	if len(queue) != 1 {
+		t.Errorf("grouping should stop once caught up, %v updates left", len(queue))
+	}
+}
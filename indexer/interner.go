@@ -0,0 +1,147 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var internedMeter = metrics.NewMinorMeter("/flume/intern/rows")
+
+// internBatch is the number of rowids converted per transaction.
+const internBatch = 5000
+
+type internColumn struct{ column, table string }
+
+// logInternColumns are the event_logs columns that are interned.
+var logInternColumns = []internColumn{
+	{"address", intern.Addresses},
+	{"topic0", intern.Topics},
+}
+
+// txInternColumns are the transactions columns that are interned.
+var txInternColumns = []internColumn{
+	{"sender", intern.Addresses},
+	{"recipient", intern.Addresses},
+	{"contractAddress", intern.Addresses},
+}
+
+type internSource struct {
+	table   string
+	columns []internColumn
+}
+
+// Interner converts event_logs and transactions written before interning was
+// enabled, in batches of rowids, until it reaches rows the indexer wrote with
+// ids. The highest rowid converted in each table is committed with each
+// batch, so a restart resumes where it left off.
+type Interner struct {
+	db      *sql.DB
+	mut     *sync.RWMutex
+	sources []internSource
+}
+
+// NewInterner returns an interner for event_logs if logs is set and for
+// transactions if transactions is set, along with their shards. Batches are
+// committed under mut, the lock held by ProcessDataFeed.
+func NewInterner(db *sql.DB, mut *sync.RWMutex, logs, transactions bool) *Interner {
+	i := &Interner{db: db, mut: mut}
+	if logs && hasTable(db, "logs", "event_logs") {
+		for _, table := range shards.All("logs", "event_logs") {
+			i.sources = append(i.sources, internSource{table, logInternColumns})
+		}
+	}
+	if transactions && hasTable(db, "transactions", "transactions") {
+		for _, table := range shards.All("transactions", "transactions") {
+			i.sources = append(i.sources, internSource{table, txInternColumns})
+		}
+	}
+	return i
+}
+
+// Run converts batches for up to half of every interval until ctx is
+// cancelled. The lock is released between batches, so the indexer is not
+// held up for longer than a batch.
+func (i *Interner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := i.convert(ctx, now.Add(interval/2)); err != nil && ctx.Err() == nil {
+				log.Warn("Interning failed", "err", err.Error())
+			}
+		}
+	}
+}
+
+// convert converts batches of each table in turn until they are converted or
+// deadline passes.
+func (i *Interner) convert(ctx context.Context, deadline time.Time) error {
+	for _, source := range i.sources {
+		table := source.table
+		var max sql.NullInt64
+		if err := i.db.QueryRowContext(ctx, fmt.Sprintf("SELECT max(rowid) FROM %v;", table)).Scan(&max); err != nil {
+			return fmt.Errorf("%v: %w", table, err)
+		}
+		var from int64
+		if err := i.db.QueryRowContext(ctx, fmt.Sprintf("SELECT converted FROM %v WHERE source = ?;", intern.Progress), table).Scan(&from); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("%v: %w", table, err)
+		}
+		for max.Valid && from < max.Int64 {
+			if time.Now().After(deadline) {
+				return nil
+			}
+			to := from + internBatch
+			if to > max.Int64 {
+				to = max.Int64
+			}
+			i.mut.Lock()
+			converted, err := i.convertRange(ctx, source, from, to)
+			i.mut.Unlock()
+			if err != nil {
+				return fmt.Errorf("%v: %w", table, err)
+			}
+			internedMeter.Mark(converted)
+			from = to
+		}
+	}
+	return nil
+}
+
+// convertRange interns the values in the rowids after from up to to, which
+// are stored as blobs, unlike ids, and records to as converted.
+func (i *Interner) convertRange(ctx context.Context, source internSource, from, to int64) (int64, error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	table := source.table
+	var converted int64
+	for _, c := range source.columns {
+		condition := fmt.Sprintf("rowid > ? AND rowid <= ? AND typeof(%v) = 'blob'", c.column)
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT OR IGNORE INTO %v(value) SELECT %v FROM %v WHERE %v;", c.table, c.column, table, condition), from, to); err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %v SET %v = (SELECT id FROM %v WHERE value = %v) WHERE %v;", table, c.column, c.table, c.column, condition), from, to)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		converted += n
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %v(source, converted) VALUES (?, ?);", intern.Progress), table, to); err != nil {
+		return 0, err
+	}
+	return converted, tx.Commit()
+}
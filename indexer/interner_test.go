@@ -0,0 +1,267 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-evm/crypto"
+	"github.com/openrelayxyz/cardinal-evm/rlp"
+	evm "github.com/openrelayxyz/cardinal-evm/types"
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types"
+
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/migrations"
+)
+
+func TestInterning(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"control": filepath.Join(dir, "interner.sqlite"),
+		"logs":    filepath.Join(dir, "logs.sqlite"),
+	}
+	intern.Configure(true)
+	db, err := openControlDatabase(databases)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := migrations.Migrate(db, migrations.Core(databases), 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	address := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	topic := types.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+	// Logs written before interning was enabled.
+	for i := int64(1); i <= 2; i++ {
+		if _, err := db.Exec("INSERT INTO logs.event_logs(address, topic0, block, logIndex) VALUES (?, ?, ?, 0)", trimPrefix(address.Bytes()), trimPrefix(topic.Bytes()), i); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	pb := &delivery.PendingBatch{Number: 3, Hash: types.HexToHash("0x03"), Values: map[string][]byte{}}
+	for i, l := range []*evm.Log{
+		{Address: address, Topics: []types.Hash{topic}, Data: []byte{1}},
+		{Address: address, Data: []byte{2}},
+	} {
+		data, err := rlp.EncodeToBytes(l)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		pb.Values[fmt.Sprintf("c/1/b/%x/l/0/%x", pb.Hash.Bytes(), i)] = data
+	}
+	statements, err := NewLogIndexer(1, true).(*LogIndexer).IndexStatements(pb)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, s := range statements {
+		if _, err := tx.Exec(s.Query, s.Args...); err != nil {
+			t.Fatalf("%v: %v", s.Query, err.Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var anonymous int
+	db.QueryRow("SELECT count(*) FROM logs.event_logs WHERE block = 3 AND topic0 IS NULL AND typeof(address) = 'text'").Scan(&anonymous)
+	if anonymous != 1 {
+		t.Errorf("expected the anonymous log to have an interned address and no topic0")
+	}
+
+	if err := NewInterner(db, &sync.RWMutex{}, true, false).convert(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var raw int
+	db.QueryRow("SELECT count(*) FROM logs.event_logs WHERE typeof(address) = 'blob' OR typeof(topic0) = 'blob'").Scan(&raw)
+	if raw != 0 {
+		t.Errorf("expected every log to be interned, %v were not", raw)
+	}
+	var addresses, topics int
+	db.QueryRow("SELECT (SELECT count(*) FROM logs.interned_addresses), (SELECT count(*) FROM logs.interned_topics)").Scan(&addresses, &topics)
+	if addresses != 1 || topics != 1 {
+		t.Errorf("expected one interned address and topic, got %v and %v", addresses, topics)
+	}
+
+	addressClause, params := intern.MatchAddress("address", trimPrefix(address.Bytes()))
+	topicClause, topicParams := intern.MatchTopic("topic0", trimPrefix(topic.Bytes()))
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT block, %v, %v FROM logs.event_logs WHERE %v AND %v ORDER BY block", intern.Address("address"), intern.Topic("topic0"), addressClause, topicClause),
+		append(params, topicParams...)...,
+	)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer rows.Close()
+	blocks := []int64{}
+	for rows.Next() {
+		var block int64
+		var a, t0 []byte
+		rows.Scan(&block, &a, &t0)
+		if !bytes.Equal(a, trimPrefix(address.Bytes())) || !bytes.Equal(t0, trimPrefix(topic.Bytes())) {
+			t.Errorf("block %v resolved to %x %x", block, a, t0)
+		}
+		blocks = append(blocks, block)
+	}
+	if fmt.Sprint(blocks) != "[1 2 3]" {
+		t.Errorf("unexpected matching blocks %v", blocks)
+	}
+
+	// A restarted interner resumes from the stored progress, rather than
+	// rescanning converted rows, so this row is left alone.
+	if _, err := db.Exec("UPDATE logs.event_logs SET address = ? WHERE block = 1", trimPrefix(address.Bytes())); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("INSERT INTO logs.event_logs(address, topic0, block, logIndex) VALUES (?, ?, 4, 0)", trimPrefix(address.Bytes()), trimPrefix(topic.Bytes())); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := NewInterner(db, &sync.RWMutex{}, true, false).convert(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var raws []int64
+	rawRows, err := db.Query("SELECT block FROM logs.event_logs WHERE typeof(address) = 'blob' ORDER BY block")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer rawRows.Close()
+	for rawRows.Next() {
+		var block int64
+		rawRows.Scan(&block)
+		raws = append(raws, block)
+	}
+	if fmt.Sprint(raws) != "[1]" {
+		t.Errorf("expected only the log before the stored progress to be raw, got %v", raws)
+	}
+}
+
+func TestTransactionInterning(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"control":      filepath.Join(dir, "txinterner.sqlite"),
+		"logs":         filepath.Join(dir, "logs.sqlite"),
+		"transactions": filepath.Join(dir, "transactions.sqlite"),
+	}
+	intern.Configure(true)
+	db, err := openControlDatabase(databases)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := migrations.Migrate(db, migrations.Core(databases), 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+
+	// A transaction written before interning was enabled.
+	if _, err := db.Exec("INSERT INTO transactions.transactions(block, transactionIndex, nonce, sender, recipient) VALUES (1, 0, 0, ?, ?)", trimPrefix(sender.Bytes()), trimPrefix(recipient.Bytes())); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	header, err := rlp.EncodeToBytes(&evm.Header{Number: big.NewInt(2), Difficulty: big.NewInt(1)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	pb := &delivery.PendingBatch{Number: 2, Hash: types.HexToHash("0x02"), Values: map[string][]byte{}}
+	pb.Values[fmt.Sprintf("c/1/b/%x/h", pb.Hash.Bytes())] = header
+	signer := evm.NewEIP155Signer(big.NewInt(1))
+	for i, tx := range []*evm.LegacyTx{
+		{Nonce: 1, To: &recipient, Gas: 21000, GasPrice: big.NewInt(1), Value: big.NewInt(1)},
+		{Nonce: 2, Gas: 100000, GasPrice: big.NewInt(1), Value: big.NewInt(0), Data: []byte{1}},
+	} {
+		data, err := evm.MustSignNewTx(key, signer, tx).MarshalBinary()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		pb.Values[fmt.Sprintf("c/1/b/%x/t/%x", pb.Hash.Bytes(), i)] = data
+		receipt := &cardinalReceiptMeta{Status: 1}
+		if tx.To == nil {
+			receipt.ContractAddress = contract
+		}
+		if pb.Values[fmt.Sprintf("c/1/b/%x/r/%x", pb.Hash.Bytes(), i)], err = rlp.EncodeToBytes(receipt); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	statements, err := NewTxIndexer(1, 0, 0, false, true).(*TxIndexer).IndexStatements(pb)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, s := range statements {
+		if _, err := tx.Exec(s.Query, s.Args...); err != nil {
+			t.Fatalf("%v: %v", s.Query, err.Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var creations int
+	db.QueryRow("SELECT count(*) FROM transactions.transactions WHERE block = 2 AND recipient IS NULL AND typeof(sender) = 'text' AND typeof(contractAddress) = 'text'").Scan(&creations)
+	if creations != 1 {
+		t.Errorf("expected the contract creation to have an interned sender and contract address and no recipient")
+	}
+
+	if err := NewInterner(db, &sync.RWMutex{}, false, true).convert(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var raw int
+	db.QueryRow("SELECT count(*) FROM transactions.transactions WHERE typeof(sender) = 'blob' OR typeof(recipient) = 'blob' OR typeof(contractAddress) = 'blob'").Scan(&raw)
+	if raw != 0 {
+		t.Errorf("expected every transaction to be interned, %v were not", raw)
+	}
+	var addresses int
+	db.QueryRow("SELECT count(*) FROM logs.interned_addresses").Scan(&addresses)
+	if addresses != 3 {
+		t.Errorf("expected three interned addresses, got %v", addresses)
+	}
+
+	senderClause, params := intern.MatchAddress("sender", trimPrefix(sender.Bytes()))
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT block, nonce, %v, %v, %v FROM transactions.transactions WHERE %v ORDER BY block, transactionIndex", intern.Address("sender"), intern.Address("recipient"), intern.Address("contractAddress"), senderClause),
+		params...,
+	)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer rows.Close()
+	nonces := []int64{}
+	for rows.Next() {
+		var block, nonce int64
+		var from, to, created []byte
+		rows.Scan(&block, &nonce, &from, &to, &created)
+		if !bytes.Equal(from, trimPrefix(sender.Bytes())) {
+			t.Errorf("block %v sender resolved to %x", block, from)
+		}
+		if nonce == 2 {
+			if len(to) != 0 || !bytes.Equal(created, contract.Bytes()) {
+				t.Errorf("contract creation resolved to %x, %x", to, created)
+			}
+		} else if !bytes.Equal(to, trimPrefix(recipient.Bytes())) || len(created) != 0 {
+			t.Errorf("transfer %v resolved to %x, %x", nonce, to, created)
+		}
+		nonces = append(nonces, nonce)
+	}
+	if fmt.Sprint(nonces) != "[0 1 2]" {
+		t.Errorf("unexpected matching transactions %v", nonces)
+	}
+}
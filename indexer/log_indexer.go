@@ -1,11 +1,17 @@
 package indexer
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/openrelayxyz/cardinal-evm/crypto"
 	"github.com/openrelayxyz/cardinal-evm/rlp"
 	evm "github.com/openrelayxyz/cardinal-evm/types"
 	"github.com/openrelayxyz/cardinal-streams/delivery"
 	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 	"regexp"
 	"strconv"
 )
@@ -15,7 +21,8 @@ var (
 )
 
 type LogIndexer struct {
-	chainid uint64
+	chainid   uint64
+	interning bool
 }
 
 func getTopicIndex(topics []types.Hash, idx int) []byte {
@@ -25,11 +32,21 @@ func getTopicIndex(topics []types.Hash, idx int) []byte {
 	return []byte{}
 }
 
-func NewLogIndexer(chainid uint64) Indexer {
-	return &LogIndexer{chainid: chainid}
+// NewLogIndexer returns a log indexer. With interning, addresses and topic0
+// values are written as ids in the intern tables.
+func NewLogIndexer(chainid uint64, interning bool) Indexer {
+	return &LogIndexer{chainid: chainid, interning: interning}
 }
 
 func (indexer *LogIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
+	statements, err := indexer.IndexStatements(pb)
+	if err != nil {
+		return nil, err
+	}
+	return RenderStatements(statements), nil
+}
+
+func (indexer *LogIndexer) IndexStatements(pb *delivery.PendingBatch) ([]Statement, error) {
 
 	logData := make(map[int64]*evm.Log)
 	txData := make(map[uint]types.Hash)
@@ -56,20 +73,35 @@ func (indexer *LogIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 		}
 	}
 
-	statements := make([]string, 0, len(logData)+1)
+	statements := make([]Statement, 0, len(logData)+1)
 
-	statements = append(statements, ApplyParameters("DELETE FROM event_logs WHERE block >= %v", pb.Number))
+	table, deletes := "event_logs", []string{"event_logs"}
+	if shards.Sharded("logs") {
+		table, deletes = shards.Table("logs", "event_logs", uint64(pb.Number)), shards.Range("logs", "event_logs", uint64(pb.Number), math.MaxUint64)
+	}
+	for _, t := range deletes {
+		statements = append(statements, NewStatement(fmt.Sprintf("DELETE FROM %v WHERE block >= ?", t), pb.Number))
+	}
 
 	for i := 0; i < len(logData); i++ {
 		logRecord := logData[int64(i)]
-		statements = append(statements, ApplyParameters(
-			"INSERT INTO event_logs(address,  topic0, topic1, topic2, topic3, data, block, logIndex, transactionHash, transactionIndex, blockHash) VALUES (%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v)",
+		address, topic0 := "?", "?"
+		if indexer.interning {
+			statements = append(statements, NewStatement(intern.Insert(intern.Addresses), logRecord.Address))
+			address = intern.Lookup(intern.Addresses)
+			if len(logRecord.Topics) > 0 {
+				statements = append(statements, NewStatement(intern.Insert(intern.Topics), getTopicIndex(logRecord.Topics, 0)))
+				topic0 = intern.Lookup(intern.Topics)
+			}
+		}
+		statements = append(statements, NewStatement(
+			fmt.Sprintf("INSERT INTO %v(address,  topic0, topic1, topic2, topic3, data, block, logIndex, transactionHash, transactionIndex, blockHash) VALUES (%v, %v, ?, ?, ?, ?, ?, ?, ?, ?, ?)", table, address, topic0),
 			logRecord.Address,
 			getTopicIndex(logRecord.Topics, 0),
 			getTopicIndex(logRecord.Topics, 1),
 			getTopicIndex(logRecord.Topics, 2),
 			getTopicIndex(logRecord.Topics, 3),
-			compress(logRecord.Data),
+			codec.Compress("logs.data", logRecord.Data),
 			pb.Number,
 			logRecord.Index,
 			txData[logRecord.TxIndex],
@@ -1,6 +1,9 @@
 package indexer
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"time"
 	"database/sql"
 
@@ -8,9 +11,55 @@ import (
 	"github.com/openrelayxyz/cardinal-evm/rlp"
 	evm "github.com/openrelayxyz/cardinal-evm/types"
 	"github.com/openrelayxyz/cardinal-types"
-	"strings"
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 )
 
+// restoreMempool prepares the mempool kept from the previous run, removing
+// transactions that were confirmed or expired since, and returns the hashes
+// of those that remain so the tx feed does not insert them again. With wipe,
+// the mempool is emptied instead.
+func restoreMempool(db *sql.DB, memTxThreshold int64, wipe bool) map[types.Hash]struct{} {
+	txDedup := make(map[types.Hash]struct{})
+	if !hasTable(db, "mempool", "transactions") {
+		return txDedup
+	}
+	if wipe {
+		db.Exec("DELETE FROM mempool.transactions WHERE 1;")
+		return txDedup
+	}
+	if hasTable(db, "transactions", "transactions") {
+		// Each shard is checked by its own statement, as SQLite cannot use the
+		// senderNonce index of a shard through a union of them.
+		for _, shard := range shards.All("transactions", "transactions") {
+			if _, err := db.Exec(fmt.Sprintf("DELETE FROM mempool.transactions WHERE EXISTS (SELECT 1 FROM %v AS confirmed WHERE %v AND confirmed.nonce >= mempool.transactions.nonce);", shard, intern.MatchAddressTo("confirmed.sender", "mempool.transactions.sender"))); err != nil {
+				log.Error("Error removing confirmed transactions from mempool", "shard", shard, "err", err.Error())
+			}
+		}
+	}
+	threshold := time.Now().Add(-time.Duration(memTxThreshold) * time.Minute).Unix()
+	if _, err := db.Exec("DELETE FROM mempool.transactions WHERE time < ?;", threshold); err != nil {
+		log.Error("Error time pruning mempool", "err", err.Error())
+	}
+	rows, err := db.Query("SELECT hash FROM mempool.transactions;")
+	if err != nil {
+		log.Error("Error loading mempool", "err", err.Error())
+		return txDedup
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			log.Error("Error loading mempool", "err", err.Error())
+			return txDedup
+		}
+		txDedup[types.BytesToHash(hash)] = struct{}{}
+	}
+	log.Info("Restored mempool", "transactions", len(txDedup))
+	return txDedup
+}
+
 func prune_mempool(db *sql.DB, mempoolSlots int, txDedup map[types.Hash]struct{}, memTxThreshold int64) {
 	pstart := time.Now() 
 	threshold :=  pstart.Add(-time.Duration(memTxThreshold) * time.Minute).Unix()
@@ -28,10 +77,10 @@ func prune_mempool(db *sql.DB, mempoolSlots int, txDedup map[types.Hash]struct{}
 	}
 }
 
-func mempool_indexer(db *sql.DB, mempoolSlots int, txDedup map[types.Hash]struct{}, tx *evm.Transaction) []string {
+func mempool_indexer(db *sql.DB, sc *StatementCache, mempoolSlots int, txDedup map[types.Hash]struct{}, tx *evm.Transaction) []Statement {
 	txHash := tx.Hash()
 	if _, ok := txDedup[txHash]; ok {
-		return []string{}
+		return []Statement{}
 	}
 	var signer evm.Signer
 	var accessListRLP []byte
@@ -54,20 +103,20 @@ func mempool_indexer(db *sql.DB, mempoolSlots int, txDedup map[types.Hash]struct
 	}
 	v, r, s := tx.RawSignatureValues()
 	t := time.Now()
-	statements := []string{}
+	statements := []Statement{}
 	// If this is a replacement transaction, delete any it might be replacing
-	statements = append(statements, ApplyParameters(
-		"DELETE FROM mempool.transactions WHERE sender = %v AND nonce = %v",
+	statements = append(statements, NewStatement(
+		"DELETE FROM mempool.transactions WHERE sender = ? AND nonce = ?",
 		sender,
 		tx.Nonce(),
 	))
 	// Insert the transaction
-	statements = append(statements, ApplyParameters(
-		"INSERT INTO mempool.transactions(gas, gasPrice, hash, input, nonce, recipient, `value`, v, r, s, sender, `type`, access_list, gasFeeCap, gasTipCap, time) VALUES (%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v)",
+	statements = append(statements, NewStatement(
+		"INSERT INTO mempool.transactions(gas, gasPrice, hash, input, nonce, recipient, `value`, v, r, s, sender, `type`, access_list, gasFeeCap, gasTipCap, time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		tx.Gas(),
 		gasPrice,
 		txHash,
-		getCopy(compress(tx.Data())),
+		codec.Compress("transactions.input", tx.Data()),
 		tx.Nonce(),
 		to,
 		trimPrefix(tx.Value().Bytes()),
@@ -76,24 +125,33 @@ func mempool_indexer(db *sql.DB, mempoolSlots int, txDedup map[types.Hash]struct
 		s,
 		sender,
 		tx.Type(),
-		compress(accessListRLP),
+		codec.Compress("transactions.access_list", accessListRLP),
 		trimPrefix(tx.GasFeeCap().Bytes()),
 		trimPrefix(tx.GasTipCap().Bytes()),
 		t.Unix(),
 	))
 	// Delete the transaction we just inserted if the confirmed transactions
 	// pool has a conflicting entry
-	statements = append(statements, ApplyParameters(
-		"DELETE FROM mempool.transactions WHERE sender = %v AND nonce = %v AND (sender, nonce) IN (SELECT sender, nonce FROM transactions.transactions WHERE sender = %v AND nonce = %v)",
-		sender,
-		tx.Nonce(),
-		sender,
-		tx.Nonce(),
+	confirmed, confirmedParams := intern.MatchAddress("sender", sender)
+	params := append([]interface{}{sender, tx.Nonce()}, confirmedParams...)
+	statements = append(statements, NewStatement(
+		fmt.Sprintf("DELETE FROM mempool.transactions WHERE sender = ? AND nonce = ? AND EXISTS (SELECT 1 FROM %v WHERE %v AND nonce = ?)", shards.Source("transactions", "transactions", 0, math.MaxUint64, ""), confirmed),
+		append(params, tx.Nonce())...,
 	))
 
-	if _, err := db.Exec(strings.Join(statements, " ; ") + ";"); err != nil {
-		log.Error("Error on insert:", strings.Join(statements, " ; "), "err", err.Error())
-		return []string{}
+	dbtx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Error("Error creating a mempool transaction", "err", err.Error())
+		return []Statement{}
+	}
+	if err := sc.Exec(context.Background(), dbtx, statements); err != nil {
+		dbtx.Rollback()
+		log.Error("Error on insert:", "err", err.Error())
+		return []Statement{}
+	}
+	if err := dbtx.Commit(); err != nil {
+		log.Error("Error committing mempool transaction", "err", err.Error())
+		return []Statement{}
 	}
 	txDedup[txHash] = struct{}{}
 
@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openrelayxyz/cardinal-types"
+
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/migrations"
+)
+
+func TestRestoreMempool(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"control":      filepath.Join(dir, "mempool_restore.sqlite"),
+		"transactions": filepath.Join(dir, "transactions.sqlite"),
+		"mempool":      filepath.Join(dir, "mempool.sqlite"),
+	}
+	intern.Configure(false)
+	db, err := openControlDatabase(databases)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := migrations.Migrate(db, migrations.Core(databases), 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("INSERT INTO transactions.transactions(block, transactionIndex, sender, nonce) VALUES (1, 0, x'aa', 5)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	now := time.Now().Unix()
+	for i, tx := range []struct {
+		sender []byte
+		nonce  int64
+		time   int64
+	}{
+		{[]byte{0xaa}, 4, now},           // confirmed
+		{[]byte{0xaa}, 5, now},           // confirmed
+		{[]byte{0xaa}, 6, now},           // pending
+		{[]byte{0xbb}, 0, now - 2*60*60}, // expired
+		{[]byte{0xbb}, 1, now},           // pending
+	} {
+		if _, err := db.Exec("INSERT INTO mempool.transactions(hash, sender, nonce, time) VALUES (?, ?, ?, ?)", []byte{byte(i + 1)}, tx.sender, tx.nonce, tx.time); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	txDedup := restoreMempool(db, 60, false)
+	if len(txDedup) != 2 {
+		t.Errorf("expected 2 transactions restored, got %v", len(txDedup))
+	}
+	for _, b := range []byte{3, 5} {
+		if _, ok := txDedup[types.BytesToHash([]byte{b})]; !ok {
+			t.Errorf("expected transaction %v to be restored", b)
+		}
+	}
+	if txDedup = restoreMempool(db, 60, true); len(txDedup) != 0 {
+		t.Errorf("expected an empty mempool after wiping")
+	}
+	var count int
+	db.QueryRow("SELECT count(*) FROM mempool.transactions").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the mempool to be wiped, %v remain", count)
+	}
+}
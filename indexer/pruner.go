@@ -0,0 +1,182 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var (
+	prunedBlocksMeter = metrics.NewMinorMeter("/flume/pruned")
+	earliestGauge     = metrics.NewMinorGauge("/flume/earliest")
+)
+
+// Pruner deletes blocks that have fallen out of the configured retention
+// window, along with their withdrawals, transactions and logs, and any rows in
+// tables registered by plugins.
+//
+// Each pass first advances the config's earliest block, so that new requests
+// for pruned blocks are routed to the heavy server, and then deletes the rows
+// below the earliest block set by the previous pass. Requests that read the
+// earliest block before it advanced have a full interval to complete.
+type Pruner struct {
+	db        *sql.DB
+	cfg       *config.Config
+	mut       *sync.RWMutex
+	tables    map[string]string
+	batchSize int64
+	deletable int64
+}
+
+// NewPruner returns a pruner for db. tables maps each qualified table name to
+// the column holding its block number, and is added to the core tables that
+// are present.
+func NewPruner(db *sql.DB, cfg *config.Config, mut *sync.RWMutex, tables map[string]string) *Pruner {
+	p := &Pruner{
+		db:        db,
+		cfg:       cfg,
+		mut:       mut,
+		tables:    make(map[string]string),
+		batchSize: cfg.PruneBatchSize,
+		deletable: int64(cfg.GetEarliestBlock()),
+	}
+	for _, t := range []struct{ schema, table, column string }{
+		{"blocks", "blocks", "number"},
+		{"blocks", "withdrawals", "block"},
+		{"transactions", "transactions", "block"},
+		{"logs", "event_logs", "block"},
+	} {
+		if hasTable(db, t.schema, t.table) {
+			for _, table := range shards.All(t.schema, t.table) {
+				p.tables[table] = t.column
+			}
+		}
+	}
+	for table, column := range tables {
+		p.tables[table] = column
+	}
+	return p
+}
+
+// Run prunes every interval until ctx is cancelled.
+func (p *Pruner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.prune(ctx); err != nil && ctx.Err() == nil {
+				log.Warn("Pruning failed", "err", err.Error())
+			}
+		}
+	}
+}
+
+// cutoff returns the earliest block inside the retention window. Blocks within
+// the reorg threshold of the head are never pruned.
+func (p *Pruner) cutoff(ctx context.Context) (int64, error) {
+	var head sql.NullInt64
+	if err := p.db.QueryRowContext(ctx, "SELECT max(number) FROM blocks.blocks;").Scan(&head); err != nil {
+		return 0, err
+	}
+	if !head.Valid {
+		return 0, nil
+	}
+	var cutoff int64
+	if p.cfg.RetentionBlocks > 0 {
+		cutoff = head.Int64 - int64(p.cfg.RetentionBlocks) + 1
+	}
+	if p.cfg.RetentionAge > 0 {
+		var number sql.NullInt64
+		minTime := time.Now().Unix() - p.cfg.RetentionAge
+		if err := p.db.QueryRowContext(ctx, "SELECT min(number) FROM blocks.blocks WHERE time >= ?;", minTime).Scan(&number); err != nil {
+			return 0, err
+		}
+		if !number.Valid {
+			number.Int64 = head.Int64
+		}
+		if number.Int64 > cutoff {
+			cutoff = number.Int64
+		}
+	}
+	if limit := head.Int64 - p.cfg.ReorgThreshold; cutoff > limit {
+		cutoff = limit
+	}
+	return cutoff, nil
+}
+
+func (p *Pruner) prune(ctx context.Context) error {
+	deletable := p.deletable
+	cutoff, err := p.cutoff(ctx)
+	if err != nil {
+		return err
+	}
+	if cutoff > int64(p.cfg.GetEarliestBlock()) {
+		p.cfg.SetEarliestBlock(uint64(cutoff))
+		log.Debug("Earliest block advanced", "block", cutoff)
+	}
+	earliestGauge.Update(int64(p.cfg.GetEarliestBlock()))
+	p.deletable = int64(p.cfg.GetEarliestBlock())
+	return p.DeleteBefore(ctx, deletable)
+}
+
+// DeleteBefore removes the rows of blocks below number, batchSize blocks per
+// transaction.
+func (p *Pruner) DeleteBefore(ctx context.Context, number int64) error {
+	var first sql.NullInt64
+	if err := p.db.QueryRowContext(ctx, "SELECT min(number) FROM blocks.blocks;").Scan(&first); err != nil {
+		return err
+	}
+	if !first.Valid {
+		return nil
+	}
+	tables := make([]string, 0, len(p.tables))
+	for table := range p.tables {
+		tables = append(tables, table)
+	}
+	// Deleting blocks.blocks last keeps the remaining rows discoverable by
+	// the next pass if this one is interrupted.
+	sort.Slice(tables, func(i, j int) bool {
+		if (tables[i] == "blocks.blocks") != (tables[j] == "blocks.blocks") {
+			return tables[j] == "blocks.blocks"
+		}
+		return tables[i] < tables[j]
+	})
+	for start := first.Int64; start < number; start += p.batchSize {
+		end := start + p.batchSize
+		if end > number {
+			end = number
+		}
+		if err := p.deleteBatch(ctx, tables, end); err != nil {
+			return err
+		}
+		prunedBlocksMeter.Mark(end - start)
+	}
+	return nil
+}
+
+func (p *Pruner) deleteBatch(ctx context.Context, tables []string, end int64) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	dbtx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer dbtx.Rollback()
+	for _, table := range tables {
+		if _, err := dbtx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE %v < ?;", table, p.tables[table]), end); err != nil {
+			return fmt.Errorf("%v: %w", table, err)
+		}
+	}
+	return dbtx.Commit()
+}
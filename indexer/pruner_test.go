@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-flume/config"
+)
+
+func TestPruner(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openControlDatabase(map[string]string{
+		"control": filepath.Join(dir, "pruner.sqlite"),
+		"blocks":  filepath.Join(dir, "blocks.sqlite"),
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE blocks.blocks (number BIGINT PRIMARY KEY, time BIGINT)",
+		"CREATE TABLE blocks.withdrawals (block BIGINT)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		if _, err := db.Exec("INSERT INTO blocks.blocks(number, time) VALUES (?, 0)", i); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := db.Exec("INSERT INTO blocks.withdrawals(block) VALUES (?)", i); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	cfg := &config.Config{RetentionBlocks: 10, ReorgThreshold: 2, PruneBatchSize: 3, EarliestBlock: 1}
+	pruner := NewPruner(db, cfg, &sync.RWMutex{}, nil)
+	if len(pruner.tables) != 2 {
+		t.Fatalf("unexpected tables %v", pruner.tables)
+	}
+
+	count := func(table string) (n, first int64) {
+		if err := db.QueryRow("SELECT count(*), min("+pruner.tables[table]+") FROM "+table).Scan(&n, &first); err != nil {
+			t.Fatalf(err.Error())
+		}
+		return n, first
+	}
+	if err := pruner.prune(context.Background()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cfg.GetEarliestBlock() != 11 {
+		t.Errorf("expected earliest block 11, got %v", cfg.GetEarliestBlock())
+	}
+	if n, _ := count("blocks.blocks"); n != 20 {
+		t.Errorf("rows deleted before the earliest block was advanced, %v remain", n)
+	}
+
+	if err := pruner.prune(context.Background()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, table := range []string{"blocks.blocks", "blocks.withdrawals"} {
+		if n, first := count(table); n != 10 || first != 11 {
+			t.Errorf("expected %v to hold blocks 11-20, got %v rows from %v", table, n, first)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var (
+	recompressedMeter = metrics.NewMinorMeter("/flume/recompress/rows")
+	recompressSaved   = metrics.NewMinorMeter("/flume/recompress/saved")
+)
+
+const (
+	// recompressBatch is the number of rows converted per transaction.
+	recompressBatch = 1000
+	// trainingSamples is the number of recent rows a dictionary is trained on.
+	trainingSamples = 5000
+)
+
+type recompressTarget struct {
+	column   string
+	table    string
+	field    string
+	progress string
+	next     int64
+	loaded   bool
+}
+
+type recompressUpdate struct {
+	rowid     int64
+	old, data []byte
+}
+
+// Recompressor converts stored zlib blobs to zstd with the column
+// dictionaries, in batches of rows. A column with no dictionary first has one
+// trained from its most recent rows, which is stored in its database's
+// codec_dictionaries table and used for new rows from then on.
+//
+// The highest rowid converted for each column of each table is committed to
+// its database's recompress_progress table with each batch, so a restart
+// resumes where it left off.
+type Recompressor struct {
+	db      *sql.DB
+	mut     *sync.RWMutex
+	targets []*recompressTarget
+}
+
+// NewRecompressor returns a recompressor for the core tables that are present
+// and their shards. Batches are committed under mut, the lock held by
+// ProcessDataFeed.
+func NewRecompressor(db *sql.DB, mut *sync.RWMutex) *Recompressor {
+	r := &Recompressor{db: db, mut: mut}
+	for _, t := range []struct{ schema, table, field string }{
+		{"transactions", "transactions", "input"},
+		{"transactions", "transactions", "access_list"},
+		{"logs", "event_logs", "data"},
+	} {
+		if hasTable(db, t.schema, t.table) && hasTable(db, t.schema, "codec_dictionaries") && hasTable(db, t.schema, "recompress_progress") {
+			for _, table := range shards.All(t.schema, t.table) {
+				r.targets = append(r.targets, &recompressTarget{
+					column:   fmt.Sprintf("%v.%v", t.schema, t.field),
+					table:    table,
+					field:    t.field,
+					progress: fmt.Sprintf("%v.recompress_progress", t.schema),
+				})
+			}
+		}
+	}
+	return r
+}
+
+// Run recompresses batches for up to half of every interval until ctx is
+// cancelled. The lock is released between batches, so the indexer is not
+// held up for longer than a batch.
+func (r *Recompressor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.recompress(ctx, now.Add(interval/2)); err != nil && ctx.Err() == nil {
+				log.Warn("Recompression failed", "err", err.Error())
+			}
+		}
+	}
+}
+
+// recompress converts batches of each target in turn until they reach the
+// end of their tables or deadline passes.
+func (r *Recompressor) recompress(ctx context.Context, deadline time.Time) error {
+	for _, target := range r.targets {
+		if !codec.HasDictionary(target.column) {
+			if err := r.train(ctx, target); err != nil {
+				return fmt.Errorf("training %v: %w", target.column, err)
+			}
+		}
+		if !target.loaded {
+			if err := r.db.QueryRowContext(ctx, fmt.Sprintf("SELECT converted FROM %v WHERE source = ?;", target.progress), target.source()).Scan(&target.next); err != nil && err != sql.ErrNoRows {
+				return fmt.Errorf("%v: %w", target.table, err)
+			}
+			target.loaded = true
+		}
+		for {
+			if time.Now().After(deadline) {
+				return nil
+			}
+			done, err := r.convert(ctx, target)
+			if err != nil {
+				return fmt.Errorf("%v: %w", target.table, err)
+			}
+			if done {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// source is the key of target's progress.
+func (target *recompressTarget) source() string {
+	return fmt.Sprintf("%v.%v", target.table, target.field)
+}
+
+func (r *Recompressor) train(ctx context.Context, target *recompressTarget) error {
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf("SELECT %v FROM %v WHERE length(%v) > 0 ORDER BY rowid DESC LIMIT ?;", target.field, target.table, target.field), trainingSamples)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	samples := [][]byte{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		raw, err := codec.Decompress(data)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, raw)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	dict := codec.Train(samples, codec.DictionarySize)
+	if dict == nil {
+		log.Debug("Not enough data to train a dictionary", "column", target.column, "samples", len(samples))
+		return nil
+	}
+	r.mut.Lock()
+	id, err := codec.Store(r.db, target.column, dict, time.Now().Unix())
+	r.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Info("Trained compression dictionary", "column", target.column, "id", id, "size", len(dict), "samples", len(samples))
+	return nil
+}
+
+// convert recompresses the next batch of target's rows and records the last
+// of them as converted, reporting whether there were no rows left. Rows are
+// only updated if they are unchanged since they were read, so a reorg
+// replacing them concurrently wins.
+func (r *Recompressor) convert(ctx context.Context, target *recompressTarget) (bool, error) {
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf("SELECT rowid, %v FROM %v WHERE rowid > ? ORDER BY rowid LIMIT ?;", target.field, target.table), target.next, recompressBatch)
+	if err != nil {
+		return false, err
+	}
+	updates := []recompressUpdate{}
+	next := target.next
+	for rows.Next() {
+		var rowid int64
+		var data []byte
+		if err := rows.Scan(&rowid, &data); err != nil {
+			rows.Close()
+			return false, err
+		}
+		next = rowid
+		if len(data) == 0 || data[0] == codec.ZstdMarker {
+			continue
+		}
+		raw, err := codec.Decompress(data)
+		if err != nil {
+			log.Warn("Skipping undecodable row", "table", target.table, "field", target.field, "rowid", rowid, "err", err.Error())
+			continue
+		}
+		if compressed := codec.CompressZstd(target.column, raw); len(compressed) < len(data) {
+			updates = append(updates, recompressUpdate{rowid, data, compressed})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if next == target.next {
+		return true, nil
+	}
+	r.mut.Lock()
+	saved, err := r.apply(ctx, target, updates, next)
+	r.mut.Unlock()
+	if err != nil {
+		return false, err
+	}
+	recompressedMeter.Mark(int64(len(updates)))
+	recompressSaved.Mark(saved)
+	target.next = next
+	return false, nil
+}
+
+// apply writes updates and records next as target's progress in one
+// transaction.
+func (r *Recompressor) apply(ctx context.Context, target *recompressTarget, updates []recompressUpdate, next int64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var saved int64
+	for _, u := range updates {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %v SET %v = ? WHERE rowid = ? AND %v = ?;", target.table, target.field, target.field), u.data, u.rowid, u.old); err != nil {
+			return 0, err
+		}
+		saved += int64(len(u.old) - len(u.data))
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %v(source, converted) VALUES (?, ?);", target.progress), target.source(), next); err != nil {
+		return 0, err
+	}
+	return saved, tx.Commit()
+}
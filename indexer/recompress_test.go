@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/migrations"
+)
+
+func TestRecompressor(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"control":      filepath.Join(dir, "recompress.sqlite"),
+		"transactions": filepath.Join(dir, "transactions.sqlite"),
+		"logs":         filepath.Join(dir, "logs.sqlite"),
+	}
+	db, err := openControlDatabase(databases)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := migrations.Migrate(db, migrations.Core(databases), 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := codec.Configure("zstd"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer codec.Configure("zlib")
+
+	inputs := make(map[int64][]byte)
+	for i := int64(1); i <= 50; i++ {
+		input := []byte(fmt.Sprintf("transfer(address,uint256) to recipient %04d of amount %08d", i%7, i*1000))
+		inputs[i] = input
+		if _, err := db.Exec("INSERT INTO transactions.transactions(block, transactionIndex, input) VALUES (?, 0, ?)", i, compress(input)); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := db.Exec("INSERT INTO logs.event_logs(block, logIndex, data) VALUES (?, 0, ?)", i, compress(input)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	r := NewRecompressor(db, &sync.RWMutex{})
+	if err := r.recompress(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !codec.HasDictionary("transactions.input") || !codec.HasDictionary("logs.data") {
+		t.Errorf("expected dictionaries to be trained")
+	}
+	var stored int
+	db.QueryRow("SELECT count(*) FROM transactions.codec_dictionaries WHERE col = 'transactions.input'").Scan(&stored)
+	if stored != 1 {
+		t.Errorf("expected a stored input dictionary, got %v", stored)
+	}
+	for _, query := range []string{
+		"SELECT block, input FROM transactions.transactions",
+		"SELECT block, data FROM logs.event_logs",
+	} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		for rows.Next() {
+			var block int64
+			var data []byte
+			rows.Scan(&block, &data)
+			if data[0] != codec.ZstdMarker {
+				t.Errorf("%v: block %v was not recompressed", query, block)
+				continue
+			}
+			raw, err := codec.Decompress(data)
+			if err != nil {
+				t.Errorf("%v: block %v: %v", query, block, err.Error())
+			} else if !bytes.Equal(raw, inputs[block]) {
+				t.Errorf("%v: block %v changed", query, block)
+			}
+		}
+		rows.Close()
+	}
+	var converted int64
+	db.QueryRow("SELECT converted FROM transactions.recompress_progress WHERE source = 'transactions.transactions.input'").Scan(&converted)
+	if converted != 50 {
+		t.Errorf("expected progress to be stored, got %v", converted)
+	}
+	// A new recompressor resumes from the stored progress.
+	r = NewRecompressor(db, &sync.RWMutex{})
+	if err := r.recompress(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, target := range r.targets {
+		if target.next != 50 {
+			t.Errorf("%v: expected to resume from 50, got %v", target.source(), target.next)
+		}
+	}
+	// Nothing is converted once the deadline has passed.
+	db.Exec("DELETE FROM transactions.recompress_progress")
+	r = NewRecompressor(db, &sync.RWMutex{})
+	if err := r.recompress(context.Background(), time.Now()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	db.QueryRow("SELECT count(*) FROM transactions.recompress_progress").Scan(&converted)
+	if converted != 0 {
+		t.Errorf("expected no batches after the deadline, got %v", converted)
+	}
+}
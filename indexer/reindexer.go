@@ -0,0 +1,220 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+)
+
+var (
+	reindexMeter     = metrics.NewMinorMeter("/flume/reindex/blocks")
+	reindexNextGauge = metrics.NewMinorGauge("/flume/reindex/next")
+)
+
+// reindexBatch is the number of blocks committed per transaction.
+const reindexBatch = 100
+
+// Reindexer runs a set of indexers over a range of blocks fetched with
+// cardinal_streamsBlock, replacing the rows they produce for each block.
+//
+// Batches are fetched and indexed by concurrent workers and committed in
+// order under the indexer lock, each along with a checkpoint recording the
+// next block to process. A job is identified by its indexers and range, so
+// running it again resumes from its checkpoint, which is removed once the
+// range is complete.
+type Reindexer struct {
+	db          *sql.DB
+	mut         *sync.RWMutex
+	indexers    []Indexer
+	job         string
+	from, to    int64
+	concurrency int
+	newFetch    func() func(context.Context, int64) (*delivery.PendingBatch, error)
+}
+
+// NewReindexer returns a reindexer for blocks from through to. names
+// identifies the indexers for checkpointing. It fails if no websocket broker
+// is configured.
+func NewReindexer(db *sql.DB, cfg *config.Config, mut *sync.RWMutex, names []string, indexers []Indexer, from, to int64, concurrency int) (*Reindexer, error) {
+	wsURL := websocketBroker(cfg)
+	if wsURL == "" {
+		return nil, fmt.Errorf("the reindexer requires a websocket broker")
+	}
+	r := newReindexer(db, mut, names, indexers, from, to, concurrency)
+	r.newFetch = func() func(context.Context, int64) (*delivery.PendingBatch, error) {
+		return (&streamsClient{url: wsURL}).fetch
+	}
+	return r, nil
+}
+
+func newReindexer(db *sql.DB, mut *sync.RWMutex, names []string, indexers []Indexer, from, to int64, concurrency int) *Reindexer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Reindexer{
+		db:          db,
+		mut:         mut,
+		indexers:    indexers,
+		job:         fmt.Sprintf("%v:%v-%v", names, from, to),
+		from:        from,
+		to:          to,
+		concurrency: concurrency,
+	}
+}
+
+type reindexResult struct {
+	start      int64
+	end        int64
+	statements []Statement
+	err        error
+}
+
+// Run reindexes the range from its checkpoint, returning once the range is
+// complete, a batch fails or ctx is cancelled.
+func (r *Reindexer) Run(ctx context.Context) error {
+	next := r.from
+	var checkpoint sql.NullInt64
+	if err := r.db.QueryRowContext(ctx, "SELECT next FROM blocks.reindex_checkpoints WHERE job = ?;", r.job).Scan(&checkpoint); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if checkpoint.Valid {
+		next = checkpoint.Int64
+		log.Info("Resuming reindex", "job", r.job, "from", next)
+	}
+	if next > r.to {
+		return r.finish(ctx)
+	}
+
+	// Workers are cancelled and then waited for, so none is still indexing
+	// once Run returns.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	starts := make(chan int64)
+	results := make(chan reindexResult)
+	// window bounds the batches fetched ahead of the next one to commit.
+	window := make(chan struct{}, 2*r.concurrency)
+	go func() {
+		defer close(starts)
+		for start := next; start <= r.to; start += reindexBatch {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case starts <- start:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetch := r.newFetch()
+			for start := range starts {
+				result := r.index(ctx, fetch, start)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results are committed in order, so a failed batch is only reported once
+	// the batches before it are committed and checkpointed.
+	pending := make(map[int64]reindexResult)
+	for result := range results {
+		pending[result.start] = result
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if ready.err != nil {
+				return ready.err
+			}
+			if err := r.commit(ctx, ready); err != nil {
+				return err
+			}
+			<-window
+			next = ready.end + 1
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if next <= r.to {
+		return fmt.Errorf("reindex stopped at block %v", next)
+	}
+	return r.finish(ctx)
+}
+
+func (r *Reindexer) index(ctx context.Context, fetch func(context.Context, int64) (*delivery.PendingBatch, error), start int64) reindexResult {
+	result := reindexResult{start: start, end: start + reindexBatch - 1}
+	if result.end > r.to {
+		result.end = r.to
+	}
+	for number := start; number <= result.end; number++ {
+		if err := ctx.Err(); err != nil {
+			result.err = err
+			return result
+		}
+		statements, err := fetchStatements(ctx, r.db, fetch, r.indexers, number, false)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		result.statements = append(result.statements, statements...)
+	}
+	return result
+}
+
+func (r *Reindexer) commit(ctx context.Context, result reindexResult) error {
+	start := time.Now()
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	dbtx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer dbtx.Rollback()
+	sc := NewStatementCache(r.db)
+	defer sc.Close()
+	statements := append(result.statements, NewStatement("INSERT OR REPLACE INTO blocks.reindex_checkpoints(job, next) VALUES (?, ?)", r.job, result.end+1))
+	if err := sc.Exec(ctx, dbtx, statements); err != nil {
+		return err
+	}
+	if err := dbtx.Commit(); err != nil {
+		return err
+	}
+	reindexMeter.Mark(result.end - result.start + 1)
+	reindexNextGauge.Update(result.end + 1)
+	log.Info("Reindexed blocks", "from", result.start, "to", result.end, "in", time.Since(start))
+	return nil
+}
+
+func (r *Reindexer) finish(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM blocks.reindex_checkpoints WHERE job = ?;", r.job); err != nil {
+		return err
+	}
+	log.Info("Reindex complete", "job", r.job)
+	return nil
+}
@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+)
+
+func TestReindexer(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openControlDatabase(map[string]string{
+		"control": filepath.Join(dir, "reindexer.sqlite"),
+		"blocks":  filepath.Join(dir, "blocks.sqlite"),
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE blocks.blocks (number BIGINT PRIMARY KEY, hash varchar(32), parentHash varchar(32))",
+		"CREATE TABLE blocks.reindex_checkpoints (job TEXT PRIMARY KEY, next BIGINT)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for i := int64(1); i <= 300; i++ {
+		if _, err := db.Exec("INSERT INTO blocks.blocks(number, hash) VALUES (?, ?)", i, auditTestHash(i).Bytes()); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	var lock sync.Mutex
+	fetched := make(map[int64]int)
+	failAt := int64(150)
+	reindexer := newReindexer(db, &sync.RWMutex{}, []string{"blocks"}, []Indexer{auditTestIndexer{}}, 1, 250, 3)
+	reindexer.newFetch = func() func(context.Context, int64) (*delivery.PendingBatch, error) {
+		return func(ctx context.Context, number int64) (*delivery.PendingBatch, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if number == failAt {
+				return nil, fmt.Errorf("unavailable")
+			}
+			fetched[number]++
+			return &delivery.PendingBatch{Number: number, Hash: auditTestHash(number), ParentHash: auditTestHash(number - 1)}, nil
+		}
+	}
+
+	if err := reindexer.Run(context.Background()); err == nil {
+		t.Fatalf("expected the reindex to fail")
+	}
+	var next int64
+	if err := db.QueryRow("SELECT next FROM blocks.reindex_checkpoints").Scan(&next); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if next != 101 {
+		t.Errorf("expected a checkpoint at 101, got %v", next)
+	}
+
+	failAt = -1
+	fetched = make(map[int64]int)
+	if err := reindexer.Run(context.Background()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if fetched[100] != 0 || fetched[101] != 1 || fetched[250] != 1 || len(fetched) != 150 {
+		t.Errorf("expected the reindex to resume from 101, fetched %v blocks (100: %v, 101: %v, 250: %v)", len(fetched), fetched[100], fetched[101], fetched[250])
+	}
+	var count, linked int64
+	if err := db.QueryRow("SELECT count(*), count(parentHash) FROM blocks.blocks").Scan(&count, &linked); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if count != 300 || linked != 250 {
+		t.Errorf("expected 300 blocks with 250 reindexed, got %v with %v", count, linked)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM blocks.reindex_checkpoints").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected the checkpoint to be removed, got %v (%v)", count, err)
+	}
+}
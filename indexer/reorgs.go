@@ -0,0 +1,228 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/openrelayxyz/cardinal-evm/rlp"
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+)
+
+var (
+	reorgMeter     = metrics.NewMajorMeter("/flume/reorg")
+	reorgDepthHist = metrics.NewMajorHistogram("/flume/reorg/depth")
+)
+
+// reorgCheck records the blocks added by a ChainUpdate, so that they can be
+// compared against the blocks table immediately before the update's statements
+// are executed. index is the position of the update's first statement within
+// the preparedUpdate.
+type reorgCheck struct {
+	index   int
+	added   map[int64]types.Hash
+	first   int64
+	newHead types.Hash
+}
+
+func newReorgCheck(index int, added []*delivery.PendingBatch) *reorgCheck {
+	if len(added) == 0 {
+		return nil
+	}
+	check := &reorgCheck{
+		index:   index,
+		added:   make(map[int64]types.Hash),
+		first:   added[0].Number,
+		newHead: added[len(added)-1].Hash,
+	}
+	for _, pb := range added {
+		check.added[pb.Number] = pb.Hash
+		if pb.Number < check.first {
+			check.first = pb.Number
+		}
+	}
+	return check
+}
+
+// journal compares the blocks being replaced with those being added. If the
+// update replaces any block with a different hash, it returns a record of the
+// reorg. Blocks re-delivered with the same hash, as happens when resuming, are
+// not considered a reorg.
+func (check *reorgCheck) journal(ctx context.Context, dbtx *sql.Tx) (*reorgRecord, error) {
+	rows, err := dbtx.QueryContext(ctx, "SELECT number, hash FROM blocks.blocks WHERE number >= ? ORDER BY number", check.first)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	replaced := []types.Hash{}
+	var divergence int64
+	for rows.Next() {
+		var number int64
+		var hashBytes []byte
+		if err := rows.Scan(&number, &hashBytes); err != nil {
+			return nil, err
+		}
+		hash := types.BytesToHash(hashBytes)
+		if len(replaced) == 0 {
+			if addedHash, ok := check.added[number]; ok && addedHash == hash {
+				continue
+			}
+			divergence = number
+		}
+		replaced = append(replaced, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(replaced) == 0 {
+		return nil, nil
+	}
+	replacedRLP, err := rlp.EncodeToBytes(replaced)
+	if err != nil {
+		return nil, err
+	}
+	return &reorgRecord{
+		block:    divergence,
+		depth:    int64(len(replaced)),
+		replaced: replaced,
+		statement: NewStatement(
+			"INSERT INTO blocks.reorgs(block, depth, oldHead, newHead, replaced, time) VALUES (?, ?, ?, ?, ?, ?)",
+			divergence,
+			len(replaced),
+			replaced[len(replaced)-1],
+			check.newHead,
+			replacedRLP,
+			time.Now().Unix(),
+		),
+	}, nil
+}
+
+type reorgRecord struct {
+	block     int64
+	depth     int64
+	replaced  []types.Hash
+	statement Statement
+}
+
+const (
+	orphanedBlockColumns      = "number, hash, parentHash, uncleHash, coinbase, root, txRoot, receiptRoot, bloom, difficulty, gasLimit, gasUsed, `time`, extra, mixDigest, nonce, uncles, size, td, baseFee, withdrawalHash, blobGasUsed, excessBlobGas, parentBeaconRoot"
+	orphanedWithdrawalColumns = "wtdrlIndex, vldtrIndex, address, amount, block, blockHash"
+	orphanedTxColumns         = "block, gas, gasPrice, hash, input, nonce, recipient, transactionIndex, `value`, v, r, s, sender, func, contractAddress, cumulativeGasUsed, gasUsed, logsBloom, `status`, `type`, access_list, gasFeeCap, gasTipCap, maxFeePerBlobGas, blobVersionedHashes"
+	orphanedLogColumns        = "address, topic0, topic1, topic2, topic3, data, block, logIndex, transactionHash, transactionIndex, blockHash"
+)
+
+// reorgJournal tracks which of the reorg journal and orphaned tables are
+// available in the attached databases. Reorgs can only be detected when the
+// blocks database is present.
+type reorgJournal struct {
+	reorgs       bool
+	blocks       bool
+	transactions bool
+	logs         bool
+	threshold    int64
+}
+
+func hasTable(db *sql.DB, schema, table string) bool {
+	var name string
+	db.QueryRow(fmt.Sprintf("SELECT name FROM %v.sqlite_master WHERE type='table' AND name=?;", schema), table).Scan(&name)
+	return name == table
+}
+
+func newReorgJournal(db *sql.DB, threshold int64) *reorgJournal {
+	return &reorgJournal{
+		reorgs:       hasTable(db, "blocks", "reorgs"),
+		blocks:       hasTable(db, "blocks", "orphaned_blocks"),
+		transactions: hasTable(db, "transactions", "orphaned_transactions"),
+		logs:         hasTable(db, "logs", "orphaned_event_logs"),
+		threshold:    threshold,
+	}
+}
+
+// orphanStatements copies the rows about to be replaced by a reorg at block
+// into the orphaned tables, so they remain retrievable by hash.
+func (j *reorgJournal) orphanStatements(block int64) []Statement {
+	statements := []Statement{}
+	if j.blocks {
+		statements = append(statements,
+			NewStatement(fmt.Sprintf("INSERT OR REPLACE INTO blocks.orphaned_blocks(%v) SELECT %v FROM blocks.blocks WHERE number >= ?", orphanedBlockColumns, orphanedBlockColumns), block),
+			NewStatement(fmt.Sprintf("INSERT OR REPLACE INTO blocks.orphaned_withdrawals(%v) SELECT %v FROM blocks.withdrawals WHERE block >= ?", orphanedWithdrawalColumns, orphanedWithdrawalColumns), block),
+		)
+	}
+	if j.transactions {
+		statements = append(statements, NewStatement(fmt.Sprintf("INSERT OR REPLACE INTO transactions.orphaned_transactions(%v, blockHash) SELECT %v, blocks.hash FROM %v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE transactions.block >= ?", orphanedTxColumns, qualifyColumns("transactions", orphanedTxColumns), shards.Source("transactions", "transactions", uint64(block), math.MaxUint64, "")), block))
+	}
+	if j.logs {
+		statements = append(statements, NewStatement(fmt.Sprintf("INSERT OR REPLACE INTO logs.orphaned_event_logs(%v) SELECT %v FROM %v WHERE block >= ?", orphanedLogColumns, orphanedLogColumns, shards.Source("logs", "event_logs", uint64(block), math.MaxUint64, "")), block))
+	}
+	return statements
+}
+
+// pruneStatements removes orphaned rows that have fallen more than the reorg
+// threshold behind head.
+func (j *reorgJournal) pruneStatements(head int64) []Statement {
+	statements := []Statement{}
+	if j.blocks {
+		statements = append(statements,
+			NewStatement("DELETE FROM blocks.orphaned_blocks WHERE number < ?", head-j.threshold),
+			NewStatement("DELETE FROM blocks.orphaned_withdrawals WHERE block < ?", head-j.threshold),
+		)
+	}
+	if j.transactions {
+		statements = append(statements, NewStatement("DELETE FROM transactions.orphaned_transactions WHERE block < ?", head-j.threshold))
+	}
+	if j.logs {
+		statements = append(statements, NewStatement("DELETE FROM logs.orphaned_event_logs WHERE block < ?", head-j.threshold))
+	}
+	return statements
+}
+
+func qualifyColumns(table, columns string) string {
+	parts := strings.Split(columns, ", ")
+	for i, part := range parts {
+		parts[i] = fmt.Sprintf("%v.%v", table, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// execUpdate executes the statements of update within dbtx. Each ChainUpdate in
+// the preparedUpdate is checked for a reorg against the state left by the
+// statements before it. Reorgs are journaled and the rows they displace are
+// preserved before the update's own statements run, and the records of any
+// reorgs are returned.
+func execUpdate(ctx context.Context, sc *StatementCache, dbtx *sql.Tx, update *preparedUpdate, j *reorgJournal) ([]*reorgRecord, error) {
+	records := []*reorgRecord{}
+	start := 0
+	if j.reorgs {
+		for _, check := range update.reorgChecks {
+			if err := sc.Exec(ctx, dbtx, update.statements[start:check.index]); err != nil {
+				return nil, err
+			}
+			start = check.index
+			record, err := check.journal(ctx, dbtx)
+			if err != nil {
+				return nil, err
+			}
+			if record != nil {
+				if err := sc.Exec(ctx, dbtx, append([]Statement{record.statement}, j.orphanStatements(record.block)...)); err != nil {
+					return nil, err
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	if err := sc.Exec(ctx, dbtx, update.statements[start:]); err != nil {
+		return nil, err
+	}
+	if update.lastBatch != nil {
+		if err := sc.Exec(ctx, dbtx, j.pruneStatements(update.lastBatch.Number)); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
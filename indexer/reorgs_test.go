@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types"
+)
+
+func TestReorgJournal(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openControlDatabase(map[string]string{
+		"control": filepath.Join(dir, "reorgs.sqlite"),
+		"blocks":  filepath.Join(dir, "blocks.sqlite"),
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		fmt.Sprintf("CREATE TABLE blocks.blocks (%v)", orphanedBlockColumns),
+		fmt.Sprintf("CREATE TABLE blocks.withdrawals (%v)", orphanedWithdrawalColumns),
+		"CREATE TABLE blocks.reorgs (id INTEGER PRIMARY KEY AUTOINCREMENT, block BIGINT, depth MEDIUMINT, oldHead varchar(32), newHead varchar(32), replaced blob, time BIGINT)",
+		"CREATE TABLE blocks.orphaned_blocks AS SELECT * FROM blocks.blocks WHERE 0",
+		"CREATE UNIQUE INDEX blocks.orphanedBlockHash ON orphaned_blocks(hash)",
+		"CREATE TABLE blocks.orphaned_withdrawals AS SELECT * FROM blocks.withdrawals WHERE 0",
+		"INSERT INTO blocks.blocks(number, hash) VALUES (1, X'01'), (2, X'02'), (3, X'03')",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	journal := newReorgJournal(db, 128)
+	if !journal.reorgs || !journal.blocks || journal.transactions {
+		t.Fatalf("unexpected tables detected %+v", journal)
+	}
+	sc := NewStatementCache(db)
+	defer sc.Close()
+
+	replay := newReorgCheck(0, []*delivery.PendingBatch{
+		{Number: 2, Hash: types.BytesToHash([]byte{2})},
+		{Number: 3, Hash: types.BytesToHash([]byte{3})},
+	})
+	reorg := newReorgCheck(0, []*delivery.PendingBatch{
+		{Number: 2, Hash: types.BytesToHash([]byte{2})},
+		{Number: 3, Hash: types.BytesToHash([]byte{0x13})},
+		{Number: 4, Hash: types.BytesToHash([]byte{0x14})},
+	})
+	update := &preparedUpdate{reorgChecks: []*reorgCheck{replay, reorg}}
+
+	dbtx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	reorgs, err := execUpdate(context.Background(), sc, dbtx, update, journal)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := dbtx.Commit(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(reorgs) != 1 || reorgs[0].depth != 1 {
+		t.Fatalf("expected a single reorg of depth 1, got %v", reorgs)
+	}
+	var block, depth int64
+	var oldHead, newHead []byte
+	if err := db.QueryRow("SELECT block, depth, oldHead, newHead FROM blocks.reorgs").Scan(&block, &depth, &oldHead, &newHead); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if block != 3 || depth != 1 {
+		t.Errorf("unexpected reorg at block %v with depth %v", block, depth)
+	}
+	if types.BytesToHash(oldHead) != types.BytesToHash([]byte{3}) || types.BytesToHash(newHead) != types.BytesToHash([]byte{0x14}) {
+		t.Errorf("unexpected heads %x, %x", oldHead, newHead)
+	}
+	var orphaned int64
+	if err := db.QueryRow("SELECT number FROM blocks.orphaned_blocks WHERE hash = X'03'").Scan(&orphaned); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if orphaned != 3 {
+		t.Errorf("unexpected orphaned block %v", orphaned)
+	}
+}
@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-streams/transports"
+)
+
+// resumptionForTimestamp is replaced in tests.
+var resumptionForTimestamp = transports.ResumptionForTimestamp
+
+// rollbackTables are the core tables holding rows by block, including the
+// reorg journal and queues that the pruner leaves alone.
+var rollbackTables = []struct{ schema, table, column string }{
+	{"blocks", "blocks", "number"},
+	{"blocks", "withdrawals", "block"},
+	{"blocks", "orphaned_blocks", "number"},
+	{"blocks", "orphaned_withdrawals", "block"},
+	{"blocks", "reorgs", "block"},
+	{"blocks", "reindex_queue", "number"},
+	{"transactions", "transactions", "block"},
+	{"transactions", "orphaned_transactions", "block"},
+	{"logs", "event_logs", "block"},
+	{"logs", "orphaned_event_logs", "block"},
+}
+
+// Rollback removes every row at or above number from the core tables that are
+// present, their shards, and tables, which maps plugin tables to their block
+// columns as PrunedTables does, in a single transaction. cardinal_offsets is
+// rewritten to the Kafka offsets for the time of the block before number, so
+// that the consumer resumes from the new head.
+//
+// The caller must hold the indexer lock, if indexing is running.
+func Rollback(ctx context.Context, db *sql.DB, cfg *config.Config, tables map[string]string, number int64) error {
+	var head sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT max(number) FROM blocks.blocks;").Scan(&head); err != nil {
+		return err
+	}
+	if !head.Valid || number > head.Int64 {
+		return fmt.Errorf("block %v is beyond the head", number)
+	}
+	var timestamp int64
+	if err := db.QueryRowContext(ctx, "SELECT time FROM blocks.blocks WHERE number = ?;", number-1).Scan(&timestamp); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("block %v is not indexed, rolling back to it would leave no head", number-1)
+		}
+		return err
+	}
+	resumption, err := rollbackResumption(cfg, timestamp)
+	if err != nil {
+		return fmt.Errorf("finding the resumption for block %v: %w", number-1, err)
+	}
+
+	qualified := make(map[string]string)
+	for _, t := range rollbackTables {
+		if hasTable(db, t.schema, t.table) {
+			for _, table := range shards.All(t.schema, t.table) {
+				qualified[table] = t.column
+			}
+		}
+	}
+	for table, column := range tables {
+		qualified[table] = column
+	}
+	names := make([]string, 0, len(qualified))
+	for table := range qualified {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	dbtx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer dbtx.Rollback()
+	for _, table := range names {
+		if _, err := dbtx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %v WHERE %v >= ?;", table, qualified[table]), number); err != nil {
+			return fmt.Errorf("%v: %w", table, err)
+		}
+	}
+	statements := append([]Statement{NewStatement("DELETE FROM blocks.cardinal_offsets")}, resumptionStatements(resumption)...)
+	for _, s := range statements {
+		if _, err := dbtx.ExecContext(ctx, s.Query, s.Args...); err != nil {
+			return err
+		}
+	}
+	if err := dbtx.Commit(); err != nil {
+		return err
+	}
+	log.Info("Rolled back", "from", head.Int64, "to", number-1, "tables", len(names), "resumption", resumption)
+	return nil
+}
+
+// rollbackResumption returns the resumption for timestamp, in seconds, from
+// the Kafka brokers. Other brokers resume from the head block, so they need
+// no offsets.
+func rollbackResumption(cfg *config.Config, timestamp int64) (string, error) {
+	brokers := []transports.BrokerParams{}
+	for _, broker := range cfg.BrokerParams {
+		if strings.HasPrefix(strings.TrimPrefix(broker.URL, "cardinal://"), "kafka://") {
+			brokers = append(brokers, broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return "", nil
+	}
+	resumption, err := resumptionForTimestamp(brokers, timestamp*1000)
+	if err != nil {
+		return "", err
+	}
+	return string(resumption), nil
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/openrelayxyz/cardinal-streams/transports"
+
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/migrations"
+)
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"control":      filepath.Join(dir, "rollback.sqlite"),
+		"blocks":       filepath.Join(dir, "blocks.sqlite"),
+		"transactions": filepath.Join(dir, "transactions.sqlite"),
+		"logs":         filepath.Join(dir, "logs.sqlite"),
+	}
+	db, err := openControlDatabase(databases)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := migrations.Migrate(db, migrations.Core(databases), 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("CREATE TABLE blocks.plugin_rows (height BIGINT)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := int64(1); i <= 10; i++ {
+		for _, statement := range []string{
+			"INSERT INTO blocks.blocks(number, hash, time) VALUES (?1, ?1, ?1 * 12)",
+			"INSERT INTO blocks.withdrawals(block, wtdrlIndex) VALUES (?1, 0)",
+			"INSERT INTO transactions.transactions(block, transactionIndex) VALUES (?1, 0)",
+			"INSERT INTO logs.event_logs(block, logIndex) VALUES (?1, 0)",
+			"INSERT INTO blocks.plugin_rows(height) VALUES (?1)",
+		} {
+			if _, err := db.Exec(statement, i); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+	}
+	if _, err := db.Exec("INSERT INTO blocks.reindex_queue(number) VALUES (3), (8)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("INSERT INTO blocks.cardinal_offsets(offset, partition, topic) VALUES (900, 0, 'blocks'), (900, 1, 'blocks')"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var requested int64
+	resumptionForTimestamp = func(brokers []transports.BrokerParams, timestamp int64) ([]byte, error) {
+		requested = timestamp
+		return []byte("blocks:0=42"), nil
+	}
+	defer func() { resumptionForTimestamp = transports.ResumptionForTimestamp }()
+	cfg := &config.Config{BrokerParams: []transports.BrokerParams{{URL: "kafka://localhost:9092", Topics: []string{"blocks"}}}}
+	tables := map[string]string{"blocks.plugin_rows": "height"}
+
+	if err := Rollback(context.Background(), db, cfg, tables, 11); err == nil {
+		t.Errorf("expected an error rolling back beyond the head")
+	}
+	if err := Rollback(context.Background(), db, cfg, tables, 1); err == nil {
+		t.Errorf("expected an error rolling back every block")
+	}
+	if err := Rollback(context.Background(), db, cfg, tables, 7); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if requested != 6*12*1000 {
+		t.Errorf("expected the resumption for block 6's time, got %v", requested)
+	}
+	for _, query := range []string{
+		"SELECT count(*), max(number) FROM blocks.blocks",
+		"SELECT count(*), max(block) FROM blocks.withdrawals",
+		"SELECT count(*), max(block) FROM transactions.transactions",
+		"SELECT count(*), max(block) FROM logs.event_logs",
+		"SELECT count(*), max(height) FROM blocks.plugin_rows",
+	} {
+		var count, max int64
+		if err := db.QueryRow(query).Scan(&count, &max); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if count != 6 || max != 6 {
+			t.Errorf("%v: expected 6 rows up to 6, got %v up to %v", query, count, max)
+		}
+	}
+	var queued int64
+	db.QueryRow("SELECT count(*) FROM blocks.reindex_queue").Scan(&queued)
+	if queued != 1 {
+		t.Errorf("expected one queued block below the rollback, got %v", queued)
+	}
+	rows, err := db.Query("SELECT topic, partition, offset FROM blocks.cardinal_offsets")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer rows.Close()
+	offsets := []string{}
+	for rows.Next() {
+		var topic string
+		var partition, offset int64
+		rows.Scan(&topic, &partition, &offset)
+		offsets = append(offsets, fmt.Sprintf("%v:%v=%v", topic, partition, offset))
+	}
+	if len(offsets) != 1 || offsets[0] != "blocks:0=42" {
+		t.Errorf("unexpected offsets after rollback: %v", offsets)
+	}
+}
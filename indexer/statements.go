@@ -0,0 +1,233 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-streams/delivery"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+)
+
+// NewStatement builds a Statement, converting each parameter into the value
+// that would be stored by ApplyParameters for the same input.
+func NewStatement(query string, params ...interface{}) Statement {
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		args[i] = bindParameter(param)
+	}
+	return Statement{Query: query, Args: args}
+}
+
+func bytesOrNull(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// bindUint binds an unsigned integer as SQLite would read it from the literal
+// ApplyParameters writes: values beyond the int64 range become REAL.
+func bindUint(value uint64) interface{} {
+	if value > math.MaxInt64 {
+		return float64(value)
+	}
+	return int64(value)
+}
+
+func bindParameter(param interface{}) interface{} {
+	switch value := param.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return bytesOrNull(value)
+	case *common.Address:
+		if value == nil {
+			return nil
+		}
+		return bytesOrNull(trimPrefix(value.Bytes()))
+	case *types.Hash:
+		if value == nil {
+			return nil
+		}
+		return bytesOrNull(trimPrefix(value.Bytes()))
+	case common.Address:
+		return bytesOrNull(trimPrefix(value.Bytes()))
+	case *big.Int:
+		if value == nil {
+			return nil
+		}
+		return bytesOrNull(trimPrefix(value.Bytes()))
+	case bytesable:
+		if value == nil {
+			return nil
+		}
+		return bytesOrNull(trimPrefix(value.Bytes()))
+	case hexutil.Bytes:
+		return bytesOrNull([]byte(value))
+	case *hexutil.Big:
+		if value == nil {
+			return nil
+		}
+		return trimPrefix(value.ToInt().Bytes())
+	case hexutil.Uint64:
+		return bindUint(uint64(value))
+	case *uint64:
+		if value == nil {
+			return nil
+		}
+		return bindUint(*value)
+	case uint64:
+		return bindUint(value)
+	case uint:
+		return bindUint(uint64(value))
+	case uint32:
+		return int64(value)
+	case uint16:
+		return int64(value)
+	case uint8:
+		return int64(value)
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	}
+	return param
+}
+
+// String renders the statement with its arguments inlined, in the same form
+// ApplyParameters would have produced.
+func (s Statement) String() string {
+	if len(s.Args) == 0 {
+		return s.Query
+	}
+	rendered := make([]interface{}, len(s.Args))
+	for i, arg := range s.Args {
+		switch value := arg.(type) {
+		case nil:
+			rendered[i] = "NULL"
+		case []byte:
+			rendered[i] = fmt.Sprintf("X'%x'", value)
+		case string:
+			rendered[i] = fmt.Sprintf("'%v'", strings.ReplaceAll(value, "'", "''"))
+		default:
+			rendered[i] = fmt.Sprintf("%v", value)
+		}
+	}
+	return fmt.Sprintf(strings.ReplaceAll(strings.ReplaceAll(s.Query, "%", "%%"), "?", "%v"), rendered...)
+}
+
+// RenderStatements converts a set of Statements into raw SQL strings, for
+// consumers of the Indexer interface.
+func RenderStatements(statements []Statement) []string {
+	results := make([]string, len(statements))
+	for i, s := range statements {
+		results[i] = s.String()
+	}
+	return results
+}
+
+// BatchStatements gets the statements for a PendingBatch from any indexer.
+// Indexers that only produce raw SQL strings are wrapped as statements without
+// arguments.
+func BatchStatements(idx Indexer, pb *delivery.PendingBatch) ([]Statement, error) {
+	if sidx, ok := idx.(StatementIndexer); ok {
+		return sidx.IndexStatements(pb)
+	}
+	raw, err := idx.Index(pb)
+	if err != nil {
+		return nil, err
+	}
+	statements := make([]Statement, len(raw))
+	for i, s := range raw {
+		statements[i] = Statement{Query: s}
+	}
+	return statements, nil
+}
+
+// maxCachedStatements bounds the number of distinct queries held as prepared
+// statements, in case a plugin generates unbounded query text.
+const maxCachedStatements = 1024
+
+// StatementCache holds prepared statements for reuse across transactions.
+type StatementCache struct {
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
+	lock  sync.Mutex
+}
+
+func NewStatementCache(db *sql.DB) *StatementCache {
+	return &StatementCache{
+		db:    db,
+		stmts: make(map[string]*sql.Stmt),
+	}
+}
+
+func (sc *StatementCache) prepared(ctx context.Context, query string) (*sql.Stmt, error) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if stmt, ok := sc.stmts[query]; ok {
+		return stmt, nil
+	}
+	if len(sc.stmts) >= maxCachedStatements {
+		return nil, nil
+	}
+	stmt, err := sc.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	sc.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Exec executes a set of statements within dbtx. Statements with arguments
+// are run as cached prepared statements, while statements without arguments
+// (typically legacy plugin output with inlined values) are executed directly.
+func (sc *StatementCache) Exec(ctx context.Context, dbtx *sql.Tx, statements []Statement) error {
+	txStmts := make(map[string]*sql.Stmt)
+	for _, s := range statements {
+		if len(s.Args) == 0 {
+			if _, err := dbtx.ExecContext(ctx, s.Query); err != nil {
+				return fmt.Errorf("%v: %v", err.Error(), s.Query)
+			}
+			continue
+		}
+		txStmt, ok := txStmts[s.Query]
+		if !ok {
+			stmt, err := sc.prepared(ctx, s.Query)
+			if err != nil {
+				return fmt.Errorf("%v: %v", err.Error(), s.Query)
+			}
+			if stmt != nil {
+				txStmt = dbtx.StmtContext(ctx, stmt)
+				txStmts[s.Query] = txStmt
+			}
+		}
+		var err error
+		if txStmt == nil {
+			_, err = dbtx.ExecContext(ctx, s.Query, s.Args...)
+		} else {
+			_, err = txStmt.ExecContext(ctx, s.Args...)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", err.Error(), s.String())
+		}
+	}
+	return nil
+}
+
+// Close releases all prepared statements held by the cache.
+func (sc *StatementCache) Close() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	for query, stmt := range sc.stmts {
+		stmt.Close()
+		delete(sc.stmts, query)
+	}
+}
@@ -0,0 +1,62 @@
+package indexer
+
+import (
+	"database/sql"
+	"math"
+	"math/big"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+)
+
+func TestStatementString(t *testing.T) {
+	addr := common.HexToAddress("0x000000000000000000000000000000000000dead")
+	hash := types.HexToHash("0x00000000000000000000000000000000000000000000000000000000000000ff")
+	var nilNumber *uint64
+	number := uint64(42)
+	params := []interface{}{
+		&addr,
+		&hash,
+		big.NewInt(0),
+		big.NewInt(1024),
+		[]byte{},
+		hexutil.Bytes{1, 2, 3},
+		hexutil.Uint64(7),
+		nilNumber,
+		&number,
+		uint64(99),
+	}
+	legacy := ApplyParameters("INSERT INTO t VALUES (%v, %v, %v, %v, %v, %v, %v, %v, %v, %v)", params...)
+	statement := NewStatement("INSERT INTO t VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", params...)
+	if statement.String() != legacy {
+		t.Errorf("rendered statement mismatch: %v != %v", statement.String(), legacy)
+	}
+}
+
+func TestBindLargeUint(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (v BIGINT, w BIGINT)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	value := uint64(math.MaxUint64)
+	legacy := ApplyParameters("INSERT INTO t VALUES (%v, %v)", value, &value)
+	if _, err := db.Exec(legacy); err != nil {
+		t.Fatalf(err.Error())
+	}
+	statement := NewStatement("INSERT INTO t VALUES (?, ?)", value, &value)
+	if _, err := db.Exec(statement.Query, statement.Args...); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var distinct, negative int
+	db.QueryRow("SELECT count(DISTINCT v) + count(DISTINCT w) - 1, count(*) FILTER (WHERE v < 0 OR w < 0) FROM t").Scan(&distinct, &negative)
+	if distinct != 1 || negative != 0 {
+		t.Errorf("bound values differ from legacy literals: %v distinct, %v negative", distinct, negative)
+	}
+}
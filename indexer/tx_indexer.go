@@ -11,6 +11,9 @@ import (
 	evm "github.com/openrelayxyz/cardinal-evm/types"
 	"github.com/openrelayxyz/cardinal-streams/delivery"
 	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 	"math/big"
 	"regexp"
 	"strconv"
@@ -35,18 +38,30 @@ type TxIndexer struct {
 	eip155Block    uint64
 	homesteadBlock uint64
 	hasMempool     bool
+	interning      bool
 }
 
-func NewTxIndexer(chainid, eip155block, homesteadblock uint64, hasMempool bool) Indexer {
+// NewTxIndexer returns a transaction indexer. With interning, senders,
+// recipients and contract addresses are written as ids in the intern tables.
+func NewTxIndexer(chainid, eip155block, homesteadblock uint64, hasMempool, interning bool) Indexer {
 	return &TxIndexer{
 		chainid:        chainid,
 		eip155Block:    eip155block,
 		homesteadBlock: homesteadblock,
 		hasMempool: hasMempool,
+		interning: interning,
 	}
 }
 
 func (indexer *TxIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
+	statements, err := indexer.IndexStatements(pb)
+	if err != nil {
+		return nil, err
+	}
+	return RenderStatements(statements), nil
+}
+
+func (indexer *TxIndexer) IndexStatements(pb *delivery.PendingBatch) ([]Statement, error) {
 	headerBytes := pb.Values[fmt.Sprintf("c/%x/b/%x/h", indexer.chainid, pb.Hash.Bytes())]
 	header := &evm.Header{}
 	if err := rlp.DecodeBytes(headerBytes, &header); err != nil {
@@ -101,9 +116,12 @@ func (indexer *TxIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 		}
 	}
 
-	statements := make([]string, 0, len(txData)+1)
+	statements := make([]Statement, 0, len(txData)+1)
 
-	statements = append(statements, ApplyParameters("DELETE FROM transactions.transactions WHERE block >= %v", pb.Number))
+	for _, table := range shards.Range("transactions", "transactions", uint64(pb.Number), math.MaxUint64) {
+		statements = append(statements, NewStatement(fmt.Sprintf("DELETE FROM %v WHERE block >= ?", table), pb.Number))
+	}
+	table := shards.Table("transactions", "transactions", uint64(pb.Number))
 
 	for i := 0; i < len(txData); i++ {
 		transaction := txData[int(i)]
@@ -126,9 +144,14 @@ func (indexer *TxIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 			blobFeeCap = trimPrefix(transaction.BlobGasFeeCap().Bytes())
 			blobVersionedHashes, _ = rlp.EncodeToBytes(transaction.BlobHashes())
 		}
-		input := getCopy(compress(transaction.Data()))
-		statements = append(statements, ApplyParameters(
-			"INSERT INTO transactions.transactions(block, gas, gasPrice, hash, input, nonce, recipient, transactionIndex, `value`, v, r, s, sender, func, contractAddress, cumulativeGasUsed, gasUsed, logsBloom, `status`, `type`, access_list, gasFeeCap, gasTipCap, maxFeePerBlobGas, blobVersionedHashes) VALUES (%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v)",
+		input := codec.Compress("transactions.input", transaction.Data())
+		contractAddress := nullZeroAddress(receipt.ContractAddress)
+		var recipient, senderValue, contractValue string
+		statements, recipient = indexer.address(statements, transaction.To())
+		statements, senderValue = indexer.address(statements, sender)
+		statements, contractValue = indexer.address(statements, contractAddress)
+		statements = append(statements, NewStatement(
+			fmt.Sprintf("INSERT INTO %v(block, gas, gasPrice, hash, input, nonce, recipient, transactionIndex, `value`, v, r, s, sender, func, contractAddress, cumulativeGasUsed, gasUsed, logsBloom, `status`, `type`, access_list, gasFeeCap, gasTipCap, maxFeePerBlobGas, blobVersionedHashes) VALUES (?, ?, ?, ?, ?, ?, %v, ?, ?, ?, ?, ?, %v, ?, %v, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", table, recipient, senderValue, contractValue),
 			pb.Number,
 			transaction.Gas(),
 			gasPrice,
@@ -143,21 +166,21 @@ func (indexer *TxIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 			s,
 			sender,
 			getFuncSig(transaction.Data()),
-			nullZeroAddress(receipt.ContractAddress),
+			contractAddress,
 			receipt.CumulativeGasUsed,
 			receipt.GasUsed,
-			getCopy(compress(receipt.LogsBloom)),
+			compress(receipt.LogsBloom),
 			receipt.Status,
 			transaction.Type(),
-			compress(accessListRLP),
+			codec.Compress("transactions.access_list", accessListRLP),
 			trimPrefix(transaction.GasFeeCap().Bytes()),
 			trimPrefix(transaction.GasTipCap().Bytes()),
 			blobFeeCap,
 			blobVersionedHashes,
 		))
 		if indexer.hasMempool {
-			statements = append(statements, ApplyParameters(
-				"DELETE FROM mempool.transactions WHERE sender = %v AND nonce = %v AND (sender, nonce) IN (SELECT sender, nonce FROM transactions.transactions WHERE sender = %v AND nonce = %v)",
+			statements = append(statements, NewStatement(
+				fmt.Sprintf("DELETE FROM mempool.transactions WHERE sender = ? AND nonce = ? AND EXISTS (SELECT 1 FROM %v WHERE sender = %v AND nonce = ?)", table, senderValue),
 				sender,
 				transaction.Nonce(),
 				sender,
@@ -167,3 +190,12 @@ func (indexer *TxIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 	}
 	return statements, nil
 }
+
+// address returns the placeholder for an address column. When interning, it
+// appends the statement interning value and the placeholder looks up its id.
+func (indexer *TxIndexer) address(statements []Statement, value interface{}) ([]Statement, string) {
+	if !indexer.interning || bindParameter(value) == nil {
+		return statements, "?"
+	}
+	return append(statements, NewStatement(intern.Insert(intern.Addresses), value)), intern.Lookup(intern.Addresses)
+}
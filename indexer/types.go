@@ -4,6 +4,23 @@ import (
 	"github.com/openrelayxyz/cardinal-streams/delivery"
 )
 
+// Indexer produces raw SQL statements for a PendingBatch. It remains the
+// interface plugins are expected to return, and is adapted into Statements by
+// BatchStatements.
 type Indexer interface {
 	Index(*delivery.PendingBatch) ([]string, error)
 }
+
+// Statement is a SQL query along with the arguments to be bound to it when it
+// is executed.
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// StatementIndexer is implemented by indexers that can emit parameterized
+// statements, which are executed as cached prepared statements rather than
+// being parsed anew for every block.
+type StatementIndexer interface {
+	IndexStatements(*delivery.PendingBatch) ([]Statement, error)
+}
@@ -0,0 +1,110 @@
+package integrity
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/openrelayxyz/cardinal-evm/crypto"
+	"github.com/openrelayxyz/cardinal-evm/rlp"
+	"github.com/openrelayxyz/cardinal-types"
+)
+
+// emptyRoot is the root of a trie with no entries.
+var emptyRoot = types.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+type trieEntry struct {
+	key   []byte // nibbles
+	value []byte
+}
+
+// listRoot returns the root of the Merkle Patricia trie mapping the RLP
+// encoded index of each value to the value, as committed to by a header's
+// transaction and receipt roots.
+func listRoot(values [][]byte) types.Hash {
+	if len(values) == 0 {
+		return emptyRoot
+	}
+	entries := make([]trieEntry, len(values))
+	for i, value := range values {
+		entries[i] = trieEntry{key: nibbles(rlp.AppendUint64(nil, uint64(i))), value: value}
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+	return crypto.Keccak256Hash(encodeNode(entries, 0))
+}
+
+func nibbles(key []byte) []byte {
+	result := make([]byte, 2*len(key))
+	for i, b := range key {
+		result[2*i] = b >> 4
+		result[2*i+1] = b & 0x0f
+	}
+	return result
+}
+
+// compactKey applies hex prefix encoding to a path of nibbles.
+func compactKey(path []byte, leaf bool) []byte {
+	var flag byte
+	if leaf {
+		flag = 2
+	}
+	result := []byte{}
+	if len(path)%2 == 1 {
+		result = append(result, (flag+1)<<4|path[0])
+		path = path[1:]
+	} else {
+		result = append(result, flag<<4)
+	}
+	for i := 0; i < len(path); i += 2 {
+		result = append(result, path[i]<<4|path[i+1])
+	}
+	return result
+}
+
+// reference returns how a node is embedded in its parent: inline if its
+// encoding is shorter than a hash, and by hash otherwise.
+func reference(encoded []byte) interface{} {
+	if len(encoded) < 32 {
+		return rlp.RawValue(encoded)
+	}
+	return crypto.Keccak256(encoded)
+}
+
+// encodeNode returns the encoding of the node holding entries, which are
+// sorted and share their first depth nibbles.
+func encodeNode(entries []trieEntry, depth int) []byte {
+	var encoded []byte
+	if len(entries) == 1 {
+		encoded, _ = rlp.EncodeToBytes([]interface{}{compactKey(entries[0].key[depth:], true), entries[0].value})
+		return encoded
+	}
+	first, last := entries[0].key, entries[len(entries)-1].key
+	prefix := 0
+	for depth+prefix < len(first) && depth+prefix < len(last) && first[depth+prefix] == last[depth+prefix] {
+		prefix++
+	}
+	if prefix > 0 {
+		child := encodeNode(entries, depth+prefix)
+		encoded, _ = rlp.EncodeToBytes([]interface{}{compactKey(first[depth:depth+prefix], false), reference(child)})
+		return encoded
+	}
+	branch := make([]interface{}, 17)
+	for i := range branch {
+		branch[i] = []byte{}
+	}
+	for start := 0; start < len(entries); {
+		if len(entries[start].key) == depth {
+			branch[16] = entries[start].value
+			start++
+			continue
+		}
+		nibble := entries[start].key[depth]
+		end := start + 1
+		for end < len(entries) && len(entries[end].key) > depth && entries[end].key[depth] == nibble {
+			end++
+		}
+		branch[nibble] = reference(encodeNode(entries[start:end], depth+1))
+		start = end
+	}
+	encoded, _ = rlp.EncodeToBytes(branch)
+	return encoded
+}
@@ -0,0 +1,388 @@
+package integrity
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+	log "github.com/inconshreveable/log15"
+	"github.com/openrelayxyz/cardinal-evm/common"
+	"github.com/openrelayxyz/cardinal-evm/rlp"
+	evm "github.com/openrelayxyz/cardinal-evm/types"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/metrics"
+
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+)
+
+var (
+	verifyCheckedMeter  = metrics.NewMinorMeter("/flume/verify/checked")
+	verifyMismatchMeter = metrics.NewMinorMeter("/flume/verify/mismatched")
+)
+
+// verifyChunk is the number of blocks read per query.
+const verifyChunk = 1000
+
+// Mismatch describes a block whose stored rows do not reproduce its header.
+type Mismatch struct {
+	Block       uint64 `json:"block"`
+	TxRoot      bool   `json:"txRoot,omitempty"`
+	ReceiptRoot bool   `json:"receiptRoot,omitempty"`
+	ParentHash  bool   `json:"parentHash,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Report summarizes a verification run. Receipts counts the blocks whose
+// receipt root could be checked.
+type Report struct {
+	From       uint64     `json:"from"`
+	To         uint64     `json:"to"`
+	Checked    uint64     `json:"checked"`
+	Receipts   uint64     `json:"receipts"`
+	Mismatches []Mismatch `json:"mismatches"`
+	Queued     int        `json:"queued"`
+}
+
+// Verifier recomputes the transaction and receipt roots of stored blocks from
+// their transaction and log rows and checks them against the stored headers,
+// along with each block's link to its parent.
+//
+// Receipts before Byzantium commit to an intermediate state root that is not
+// stored, so their roots are not checked, and neither are any receipt roots
+// when the logs database is not attached.
+type Verifier struct {
+	db        *sql.DB
+	chainid   *big.Int
+	byzantium uint64
+	hasTx     bool
+	hasLogs   bool
+}
+
+func NewVerifier(db *sql.DB, cfg *config.Config) *Verifier {
+	return &Verifier{
+		db:        db,
+		chainid:   new(big.Int).SetUint64(cfg.Chainid),
+		byzantium: cfg.ByzantiumBlock,
+		hasTx:     hasTable(db, "transactions", "transactions"),
+		hasLogs:   hasTable(db, "logs", "event_logs"),
+	}
+}
+
+// Verify checks blocks from through to. When queue is set, mismatched blocks
+// are added to blocks.reindex_queue for the gap auditor to re-index.
+func (v *Verifier) Verify(ctx context.Context, from, to uint64, queue bool) (*Report, error) {
+	if !v.hasTx {
+		return nil, fmt.Errorf("verification requires the transactions database")
+	}
+	report := &Report{From: from, To: to, Mismatches: []Mismatch{}}
+	parent := &storedBlock{}
+	if from > 0 {
+		parent.number = from - 1
+		v.db.QueryRowContext(ctx, "SELECT hash FROM blocks.blocks WHERE number = ?;", parent.number).Scan(&parent.hash)
+	}
+	for start := from; start <= to; start += verifyChunk {
+		end := start + verifyChunk - 1
+		if end > to || end < start {
+			end = to
+		}
+		mismatches, err := v.verifyChunk(ctx, start, end, parent, report)
+		if err != nil {
+			return report, err
+		}
+		if queue {
+			for _, m := range mismatches {
+				if _, err := v.db.ExecContext(ctx, "INSERT OR IGNORE INTO blocks.reindex_queue(number) VALUES (?);", m.Block); err != nil {
+					return report, err
+				}
+				report.Queued++
+			}
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+		verifyMismatchMeter.Mark(int64(len(mismatches)))
+		if end == to {
+			break
+		}
+	}
+	if len(report.Mismatches) > 0 {
+		log.Warn("Stored blocks do not match their headers", "from", from, "to", to, "mismatched", len(report.Mismatches), "first", report.Mismatches[0].Block)
+	}
+	return report, nil
+}
+
+type storedBlock struct {
+	number      uint64
+	hash        []byte
+	parentHash  []byte
+	txRoot      []byte
+	receiptRoot []byte
+	txs         [][]byte
+	receipts    []*storedReceipt
+}
+
+type storedReceipt struct {
+	txType            uint8
+	status            uint64
+	cumulativeGasUsed uint64
+	bloom             []byte
+	logs              []storedLog
+}
+
+type storedLog struct {
+	Address common.Address
+	Topics  []types.Hash
+	Data    []byte
+}
+
+// verifyChunk checks blocks from through to, linking the first to parent,
+// which is left holding the last block checked.
+func (v *Verifier) verifyChunk(ctx context.Context, from, to uint64, parent *storedBlock, report *Report) ([]Mismatch, error) {
+	blocks := []*storedBlock{}
+	byNumber := make(map[uint64]*storedBlock)
+	rows, err := v.db.QueryContext(ctx, "SELECT number, hash, parentHash, txRoot, receiptRoot FROM blocks.blocks WHERE number >= ? AND number <= ? ORDER BY number;", from, to)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		b := &storedBlock{}
+		if err := rows.Scan(&b.number, &b.hash, &b.parentHash, &b.txRoot, &b.receiptRoot); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blocks = append(blocks, b)
+		byNumber[b.number] = b
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	failed := make(map[uint64]string)
+	if err := v.loadTransactions(ctx, from, to, byNumber, failed); err != nil {
+		return nil, err
+	}
+	if v.hasLogs {
+		if err := v.loadLogs(ctx, from, to, byNumber, failed); err != nil {
+			return nil, err
+		}
+	}
+
+	mismatches := []Mismatch{}
+	for _, b := range blocks {
+		m := Mismatch{Block: b.number}
+		if len(parent.hash) > 0 && parent.number+1 == b.number && !bytes.Equal(parent.hash, b.parentHash) {
+			m.ParentHash = true
+		}
+		parent.number, parent.hash = b.number, b.hash
+		if msg, ok := failed[b.number]; ok {
+			m.Error = msg
+		} else {
+			if listRoot(b.txs) != types.BytesToHash(b.txRoot) {
+				m.TxRoot = true
+			}
+			if v.hasLogs && b.number >= v.byzantium {
+				report.Receipts++
+				receipts := make([][]byte, len(b.receipts))
+				for i, r := range b.receipts {
+					receipts[i], err = r.encode()
+					if err != nil {
+						return nil, err
+					}
+				}
+				if listRoot(receipts) != types.BytesToHash(b.receiptRoot) {
+					m.ReceiptRoot = true
+				}
+			}
+		}
+		if m.TxRoot || m.ReceiptRoot || m.ParentHash || m.Error != "" {
+			mismatches = append(mismatches, m)
+		}
+	}
+	report.Checked += uint64(len(blocks))
+	verifyCheckedMeter.Mark(int64(len(blocks)))
+	return mismatches, nil
+}
+
+// loadTransactions rebuilds the consensus encoding of each stored transaction
+// and its receipt, recording blocks whose rows cannot be decoded in failed.
+func (v *Verifier) loadTransactions(ctx context.Context, from, to uint64, blocks map[uint64]*storedBlock, failed map[uint64]string) error {
+	rows, err := v.db.QueryContext(ctx, fmt.Sprintf("SELECT block, transactionIndex, type, nonce, gasPrice, gasFeeCap, gasTipCap, gas, %v, value, input, access_list, v, r, s, maxFeePerBlobGas, blobVersionedHashes, cumulativeGasUsed, logsBloom, status FROM %v WHERE block >= ? AND block <= ? ORDER BY block, transactionIndex;", intern.Address("recipient"), shards.Source("transactions", "transactions", from, to, "")), from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number, nonce, gasPrice, gas, cumulativeGasUsed, status uint64
+		var index int
+		var txType sql.NullInt64
+		var vValue int64
+		var gasFeeCap, gasTipCap, recipient, value, input, accessList, r, s, blobFeeCap, blobHashes, bloom []byte
+		if err := rows.Scan(&number, &index, &txType, &nonce, &gasPrice, &gasFeeCap, &gasTipCap, &gas, &recipient, &value, &input, &accessList, &vValue, &r, &s, &blobFeeCap, &blobHashes, &cumulativeGasUsed, &bloom, &status); err != nil {
+			return err
+		}
+		b, ok := blocks[number]
+		if !ok {
+			continue
+		}
+		if _, ok := failed[number]; ok {
+			continue
+		}
+		if index != len(b.txs) {
+			failed[number] = fmt.Sprintf("missing transaction %v", len(b.txs))
+			continue
+		}
+		input, err = decompress(input)
+		if err == nil {
+			accessList, err = decompress(accessList)
+		}
+		if err == nil {
+			bloom, err = decompress(bloom)
+		}
+		if err != nil {
+			failed[number] = fmt.Sprintf("transaction %v: %v", index, err.Error())
+			continue
+		}
+		var to *common.Address
+		if len(recipient) > 0 {
+			addr := common.BytesToAddress(recipient)
+			to = &addr
+		}
+		var access evm.AccessList
+		if len(accessList) > 0 {
+			if err := rlp.DecodeBytes(accessList, &access); err != nil {
+				failed[number] = fmt.Sprintf("transaction %v access list: %v", index, err.Error())
+				continue
+			}
+		}
+		bigV := big.NewInt(vValue)
+		bigR := new(big.Int).SetBytes(r)
+		bigS := new(big.Int).SetBytes(s)
+		var inner evm.TxData
+		switch {
+		case !txType.Valid || txType.Int64 == evm.LegacyTxType:
+			inner = &evm.LegacyTx{Nonce: nonce, GasPrice: new(big.Int).SetUint64(gasPrice), Gas: gas, To: to, Value: new(big.Int).SetBytes(value), Data: input, V: bigV, R: bigR, S: bigS}
+		case txType.Int64 == evm.AccessListTxType:
+			inner = &evm.AccessListTx{ChainID: v.chainid, Nonce: nonce, GasPrice: new(big.Int).SetUint64(gasPrice), Gas: gas, To: to, Value: new(big.Int).SetBytes(value), Data: input, AccessList: access, V: bigV, R: bigR, S: bigS}
+		case txType.Int64 == evm.DynamicFeeTxType:
+			inner = &evm.DynamicFeeTx{ChainID: v.chainid, Nonce: nonce, GasTipCap: new(big.Int).SetBytes(gasTipCap), GasFeeCap: new(big.Int).SetBytes(gasFeeCap), Gas: gas, To: to, Value: new(big.Int).SetBytes(value), Data: input, AccessList: access, V: bigV, R: bigR, S: bigS}
+		case txType.Int64 == evm.BlobTxType:
+			var hashes []types.Hash
+			if err := rlp.DecodeBytes(blobHashes, &hashes); err != nil {
+				failed[number] = fmt.Sprintf("transaction %v blob hashes: %v", index, err.Error())
+				continue
+			}
+			var recipient common.Address
+			if to != nil {
+				recipient = *to
+			}
+			inner = &evm.BlobTx{
+				ChainID:    uint256.MustFromBig(v.chainid),
+				Nonce:      nonce,
+				GasTipCap:  new(uint256.Int).SetBytes(gasTipCap),
+				GasFeeCap:  new(uint256.Int).SetBytes(gasFeeCap),
+				Gas:        gas,
+				To:         recipient,
+				Value:      new(uint256.Int).SetBytes(value),
+				Data:       input,
+				AccessList: access,
+				BlobFeeCap: new(uint256.Int).SetBytes(blobFeeCap),
+				BlobHashes: hashes,
+				V:          uint256.NewInt(uint64(vValue)),
+				R:          new(uint256.Int).SetBytes(r),
+				S:          new(uint256.Int).SetBytes(s),
+			}
+		default:
+			failed[number] = fmt.Sprintf("transaction %v has unknown type %v", index, txType.Int64)
+			continue
+		}
+		encoded, err := evm.NewTx(inner).MarshalBinary()
+		if err != nil {
+			failed[number] = fmt.Sprintf("transaction %v: %v", index, err.Error())
+			continue
+		}
+		b.txs = append(b.txs, encoded)
+		b.receipts = append(b.receipts, &storedReceipt{
+			txType:            uint8(txType.Int64),
+			status:            status,
+			cumulativeGasUsed: cumulativeGasUsed,
+			bloom:             bloom,
+		})
+	}
+	return rows.Err()
+}
+
+// loadLogs attaches the stored logs to the receipts loaded for each block.
+func (v *Verifier) loadLogs(ctx context.Context, from, to uint64, blocks map[uint64]*storedBlock, failed map[uint64]string) error {
+	rows, err := v.db.QueryContext(ctx, fmt.Sprintf("SELECT block, transactionIndex, %v, %v, topic1, topic2, topic3, data FROM %v WHERE block >= ? AND block <= ? ORDER BY block, logIndex;", intern.Address("address"), intern.Topic("topic0"), shards.Source("logs", "event_logs", from, to, "")), from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number uint64
+		var index int
+		var address, topic0, topic1, topic2, topic3, data []byte
+		if err := rows.Scan(&number, &index, &address, &topic0, &topic1, &topic2, &topic3, &data); err != nil {
+			return err
+		}
+		b, ok := blocks[number]
+		if !ok {
+			continue
+		}
+		if _, ok := failed[number]; ok {
+			continue
+		}
+		if index >= len(b.receipts) {
+			failed[number] = fmt.Sprintf("log for missing transaction %v", index)
+			continue
+		}
+		data, err = decompress(data)
+		if err != nil {
+			failed[number] = fmt.Sprintf("log data: %v", err.Error())
+			continue
+		}
+		l := storedLog{Address: common.BytesToAddress(address), Topics: []types.Hash{}, Data: data}
+		for _, topic := range [][]byte{topic0, topic1, topic2, topic3} {
+			if len(topic) == 0 {
+				break
+			}
+			l.Topics = append(l.Topics, types.BytesToHash(topic))
+		}
+		b.receipts[index].logs = append(b.receipts[index].logs, l)
+	}
+	return rows.Err()
+}
+
+// encode returns the consensus encoding of a post-Byzantium receipt.
+func (r *storedReceipt) encode() ([]byte, error) {
+	status := []byte{}
+	if r.status == 1 {
+		status = []byte{1}
+	}
+	logs := r.logs
+	if logs == nil {
+		logs = []storedLog{}
+	}
+	encoded, err := rlp.EncodeToBytes([]interface{}{status, r.cumulativeGasUsed, r.bloom, logs})
+	if err != nil {
+		return nil, err
+	}
+	if r.txType == evm.LegacyTxType {
+		return encoded, nil
+	}
+	return append([]byte{r.txType}, encoded...), nil
+}
+
+func hasTable(db *sql.DB, schema, table string) bool {
+	var name string
+	db.QueryRow(fmt.Sprintf("SELECT name FROM %v.sqlite_master WHERE type='table' AND name=?;", schema), table).Scan(&name)
+	return name == table
+}
+
+func decompress(data []byte) ([]byte, error) {
+	return codec.Decompress(data)
+}
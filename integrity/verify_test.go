@@ -0,0 +1,114 @@
+package integrity
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+)
+
+func copyResource(t *testing.T, name, dir string) string {
+	src, err := os.Open(filepath.Join("../testing-resources", name))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer src.Close()
+	path := filepath.Join(dir, name)
+	dest, err := os.Create(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer dest.Close()
+	if _, err := io.Copy(dest, src); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return path
+}
+
+func TestTrieEmptyRoot(t *testing.T) {
+	if root := listRoot(nil); root != emptyRoot {
+		t.Errorf("unexpected empty root %#x", root)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	databases := map[string]string{}
+	for _, schema := range []string{"blocks", "transactions"} {
+		databases[schema] = copyResource(t, schema+".sqlite", dir)
+		if _, err := db.Exec("ATTACH DATABASE ? AS "+schema, databases[schema]); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	intern.Configure(false)
+	// The resources may predate the blob columns.
+	for _, column := range []string{"maxFeePerBlobGas", "blobVersionedHashes"} {
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('transactions', 'transactions') WHERE name = ?", column).Scan(&count); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if count == 0 {
+			if _, err := db.Exec("ALTER TABLE transactions.transactions ADD COLUMN " + column + " blob"); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS blocks.reindex_queue (number BIGINT PRIMARY KEY)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	verifier := NewVerifier(db, &config.Config{Chainid: 1, ByzantiumBlock: 4370000})
+
+	report, err := verifier.Verify(context.Background(), 0, 14000021, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if report.Checked != 43 {
+		t.Errorf("expected 43 blocks checked, got %v", report.Checked)
+	}
+	if report.Receipts != 0 {
+		t.Errorf("receipt roots checked without logs: %v", report.Receipts)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("unexpected mismatches: %+v", report.Mismatches)
+	}
+
+	if _, err := db.Exec("UPDATE transactions.transactions SET nonce = nonce + 1 WHERE block = 14000005 AND transactionIndex = 3"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("UPDATE blocks.blocks SET parentHash = hash WHERE number = 14000010"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	report, err = verifier.Verify(context.Background(), 14000000, 14000021, true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(report.Mismatches) != 2 {
+		t.Fatalf("expected 2 mismatches, got %+v", report.Mismatches)
+	}
+	if m := report.Mismatches[0]; m.Block != 14000005 || !m.TxRoot || m.ParentHash {
+		t.Errorf("unexpected mismatch %+v", m)
+	}
+	if m := report.Mismatches[1]; m.Block != 14000010 || m.TxRoot || !m.ParentHash {
+		t.Errorf("unexpected mismatch %+v", m)
+	}
+	var queued int
+	if err := db.QueryRow("SELECT count(*) FROM blocks.reindex_queue").Scan(&queued); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if queued != 2 || report.Queued != 2 {
+		t.Errorf("expected 2 queued blocks, got %v (%v reported)", queued, report.Queued)
+	}
+}
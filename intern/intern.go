@@ -0,0 +1,123 @@
+// Package intern builds the SQL for addresses and topic0 values interned
+// into integer ids.
+//
+// Interned values live in logs.interned_addresses and logs.interned_topics.
+// event_logs stores ids in place of its address and topic0, and transactions
+// in place of its sender, recipient and contractAddress. The columns are
+// declared varchar, which gives them text affinity, so SQLite stores an id
+// bound as an integer as text, while values are always blobs. The expressions
+// here rely on that to tell the two apart with typeof, and would misread ids
+// if the columns lost their text affinity. A database may hold both forms
+// while it is converted, so queries resolve and match either.
+//
+// Until Configure enables interning, expressions read and match the columns
+// directly.
+package intern
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// Addresses interns event_logs.address and the transactions address
+	// columns.
+	Addresses = "logs.interned_addresses"
+	// Topics interns event_logs.topic0.
+	Topics = "logs.interned_topics"
+	// Progress holds the highest rowid of each table converted to ids by
+	// the background conversion.
+	Progress = "logs.intern_progress"
+)
+
+var available = false
+
+// Configure records whether columns may hold interned ids, which is the case
+// when interning is enabled or InUse reports that values were interned
+// before. Otherwise expressions read and match columns directly.
+func Configure(enabled bool) {
+	available = enabled
+}
+
+// Available reports whether columns may hold interned ids.
+func Available() bool {
+	return available
+}
+
+// InUse reports whether any values have been interned in db, which must have
+// the logs database attached. Columns may hold ids whenever it returns true,
+// even if interning has since been disabled.
+func InUse(db *sql.DB) (bool, error) {
+	var used bool
+	err := db.QueryRow(fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %v) OR EXISTS(SELECT 1 FROM %v);", Addresses, Topics)).Scan(&used)
+	return used, err
+}
+
+// Address returns an expression for column, an interned address column, that
+// evaluates to the stored address.
+func Address(column string) string {
+	return resolve(Addresses, column)
+}
+
+// Topic returns an expression for column, an event_logs topic0 column, that
+// evaluates to the stored topic.
+func Topic(column string) string {
+	return resolve(Topics, column)
+}
+
+func resolve(table, column string) string {
+	if !available {
+		return column
+	}
+	return fmt.Sprintf("(CASE WHEN typeof(%[2]v) = 'text' THEN (SELECT value FROM %[1]v WHERE id = CAST(%[2]v AS INTEGER)) ELSE %[2]v END)", table, column)
+}
+
+// MatchAddress returns a condition matching column against address in either
+// form, along with the parameters it binds.
+func MatchAddress(column string, address interface{}) (string, []interface{}) {
+	return bind(Addresses, column, address)
+}
+
+// MatchAddressTo returns a condition matching column against the address
+// value evaluates to, such as a column of another table, in either form.
+func MatchAddressTo(column, value string) string {
+	return match(Addresses, column, value)
+}
+
+// MatchTopic returns a condition matching column against topic in either
+// form, along with the parameters it binds.
+func MatchTopic(column string, topic interface{}) (string, []interface{}) {
+	return bind(Topics, column, topic)
+}
+
+// MatchTopicTo returns a condition matching column against the topic value
+// evaluates to, such as a literal, in either form.
+func MatchTopicTo(column, value string) string {
+	return match(Topics, column, value)
+}
+
+func bind(table, column string, value interface{}) (string, []interface{}) {
+	if !available {
+		return match(table, column, "?"), []interface{}{value}
+	}
+	return match(table, column, "?"), []interface{}{value, value}
+}
+
+func match(table, column, value string) string {
+	if !available {
+		return fmt.Sprintf("%v = %v", column, value)
+	}
+	return fmt.Sprintf("%[1]v IN (%[3]v, (SELECT id FROM %[2]v WHERE value = %[3]v))", column, table, value)
+}
+
+// Lookup returns an expression evaluating to the id of a bound value in
+// table, which must already be interned.
+func Lookup(table string) string {
+	return fmt.Sprintf("(SELECT id FROM %v WHERE value = ?)", table)
+}
+
+// Insert returns a statement interning a bound value into table, if it is not
+// already interned.
+func Insert(table string) string {
+	return fmt.Sprintf("INSERT OR IGNORE INTO %v(value) VALUES (?)", table)
+}
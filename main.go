@@ -19,10 +19,16 @@ import (
 	"github.com/openrelayxyz/cardinal-types/metrics"
 	"github.com/openrelayxyz/cardinal-types/metrics/publishers"
 	"github.com/openrelayxyz/cardinal-flume/api"
+	"github.com/openrelayxyz/cardinal-flume/codec"
 	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/heavy"
 	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/integrity"
+	"github.com/openrelayxyz/cardinal-flume/intern"
 	"github.com/openrelayxyz/cardinal-flume/migrations"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-flume/snapshot"
 	"github.com/openrelayxyz/cardinal-flume/txfeed"
 )
 
@@ -32,8 +38,18 @@ func main() {
 	resumptionTimestampMs := flag.Int64("resumption.ts", -1, "Timestamp (in ms) to resume from instead of database timestamp (requires Cardinal source)")
 	genesisIndex := flag.Bool("genesisIndex", false, "index from zero")
 	lightSeed := flag.Int64("lightSeed", 0, "set light service starting block")
-	blockRollback := flag.Int64("block.rollback", 0, "Rollback to block N before syncing. If N < 0, rolls back from head before starting or syncing.")
+	blockRollback := flag.Int64("block.rollback", 0, "Rollback to block N before syncing, removing block N and later from every table and rewinding the resumption. If N < 0, rolls back from head before starting or syncing.")
 	runCertaintyCheck := flag.Bool("certaintyCheck", false, "run database uncertainty check")
+	snapshotDir := flag.String("snapshot", "", "Copy the databases and a resumption manifest into the given directory, then exit")
+	bootstrapDir := flag.String("bootstrap", "", "Restore the databases from the snapshot in the given directory before syncing")
+	reindexRange := flag.String("reindex", "", "Reindex blocks FROM-TO over the websocket broker, then exit unless reindex.live is set")
+	reindexNames := flag.String("reindex.indexers", "blocks,transactions,logs", "Comma separated indexers to reindex with: blocks, transactions, logs or a plugin name")
+	reindexConcurrency := flag.Int("reindex.concurrency", 4, "Number of batches fetched concurrently when reindexing")
+	reindexLive := flag.Bool("reindex.live", false, "Reindex alongside syncing instead of exiting when complete")
+	verifyRange := flag.String("verify", "", "Check blocks FROM-TO against their transaction and receipt roots and parent hashes, then exit")
+	verifyQueue := flag.Bool("verify.queue", false, "Queue blocks that fail verification for the gap auditor to re-index")
+	migrateStatus := flag.Bool("migrate.status", false, "Print the current and pending migration versions of each database without migrating, then exit")
+	bootstrapBlocks := flag.Uint64("bootstrap.blocks", 0, "Number of recent blocks to keep from the bootstrap snapshot, defaults to retentionBlocks")
 
 	flag.CommandLine.Parse(os.Args[1:])
 
@@ -47,6 +63,13 @@ func main() {
 		cfg.LightSeed = *lightSeed
 	}
 
+	heavy.Configure(time.Duration(cfg.HeavyHealthInterval) * time.Second, cfg.HeavyFailureThreshold, time.Duration(cfg.HeavyCooldown) * time.Second)
+	heavy.ConfigureBatching(time.Duration(cfg.HeavyBatchWindow) * time.Millisecond, cfg.HeavyBatchSize)
+	if err := shards.Configure(cfg.Databases, cfg.Shards); err != nil {
+		log.Error("Error configuring shards", "err", err)
+		os.Exit(1)
+	}
+
 	pl, err := plugins.NewPluginLoader(cfg)
 	if err != nil {
 		log.Error("No PluginLoader initialized", "err", err.Error())
@@ -54,12 +77,28 @@ func main() {
 
 	pl.Initialize(cfg)
 
+	var bootstrapManifest *snapshot.Manifest
+	if *bootstrapDir != "" {
+		if cfg.LightSeed != 0 {
+			log.Error("bootstrap and lightSeed cannot be used together")
+			os.Exit(1)
+		}
+		bootstrapManifest, err = snapshot.Restore(*bootstrapDir, snapshot.Databases(cfg.Databases))
+		if err != nil {
+			log.Error("Error restoring bootstrap snapshot", "err", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	sql.Register("sqlite3_hooked",
 		&sqlite3.SQLiteDriver{
 			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
 				for name, path := range cfg.Databases {
 					conn.Exec(fmt.Sprintf("ATTACH DATABASE '%v' AS '%v'; PRAGMA %v.page_size = 65536 ; PRAGMA %v.journal_mode = WAL ; PRAGMA %v.synchronous = OFF ; pragma %v.max_page_count = 4294967294;", path, name, name, name, name, name), nil)
 				}
+				for name, path := range shards.Attachments() {
+					conn.Exec(fmt.Sprintf("ATTACH DATABASE '%v' AS '%v'; PRAGMA %v.page_size = 65536 ; PRAGMA %v.journal_mode = WAL ; PRAGMA %v.synchronous = OFF ; pragma %v.max_page_count = 4294967294;", path, name, name, name, name, name), nil)
+				}
 				return nil
 			},
 		})
@@ -135,43 +174,116 @@ func main() {
 		log.Info("flume initailizing without a mempool database attached")
 	}
 
-	if hasBlocks {
-		if err := migrations.MigrateBlocks(logsdb, cfg.Chainid); err != nil {
-			log.Error(err.Error())
-		}
+	migrationSets := migrations.Core(cfg.Databases)
+	for _, v := range pl.Lookup("Migrations", func(v interface{}) bool {
+		_, ok := v.(*[]migrations.Set)
+		return ok
+	}) {
+		migrationSets = append(migrationSets, *v.(*[]migrations.Set)...)
 	}
-	if hasTx {
-		if err := migrations.MigrateTransactions(logsdb, cfg.Chainid); err != nil {
-			log.Error(err.Error())
+
+	if *migrateStatus {
+		statuses, err := migrations.Report(logsdb, migrationSets)
+		if err != nil {
+			log.Error("Error reading migration status", "err", err.Error())
+			os.Exit(1)
 		}
+		for _, status := range statuses {
+			pending := "up to date"
+			if len(status.Pending) > 0 {
+				pending = fmt.Sprintf("pending %v", status.Pending)
+			}
+			fmt.Printf("%-16v current %-4v latest %-4v %v\n", status.Label(), status.Current, status.Latest, pending)
+		}
+		logsdb.Close()
+		return
+	}
+
+	if err := migrations.Migrate(logsdb, migrationSets, cfg.Chainid); err != nil {
+		log.Error("Error migrating databases", "err", err.Error())
+		os.Exit(1)
 	}
 	if hasLogs {
-		if err := migrations.MigrateLogs(logsdb, cfg.Chainid); err != nil {
-			log.Error(err.Error())
-		}
 		if err := api.LoadIndexHints(logsdb); err != nil {
 			log.Warn("Failed to load index hints", "err", err.Error())
 		}
+		interned, err := intern.InUse(logsdb)
+		if err != nil {
+			log.Error("Error checking for interned values", "err", err.Error())
+			os.Exit(1)
+		}
+		intern.Configure(cfg.InternLogs || cfg.InternTransactions || interned)
 	}
-	if hasMempool {
-		if err := migrations.MigrateMempool(logsdb, cfg.Chainid); err != nil {
-			log.Error(err.Error())
+	if err := shards.Migrate(logsdb); err != nil {
+		log.Error("Error migrating shards", "err", err.Error())
+		os.Exit(1)
+	}
+	if err := codec.Configure(cfg.Compression); err != nil {
+		log.Error("Error configuring compression", "err", err.Error())
+		os.Exit(1)
+	}
+	for schema, ok := range map[string]bool{"transactions": hasTx, "logs": hasLogs} {
+		if ok {
+			if err := codec.Load(logsdb, schema); err != nil {
+				log.Error("Error loading compression dictionaries", "schema", schema, "err", err.Error())
+				os.Exit(1)
+			}
 		}
 	}
-	
-	pluginMigrations := pl.Lookup("Migrate", func(v interface{}) bool {
-		_, ok := v.(func(*sql.DB, uint64) error)
+
+	if *snapshotDir != "" {
+		if _, err := snapshot.Create(context.Background(), logsdb, nil, snapshot.Databases(cfg.Databases), *snapshotDir); err != nil {
+			log.Error("Error creating snapshot", "err", err.Error())
+			os.Exit(1)
+		}
+		logsdb.Close()
+		return
+	}
+
+	if *verifyRange != "" {
+		from, to, err := parseRange(*verifyRange)
+		if err != nil {
+			log.Error("Invalid verify range", "err", err.Error())
+			os.Exit(1)
+		}
+		report, err := integrity.NewVerifier(logsdb, cfg).Verify(context.Background(), uint64(from), uint64(to), *verifyQueue)
+		if err != nil {
+			log.Error("Error verifying blocks", "err", err.Error())
+			os.Exit(1)
+		}
+		for _, m := range report.Mismatches {
+			log.Warn("Block failed verification", "number", m.Block, "txRoot", m.TxRoot, "receiptRoot", m.ReceiptRoot, "parentHash", m.ParentHash, "err", m.Error)
+		}
+		log.Info("Verification complete", "checked", report.Checked, "receipts", report.Receipts, "mismatched", len(report.Mismatches), "queued", report.Queued)
+		logsdb.Close()
+		if len(report.Mismatches) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	prunedTables := make(map[string]string)
+	for _, v := range pl.Lookup("PrunedTables", func(v interface{}) bool {
+		_, ok := v.(*map[string]string)
 		return ok
-	})
-	
-	for _, mgt := range pluginMigrations {
-		fn := mgt.(func(*sql.DB, uint64) error)
-		if err := fn(logsdb, cfg.Chainid); err != nil {
-			log.Error("Unable to migrate from plugin", "err", err.Error())
+	}) {
+		for table, column := range *v.(*map[string]string) {
+			prunedTables[table] = column
 		}
 	}
 
-	
+	if bootstrapManifest != nil {
+		blocks := *bootstrapBlocks
+		if blocks == 0 {
+			blocks = cfg.RetentionBlocks
+		}
+		if err := bootstrap(logsdb, cfg, bootstrapManifest, blocks, prunedTables); err != nil {
+			log.Error("Error bootstrapping from snapshot", "err", err.Error())
+			os.Exit(1)
+		}
+		log.Info("Bootstrapped from snapshot", "block", bootstrapManifest.Block)
+	}
+
 	var maxBlock int
 	if err := logsdb.QueryRowContext(context.Background(), "SELECT max(number) FROM blocks;").Scan(&maxBlock); err != nil {
 		log.Warn("sql max block query error", "err", err.Error())
@@ -184,10 +296,11 @@ func main() {
 		if *blockRollback < 0 {
 			rollback = int64(maxBlock) + *blockRollback
 		}
-		if _, err := logsdb.Exec("DELETE FROM blocks.blocks WHERE number >= ?;", rollback); err != nil {
+		if err := indexer.Rollback(context.Background(), logsdb, cfg, prunedTables, rollback); err != nil {
 			log.Error("blockRollBack error", "err", err.Error())
+			os.Exit(1)
 		}
-		cfg.LatestBlock = uint64(rollback)
+		cfg.LatestBlock = uint64(rollback - 1)
 	}
 
 	log.Debug("latest block config", "number", cfg.LatestBlock)
@@ -197,6 +310,13 @@ func main() {
 		log.Error(err.Error())
 	}
 	quit := make(chan struct{})
+	var stopFeedOnce sync.Once
+	// stopFeed stops ProcessDataFeed. admin_rollback stops it early, so it is
+	// only signalled once.
+	stopFeed := func() {
+		stopFeedOnce.Do(func() { quit <- struct{}{} })
+	}
+	restart := make(chan struct{}, 1)
 	mut := &sync.RWMutex{}
 
 	indexes := []indexer.Indexer{}
@@ -205,10 +325,10 @@ func main() {
 		indexes = append(indexes, indexer.NewBlockIndexer(cfg.Chainid))
 	}
 	if hasTx {
-		indexes = append(indexes, indexer.NewTxIndexer(cfg.Chainid, cfg.Eip155Block, cfg.HomesteadBlock, hasMempool))
+		indexes = append(indexes, indexer.NewTxIndexer(cfg.Chainid, cfg.Eip155Block, cfg.HomesteadBlock, hasMempool, cfg.InternTransactions))
 	}
 	if hasLogs {
-		indexes = append(indexes, indexer.NewLogIndexer(cfg.Chainid))
+		indexes = append(indexes, indexer.NewLogIndexer(cfg.Chainid, cfg.InternLogs))
 	}
 
 	pluginIndexers := pl.Lookup("Indexer", func(v interface{}) bool {
@@ -234,6 +354,23 @@ func main() {
 		}
 	}
 
+	var reindexer *indexer.Reindexer
+	if *reindexRange != "" {
+		reindexer, err = newReindexer(logsdb, cfg, pl, mut, *reindexRange, *reindexNames, *reindexConcurrency, hasMempool)
+		if err != nil {
+			log.Error("Error configuring reindex", "err", err.Error())
+			os.Exit(1)
+		}
+		if !*reindexLive {
+			if err := reindexer.Run(context.Background()); err != nil {
+				log.Error("Reindex failed", "err", err.Error())
+				os.Exit(1)
+			}
+			logsdb.Close()
+			return
+		}
+	}
+
 	pluginReIndexers := pl.Lookup("ReIndexer", func(v interface{}) bool {
 		_, ok := v.(func(*config.Config, *sql.DB, []indexer.Indexer) error)
 		return ok
@@ -253,20 +390,41 @@ func main() {
 		return
 	}
 
-	consumer, err := AcquireConsumer(logsdb, cfg, *resumptionTimestampMs, !*ignoreBlockTime, pl)
+	// A bootstrapped instance resumes from the snapshot's offsets rather than
+	// its head block's time.
+	consumer, err := AcquireConsumer(logsdb, cfg, *resumptionTimestampMs, !*ignoreBlockTime && bootstrapManifest == nil, pl)
 	if err != nil {
 		log.Error("error establishing consumer", "err", err.Error())
 	}
 
 	hc := &indexer.HealthCheck{}
 	rhf := make(chan *rpc.HeightRecord, 1024)
-	go indexer.ProcessDataFeed(consumer, txFeed, logsdb, quit, cfg.Eip155Block, cfg.HomesteadBlock, mut, cfg.MempoolSlots, indexes, hc, cfg.MemTxTimeThreshold, rhf, cfg.Chainid)
+	chainFeed := &indexer.ChainFeed{}
+	go indexer.ProcessDataFeed(consumer, txFeed, logsdb, quit, cfg.Eip155Block, cfg.HomesteadBlock, mut, cfg.MempoolSlots, indexes, hc, cfg.MemTxTimeThreshold, rhf, cfg.Chainid, indexer.FeedOptions{
+		WipeMempool:     cfg.MempoolWipe,
+		Pipeline:        cfg.PipelineIndexing,
+		GroupCommitAge:  time.Duration(cfg.GroupCommitAge) * time.Second,
+		GroupCommitSize: cfg.GroupCommitSize,
+		ReorgThreshold:  cfg.ReorgThreshold,
+		ChainFeed:       chainFeed,
+	})
+
+	if reindexer != nil {
+		go func() {
+			if err := reindexer.Run(context.Background()); err != nil {
+				log.Error("Reindex failed", "err", err.Error())
+			}
+		}()
+	}
 
 	tm := rpcTransports.NewTransportManager(cfg.Concurrency)
 	tm.SetBlockWaitDuration(time.Duration(cfg.BlockWaitDuration) * time.Millisecond)
 	tm.RegisterHeightFeed(rhf)
 	tm.RegisterHealthCheck(hc)
 	tm.AddHTTPServer(cfg.Port)
+	if cfg.WSPort != 0 {
+		tm.AddWSServer(cfg.WSPort)
+	}
 
 	pluginAPIs := pl.Lookup("RegisterAPI", func(v interface{}) bool {
 		_, ok := v.(func(*rpcTransports.TransportManager, *sql.DB, *config.Config) error)
@@ -296,8 +454,18 @@ func main() {
 	if hasTx && hasBlocks && hasLogs {
 		tm.Register("eth", api.NewTransactionAPI(logsdb, cfg.Chainid, pl, cfg, hasMempool))
 		tm.Register("flume", api.NewFlumeAPI(logsdb, cfg.Chainid, pl, cfg, hasMempool))
+		tm.Register("eth", api.NewSubscriptionAPI(logsdb, cfg.Chainid, cfg, chainFeed, txFeed))
+		tm.Register("eth", api.NewFilterAPI(logsdb, cfg.Chainid, pl, cfg, chainFeed))
 	}
 	tm.Register("debug", &metrics.MetricsAPI{})
+	if cfg.AdminAPI {
+		adminAPI := api.NewAdminAPI(logsdb, cfg, mut, prunedTables, stopFeed, restart)
+		go func() {
+			if err := api.ServeAdmin(fmt.Sprintf("%v:%v", cfg.AdminHost, cfg.AdminPort), cfg.Concurrency, adminAPI); err != nil {
+				log.Error("Admin listener failed", "err", err.Error())
+			}
+		}()
+	}
 
 	<-consumer.Ready()
 	var minBlock int
@@ -311,8 +479,8 @@ func main() {
 		}
 	}
 	//if this > 0 then this is a light server
-	cfg.EarliestBlock = uint64(minBlock)
-	log.Debug("earliest block config", "number", cfg.EarliestBlock)
+	cfg.SetEarliestBlock(uint64(minBlock))
+	log.Debug("earliest block config", "number", cfg.GetEarliestBlock())
 	if len(cfg.HeavyServer) == 0 && minBlock > cfg.MinSafeBlock {
 		log.Error("Minimum block error", "Earliest log found on block:", minBlock, "Should be less than or equal to:", cfg.MinSafeBlock)
 		os.Exit(1)
@@ -324,7 +492,35 @@ func main() {
 		if cfg.CloudWatch != nil {
 			publishers.CloudWatch(cfg.CloudWatch.Namespace, cfg.CloudWatch.Dimensions, int64(cfg.Chainid), time.Duration(cfg.CloudWatch.Interval), cfg.CloudWatch.Percentiles, cfg.CloudWatch.Minor)
 		}
-		stopFns := make([]func(), 0, len(startFns))
+		stopFns := make([]func(), 0, len(startFns)+2)
+		if cfg.Pruning() {
+			ctx, cancel := context.WithCancel(context.Background())
+			go indexer.NewPruner(logsdb, cfg, mut, prunedTables).Run(ctx, time.Duration(cfg.PruneInterval) * time.Second)
+			stopFns = append(stopFns, cancel)
+		}
+		if cfg.AuditInterval > 0 {
+			if auditor, err := indexer.NewAuditor(logsdb, cfg, mut, indexes); err != nil {
+				log.Warn("Gap auditor disabled", "err", err.Error())
+			} else {
+				ctx, cancel := context.WithCancel(context.Background())
+				go auditor.Run(ctx, time.Duration(cfg.AuditInterval) * time.Second)
+				stopFns = append(stopFns, cancel)
+			}
+		}
+		if (cfg.InternLogs || cfg.InternTransactions) && hasLogs {
+			ctx, cancel := context.WithCancel(context.Background())
+			go indexer.NewInterner(logsdb, mut, cfg.InternLogs, cfg.InternTransactions).Run(ctx, time.Duration(cfg.InternInterval) * time.Second)
+			stopFns = append(stopFns, cancel)
+		}
+		if cfg.RecompressInterval > 0 {
+			if cfg.Compression != "zstd" {
+				log.Warn("Recompression disabled, it requires zstd compression")
+			} else {
+				ctx, cancel := context.WithCancel(context.Background())
+				go indexer.NewRecompressor(logsdb, mut).Run(ctx, time.Duration(cfg.RecompressInterval) * time.Second)
+				stopFns = append(stopFns, cancel)
+			}
+		}
 		for _, v := range startFns {
 			if fn, ok := v.(func(*sql.DB, *config.Config) func()); ok {
 				stopFns = append(stopFns, fn(logsdb, cfg))
@@ -335,17 +531,31 @@ func main() {
 				fn()
 			}
 		}
-		if err := tm.Run(cfg.HealthcheckPort); err != nil {
-			log.Error(err.Error())
+		runErr := make(chan error, 1)
+		go func() { runErr <- tm.Run(cfg.HealthcheckPort) }()
+		select {
+		case err := <-runErr:
+			if err != nil {
+				log.Error(err.Error())
+				stop()
+				stopFeed()
+				logsdb.Close()
+				time.Sleep(time.Second)
+				os.Exit(1)
+			}
+		case <-restart:
+			// admin_rollback has already stopped the feed. Exit non-zero so
+			// that a supervisor restarts from the rolled back head.
+			log.Warn("Shutting down to restart after admin_rollback")
 			stop()
-			quit <- struct{}{}
 			logsdb.Close()
+			metrics.Clear()
 			time.Sleep(time.Second)
 			os.Exit(1)
 		}
 		stop()
 	}
-	quit <- struct{}{}
+	stopFeed()
 	logsdb.Close()
 	metrics.Clear()
 	time.Sleep(time.Second)
@@ -2,420 +2,319 @@ package migrations
 
 import (
 	"database/sql"
-	log "github.com/inconshreveable/log15"
 )
 
 const (
 	maxInt = 9223372036854775807
 )
 
-func MigrateBlocks(db *sql.DB, chainid uint64) error {
-	var tableName string
-	db.QueryRow("SELECT name FROM blocks.sqlite_master WHERE type='table' and name='migrations';").Scan(&tableName)
-	if tableName != "migrations" {
-		if _, err := db.Exec("CREATE TABLE blocks.migrations (version integer PRIMARY KEY);"); err != nil {
-			log.Error("migrations CREATE TABLE blocks.migrations error", "err", err.Error())
-		}
-		if _, err := db.Exec("INSERT INTO blocks.migrations(version) VALUES (0);"); err != nil {
-			log.Error("migrations INSERT INTO blocks.migrations error", "err", err.Error())
-		}
-	}
-	var schemaVersion uint
-	db.QueryRow("SELECT version FROM blocks.migrations;").Scan(&schemaVersion)
-	if schemaVersion < 1 {
-		log.Info("Applying blocks v1 migration")
-		if _, err := db.Exec(`CREATE TABLE blocks.blocks (
-		    number      BIGINT PRIMARY KEY,
-			hash        varchar(32) UNIQUE,
-		    parentHash  varchar(32),
-		    uncleHash   varchar(32),
-		    coinbase    varchar(20),
-		    root        varchar(32),
-		    txRoot      varchar(32),
-		    receiptRoot varchar(32),
-		    bloom       blob,
-		    difficulty  varchar(32),
-		    gasLimit    BIGINT,
-		    gasUsed     BIGINT,
-		    time        BIGINT,
-		    extra       blob,
-		    mixDigest   varchar(32),
-		    nonce       BIGINT,
-		    uncles      blob,
-		    size        BIGINT,
-		    td          varchar(32),
-		    baseFee varchar(32))`); err != nil {
-			log.Error("migrations CREATE TABLE blocks.blocks error", "err", err.Error())
-			return nil
-			}
-		if _, err := db.Exec(`CREATE TABLE blocks.cardinal_offsets (
-			partition INT,
-			offset BIGINT,
-			topic STRING,
-			PRIMARY KEY (topic, partition))`) ; err != nil {
-			log.Error("migrations CREATE TABLE blocks.cardinal_offsets error", "err", err.Error())
-			return nil
-			}
-		if _, err := db.Exec(`CREATE TABLE blocks.issuance (
-					startBlock     BIGINT,
-					endBlock       BIGINT,
-					value          BIGINT
-					)`); err != nil {
-			log.Error("migrations CREATE TABLE blocks.issuance error", "err", err.Error())
-			return nil
-			}
-		if _, err := db.Exec(`CREATE INDEX blocks.coinbase ON blocks(coinbase)`); err != nil {
-			log.Error("migrations CREATE INDEX blocks.coinbase error", "err", err.Error())
-			return nil
-		}
-
-		if _, err := db.Exec(`CREATE INDEX blocks.timestamp ON blocks(time)`); err != nil {
-			log.Error("migrations CREATE INDEX blocks.timestamp error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`UPDATE blocks.migrations SET version = 1;`); err != nil {
-			log.Error("migrations UPDATE blocks.migrations v1 error", "err", err.Error())
-		}
-		log.Info("blocks v1 migrations done")
-
-	}
-	if schemaVersion < 2 {
-		log.Info("Applying blocks v2 migration")
-
-		if _, err := db.Exec(`CREATE INDEX blocks.bkHash ON blocks(hash);`); err != nil {
-			log.Error("migrations CREATE INDEX blocks.bkHash error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE blocks.migrations SET version = 2;"); err != nil {
-			log.Error("migrations UPDATE blocks.migrations v2 error", "err", err.Error())
-		}
-		log.Info("blocks v2 migrations done")
-	}
-	if schemaVersion < 3 {
-		log.Info("Applying blocks v3 migration")
-		if _, err := db.Exec(`CREATE INDEX blocks.coinbaseCompound ON blocks(coinbase, number)`); err != nil {
-			log.Error("migrations CREATE INDEX blocks.coinbaseCompound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`DROP INDEX blocks.coinbase;`); err != nil {
-			log.Error("migrations DROP INDEX blocks.coinbase error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE blocks.migrations SET version = 3;"); err != nil {
-			log.Error("migrations UPDATE blocks.migrations v3 error", "err", err.Error())
-		}
-		log.Info("blocks v3 migrations done")
-	}
-	if schemaVersion < 4 {
-		log.Info("Applying blocks v4 migration")
-		if _, err := db.Exec(`ALTER TABLE blocks.blocks ADD COLUMN withdrawalHash VARCHAR(32)`); err != nil {
-				log.Error("migrations ALTER TABLE blocks.blocks ADD COLUMN withdrawalHash error", "err", err.Error())
-				return nil
-			}
-		if _, err := db.Exec(`CREATE TABLE blocks.withdrawals (
+// Blocks migrates the blocks database.
+var Blocks = Set{
+	Schema: "blocks",
+	Steps: []Step{
+		{Version: 1, Statements: []string{
+			`CREATE TABLE blocks.blocks (
+			    number      BIGINT PRIMARY KEY,
+				hash        varchar(32) UNIQUE,
+			    parentHash  varchar(32),
+			    uncleHash   varchar(32),
+			    coinbase    varchar(20),
+			    root        varchar(32),
+			    txRoot      varchar(32),
+			    receiptRoot varchar(32),
+			    bloom       blob,
+			    difficulty  varchar(32),
+			    gasLimit    BIGINT,
+			    gasUsed     BIGINT,
+			    time        BIGINT,
+			    extra       blob,
+			    mixDigest   varchar(32),
+			    nonce       BIGINT,
+			    uncles      blob,
+			    size        BIGINT,
+			    td          varchar(32),
+			    baseFee varchar(32))`,
+			`CREATE TABLE blocks.cardinal_offsets (
+				partition INT,
+				offset BIGINT,
+				topic STRING,
+				PRIMARY KEY (topic, partition))`,
+			`CREATE TABLE blocks.issuance (
+				startBlock     BIGINT,
+				endBlock       BIGINT,
+				value          BIGINT
+				)`,
+			`CREATE INDEX blocks.coinbase ON blocks(coinbase)`,
+			`CREATE INDEX blocks.timestamp ON blocks(time)`,
+		}},
+		{Version: 2, Statements: []string{
+			`CREATE INDEX blocks.bkHash ON blocks(hash)`,
+		}},
+		{Version: 3, Statements: []string{
+			`CREATE INDEX blocks.coinbaseCompound ON blocks(coinbase, number)`,
+			`DROP INDEX blocks.coinbase`,
+		}},
+		{Version: 4, Statements: []string{
+			`ALTER TABLE blocks.blocks ADD COLUMN withdrawalHash VARCHAR(32)`,
+			`CREATE TABLE blocks.withdrawals (
 				wtdrlIndex MEDIUMINT,
 				vldtrIndex MEDIUMINT,
 				address VARCHAR(20),
 				amount    MEDIUMINT,
 				block     BIGINT,
 				blockHash VARCHAR(32),
-				PRIMARY KEY (block, wtdrlIndex))`); err != nil {
-				log.Error("migrations CREATE TABLE blocks.withdrawals", "err", err.Error())
-				return nil
-				}
-		if _, err := db.Exec(`CREATE INDEX blocks.addressBlock ON withdrawals(address, block)`); err != nil {
-			log.Error("migrations CREATE INDEX blocks.addressBlock error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX blocks.blockHash ON withdrawals(blockHash)`); err != nil {
-			log.Error("migrations CREATE INDEX blocks.blockHash error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE blocks.migrations SET version = 4;"); err != nil {
-			log.Error("migrations UPDATE blocks.migrations v4 error", "err", err.Error())
-			return nil
-		}
-		log.Info("blocks v4 migrations done")
-	}
-	if schemaVersion < 5 {
-		log.Info("Applying blocks v5 migration")
-		if _, err := db.Exec(`ALTER TABLE blocks.blocks ADD COLUMN blobGasUsed BIGINT`); err != nil {
-			log.Error("migrations ALTER TABLE blocks.blocks blobGasUsed error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`ALTER TABLE blocks.blocks ADD COLUMN excessBlobGas BIGINT`); err != nil {
-			log.Error("migrations ALTER TABLE blocks.blocks excessBlobGas error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`ALTER TABLE blocks.blocks ADD COLUMN parentBeaconRoot varchar(32)`); err != nil {
-			log.Error("migrations ALTER TABLE blocks.blocks parentBeaconRoot error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE blocks.migrations SET version = 5;"); err != nil {
-			log.Error("migrations UPDATE blocks.migrations v5 error", "err", err.Error())
-			return nil
-		}
-		log.Info("blocks v5 migrations done")
-	}
-
-	log.Info("blocks migration up to date")
-	return nil
+				PRIMARY KEY (block, wtdrlIndex))`,
+			`CREATE INDEX blocks.addressBlock ON withdrawals(address, block)`,
+			`CREATE INDEX blocks.blockHash ON withdrawals(blockHash)`,
+		}},
+		{Version: 5, Statements: []string{
+			`ALTER TABLE blocks.blocks ADD COLUMN blobGasUsed BIGINT`,
+			`ALTER TABLE blocks.blocks ADD COLUMN excessBlobGas BIGINT`,
+			`ALTER TABLE blocks.blocks ADD COLUMN parentBeaconRoot varchar(32)`,
+		}},
+		{Version: 6, Statements: []string{
+			`CREATE TABLE blocks.reorgs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				block BIGINT,
+				depth MEDIUMINT,
+				oldHead varchar(32),
+				newHead varchar(32),
+				replaced blob,
+				time BIGINT)`,
+			`CREATE INDEX blocks.reorgBlock ON reorgs(block)`,
+		}},
+		// Orphaned tables mirror the columns of the canonical tables, and hold
+		// rows displaced by reorgs until they fall past the reorg threshold.
+		{Version: 7, Statements: []string{
+			`CREATE TABLE blocks.orphaned_blocks AS SELECT * FROM blocks.blocks WHERE 0`,
+			`CREATE UNIQUE INDEX blocks.orphanedBlockHash ON orphaned_blocks(hash)`,
+			`CREATE INDEX blocks.orphanedBlockNumber ON orphaned_blocks(number)`,
+			`CREATE TABLE blocks.orphaned_withdrawals AS SELECT * FROM blocks.withdrawals WHERE 0`,
+			`CREATE UNIQUE INDEX blocks.orphanedWithdrawalHash ON orphaned_withdrawals(blockHash, wtdrlIndex)`,
+			`CREATE INDEX blocks.orphanedWithdrawalBlock ON orphaned_withdrawals(block)`,
+		}},
+		// The pruner deletes withdrawals by block.
+		{Version: 8, Statements: []string{
+			`CREATE INDEX blocks.withdrawalBlock ON withdrawals(block)`,
+		}},
+		{Version: 9, Statements: []string{
+			`CREATE TABLE blocks.reindex_checkpoints (
+				job  TEXT PRIMARY KEY,
+				next BIGINT)`,
+		}},
+		// Blocks that failed verification, awaiting the gap auditor.
+		{Version: 10, Statements: []string{
+			`CREATE TABLE blocks.reindex_queue (
+				number BIGINT PRIMARY KEY)`,
+		}},
+	},
 }
 
-func MigrateTransactions(db *sql.DB, chainid uint64) error {
-	var tableName string
-	db.QueryRow("SELECT name FROM transactions.sqlite_master WHERE type='table' and name='migrations';").Scan(&tableName)
-	if tableName != "migrations" {
-		if _, err := db.Exec("CREATE TABLE transactions.migrations (version integer PRIMARY KEY);"); err != nil {
-			log.Error("migrations CREATE TABLE transactions.migrations error", "err", err.Error())
-		}
-
-		if _, err := db.Exec("INSERT INTO transactions.migrations(version) VALUES (0);"); err != nil {
-			log.Error("migrations INSERT INTO transactions.migrations v1 error", "err", err.Error())
-		}
-	}
-	var schemaVersion uint
-	db.QueryRow("SELECT version FROM transactions.migrations;").Scan(&schemaVersion)
-	if schemaVersion < 1 {
-		log.Info("Applying transacitons v1 migration")
+// Transactions migrates the transactions database.
+//
+// Tables in the first step of the transactions, logs and mempool databases
+// are created if they do not exist, as databases from before versioned
+// migrations have them without a migrations table.
+var Transactions = Set{
+	Schema: "transactions",
+	Steps: []Step{
 		// See note 1 *below
-		if _, err := db.Exec(`CREATE TABLE transactions.transactions (
-		    id INTEGER PRIMARY KEY AUTOINCREMENT,
-		    gas BIGINT,
-		    gasPrice BIGINT,
-		    hash varchar(32),
-		    input blob,
-		    nonce BIGINT,
-		    recipient varchar(20),
-		    transactionIndex MEDIUMINT,
-		    value varchar(32),
-		    v SMALLINT,
-		    r varchar(32),
-		    s varchar(32),
-		    sender varchar(20),
-		    func varchar(4),
-		    contractAddress varchar(20),
-		    cumulativeGasUsed BIGINT,
-		    gasUsed BIGINT,
-		    logsBloom blob,
-		    status TINYINT,
-		    block BIGINT,
-		    type TINYINT,
-		    access_list blob,
-		    gasFeeCap varchar(32),
-		    gasTipCap varchar(32))`); err != nil {
-			log.Error("migrations CREATE TABLE transactions.transactions error", "err", err.Error())
-			}
+		{Version: 1, Statements: []string{
+			`CREATE TABLE IF NOT EXISTS transactions.transactions (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    gas BIGINT,
+			    gasPrice BIGINT,
+			    hash varchar(32),
+			    input blob,
+			    nonce BIGINT,
+			    recipient varchar(20),
+			    transactionIndex MEDIUMINT,
+			    value varchar(32),
+			    v SMALLINT,
+			    r varchar(32),
+			    s varchar(32),
+			    sender varchar(20),
+			    func varchar(4),
+			    contractAddress varchar(20),
+			    cumulativeGasUsed BIGINT,
+			    gasUsed BIGINT,
+			    logsBloom blob,
+			    status TINYINT,
+			    block BIGINT,
+			    type TINYINT,
+			    access_list blob,
+			    gasFeeCap varchar(32),
+			    gasTipCap varchar(32))`,
+			`CREATE INDEX IF NOT EXISTS transactions.txblock ON transactions(block)`,
+			`CREATE INDEX IF NOT EXISTS transactions.recipient_partial ON transactions(recipient) WHERE recipient IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS transactions.contractAddress_partial ON transactions(contractAddress) WHERE contractAddress IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS transactions.senderNonce ON transactions(sender, nonce)`,
+		}},
+		{Version: 2, Statements: []string{
+			`CREATE INDEX transactions.txHash ON transactions(hash)`,
+		}},
+		{Version: 3, Statements: []string{
+			`ALTER TABLE transactions.transactions ADD COLUMN maxFeePerBlobGas varchar(32)`,
+			`ALTER TABLE transactions.transactions ADD COLUMN blobVersionedHashes blob`,
+		}},
+		{Version: 4, Statements: []string{
+			`CREATE TABLE transactions.orphaned_transactions AS SELECT * FROM transactions.transactions WHERE 0`,
+			`ALTER TABLE transactions.orphaned_transactions ADD COLUMN blockHash varchar(32)`,
+			`CREATE UNIQUE INDEX transactions.orphanedTxBlockHash ON orphaned_transactions(blockHash, transactionIndex)`,
+			`CREATE INDEX transactions.orphanedTxHash ON orphaned_transactions(hash)`,
+			`CREATE INDEX transactions.orphanedTxBlock ON orphaned_transactions(block)`,
+		}},
+		{Version: 5, Statements: []string{
+			`CREATE INDEX transactions.senderBlock ON transactions(sender, block, transactionIndex)`,
+			`CREATE INDEX transactions.recipientBlock ON transactions(recipient, block, transactionIndex)`,
+		}},
+		{Version: 6, Statements: []string{
+			`CREATE TABLE transactions.codec_dictionaries (
+				id INTEGER PRIMARY KEY,
+				col TEXT,
+				dictionary BLOB,
+				created BIGINT
+			)`,
+		}},
+		{Version: 7, Statements: []string{
+			`CREATE TABLE transactions.recompress_progress (
+				source varchar PRIMARY KEY,
+				converted BIGINT
+			)`,
+		}},
+	},
+}
 
-		if _, err := db.Exec(`CREATE INDEX transactions.txblock ON transactions(block)`); err != nil {
-			log.Error("migrations CREATE INDEX transactions.txblock error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX transactions.recipient_partial ON transactions(recipient) WHERE recipient IS NOT NULL`); err != nil {
-			log.Error("migrations CREATE INDEX transactions.receipient error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX transactions.contractAddress_partial ON transactions(contractAddress) WHERE contractAddress IS NOT NULL`); err != nil {
-			log.Error("migrations CREATE INDEX transactions.contractAddress_partial error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX transactions.senderNonce ON transactions(sender, nonce)`); err != nil {
-			log.Error("migrations CREATE INDEX transactions.senderNonce error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`UPDATE transactions.migrations SET version = 1;`); err != nil {
-			log.Error("migrations UPDATE transactions.migrations v1 error", "err", err.Error())
-		}
-		log.Info("transacitons migrations v1 done")
-	}
+// Logs migrates the logs database.
+var Logs = Set{
+	Schema: "logs",
+	Steps: []Step{
+		{Version: 1, Statements: []string{
+			`CREATE TABLE IF NOT EXISTS logs.event_logs (
+			    address varchar(20),
+			    topic0 varchar(32),
+			    topic1 varchar(32),
+			    topic2 varchar(32),
+			    topic3 varchar(32),
+			    data blob,
+			    block BIGINT,
+			    logIndex MEDIUMINT,
+			    transactionHash varchar(32),
+			    transactionIndex varchar(32),
+			    blockHash varchar(32),
+			    PRIMARY KEY (block, logIndex)
+			    )`,
+			`CREATE INDEX IF NOT EXISTS logs.address_compound ON event_logs(address, block)`,
+			`CREATE INDEX IF NOT EXISTS logs.topic0_compound ON event_logs(topic0, block)`,
+			`CREATE INDEX IF NOT EXISTS logs.topic1_partial ON event_logs(topic1, topic0, address, block) WHERE topic1 IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS logs.topic2_partial ON event_logs(topic2, topic0, address, block) WHERE topic2 IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS logs.topic3_partial ON event_logs(topic3, topic0, address, block) WHERE topic3 IS NOT NULL`,
+		}},
+		{Version: 2, Statements: []string{
+			`CREATE TABLE logs.address_hints (
+				address varchar(20)
+			)`,
+		}},
+		{Version: 3, Statements: []string{
+			`CREATE INDEX logs.address_topic0_compound ON event_logs(address, topic0, block)`,
+		}},
+		{Version: 4, Statements: []string{
+			`CREATE TABLE logs.orphaned_event_logs AS SELECT * FROM logs.event_logs WHERE 0`,
+			`CREATE UNIQUE INDEX logs.orphanedLogBlockHash ON orphaned_event_logs(blockHash, logIndex)`,
+			`CREATE INDEX logs.orphanedLogBlock ON orphaned_event_logs(block)`,
+		}},
+		{Version: 5, Statements: []string{
+			`CREATE TABLE logs.codec_dictionaries (
+				id INTEGER PRIMARY KEY,
+				col TEXT,
+				dictionary BLOB,
+				created BIGINT
+			)`,
+		}},
+		{Version: 6, Statements: []string{
+			`CREATE TABLE logs.interned_addresses (
+				id INTEGER PRIMARY KEY,
+				value varchar(20) UNIQUE
+			)`,
+			`CREATE TABLE logs.interned_topics (
+				id INTEGER PRIMARY KEY,
+				value varchar(32) UNIQUE
+			)`,
+		}},
+		{Version: 7, Statements: []string{
+			`CREATE TABLE logs.intern_progress (
+				source varchar PRIMARY KEY,
+				converted BIGINT
+			)`,
+		}},
+		{Version: 8, Statements: []string{
+			`CREATE TABLE logs.recompress_progress (
+				source varchar PRIMARY KEY,
+				converted BIGINT
+			)`,
+		}},
+	},
+}
 
-	if schemaVersion < 2 {
-		log.Info("Applying transactions v2 migration")
+// Mempool migrates the mempool database.
+var Mempool = Set{
+	Schema: "mempool",
+	Steps: []Step{
+		{Version: 1, Statements: []string{
+			`CREATE TABLE IF NOT EXISTS mempool.transactions (
+				gas BIGINT,
+				gasPrice BIGINT,
+				gasFeeCap varchar(32),
+				gasTipCap varchar(32),
+				hash varchar(32) UNIQUE,
+				input blob,
+				nonce BIGINT,
+				recipient varchar(20),
+				value varchar(32),
+				v SMALLINT,
+				r varchar(32),
+				s varchar(32),
+				sender varchar(20),
+				type TINYINT,
+				access_list blob)`,
+			`CREATE INDEX IF NOT EXISTS mempool.sender ON transactions(sender, nonce)`,
+			`CREATE INDEX IF NOT EXISTS mempool.recipient ON transactions(recipient)`,
+			`CREATE INDEX IF NOT EXISTS mempool.gasPrice ON transactions(gasPrice)`,
+		}},
+		{Version: 2, Statements: []string{
+			`ALTER TABLE mempool.transactions ADD time BIGINT`,
+			`CREATE INDEX mempool.time ON transactions(time)`,
+		}},
+	},
+}
 
-		if _, err := db.Exec(`CREATE INDEX transactions.txHash ON transactions(hash);`); err != nil {
-			log.Error("migrations CREATE INDEX transactions.txHash error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE transactions.migrations SET version = 2;"); err != nil {
-			log.Error("migrations UPDATE transactions.migrations v2 error", "err", err.Error())
+// Core returns the sets for the core databases that are configured.
+func Core(databases map[string]string) []Set {
+	sets := []Set{}
+	for _, set := range []Set{Blocks, Transactions, Logs, Mempool} {
+		if _, ok := databases[set.Schema]; ok {
+			sets = append(sets, set)
 		}
-		log.Info("transacitons migrations v2 done")
 	}
-	if schemaVersion < 3 {
-		log.Info("Applying transactions v3 migration")
-		if _, err := db.Exec(`ALTER TABLE transactions.transactions ADD COLUMN maxFeePerBlobGas varchar(32)`); err != nil {
-			log.Error("migrations ALTER TABLE transactions.transactions maxFeePerBlobGas error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`ALTER TABLE transactions.transactions ADD COLUMN blobVersionedHashes blob`); err != nil {
-			log.Error("migrations ALTER TABLE transactions.transactions blobVersionedHashes error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE transactions.migrations SET version = 3;"); err != nil {
-			log.Error("migrations UPDATE transactions.migrations v3 error", "err", err.Error())
-		}
-		log.Info("transacitons migrations v3 done")
-	}
-	
-	log.Info("transactions migrations up to date")
-	return nil
+	return sets
 }
 
-func MigrateLogs(db *sql.DB, chainid uint64) error {
-	var tableName string
-	db.QueryRow("SELECT name FROM logs.sqlite_master WHERE type='table' and name='migrations';").Scan(&tableName)
-	if tableName != "migrations" {
-		if _, err := db.Exec("CREATE TABLE logs.migrations (version integer PRIMARY KEY);"); err != nil {
-			log.Error("migrations CREATE TABLE logs.migrations error", "err", err.Error())
-		}
-
-		if _, err := db.Exec("INSERT INTO logs.migrations(version) VALUES (0);"); err != nil {
-			log.Error("migrations INSERT INTO log.migraions error", "err", err.Error())
-		}
-	}
-	var schemaVersion uint
-	db.QueryRow("SELECT version FROM logs.migrations;").Scan(&schemaVersion)
-	if schemaVersion < 1 {
-		log.Info("Applying logs v1 migration")
-		if _, err := db.Exec(`CREATE TABLE logs.event_logs (
-		    address varchar(20),
-		    topic0 varchar(32),
-		    topic1 varchar(32),
-		    topic2 varchar(32),
-		    topic3 varchar(32),
-		    data blob,
-		    block BIGINT,
-		    logIndex MEDIUMINT,
-		    transactionHash varchar(32),
-		    transactionIndex varchar(32),
-		    blockHash varchar(32),
-		    PRIMARY KEY (block, logIndex)
-		    )`); err != nil {
-			log.Error("migrations CREATE TABLE logs.event_logs error", "err", err.Error())
-			}
-		if _, err := db.Exec(`CREATE INDEX logs.address_compound ON event_logs(address, block)`); err != nil {
-			log.Error("migrations CREATE INDEX logs.address_compound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX logs.topic0_compound ON event_logs(topic0, block)`); err != nil {
-			log.Error("migrations CREATE INDEX logs.topic0_compound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX logs.topic1_partial ON event_logs(topic1, topic0, address, block) WHERE topic1 IS NOT NULL`); err != nil {
-			log.Error("migrations CREATE INDEX logs.topic1_compound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX logs.topic2_partial ON event_logs(topic2, topic0, address, block) WHERE topic2 IS NOT NULL`); err != nil {
-			log.Error("migrations CREATE INDEX logs.topic2_compound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX logs.topic3_partial ON event_logs(topic3, topic0, address, block) WHERE topic3 IS NOT NULL`); err != nil {
-			log.Error("migrations CREATE INDEX logs.topic3_compound error", "err", err.Error())
-			return nil
-		}
+func MigrateBlocks(db *sql.DB, chainid uint64) error {
+	return Blocks.Apply(db, chainid)
+}
 
-		if _, err := db.Exec(`UPDATE logs.migrations SET version = 1;`); err != nil {
-			log.Error("migrations UPDATE log.migrations error", "err", err.Error())
-		}
-		log.Info("logs migrations v1 done")
-	}
-	if schemaVersion < 2 {
-		if _, err := db.Exec(`CREATE TABLE logs.address_hints (
-			address varchar(20)
-		);`); err != nil {
-			log.Error("Migrate Logs CREATE ADDRESS address_hints error", "err", err.Error())
-			return nil
-		}
-		db.Exec(`UPDATE logs.migrations SET version = 2;`)
-		log.Info("logs migrations v2 done")
-	}
-	if schemaVersion < 3 {
-		if _, err := db.Exec(`CREATE INDEX logs.address_topic0_compound ON event_logs(address, topic0, block);`); err != nil {
-			log.Error("Migrate Logs CREATE INDEX logs.addresstopic0_compound error", "err", err.Error())
-			return nil
-		}
-		db.Exec(`UPDATE logs.migrations SET version = 3;`)
-		log.Info("logs migrations v3 done")
-	}
+func MigrateTransactions(db *sql.DB, chainid uint64) error {
+	return Transactions.Apply(db, chainid)
+}
 
-	log.Info("logs migrations up to date")
-	return nil
+func MigrateLogs(db *sql.DB, chainid uint64) error {
+	return Logs.Apply(db, chainid)
 }
 
 func MigrateMempool(db *sql.DB, chainid uint64) error {
-	var tableName string
-	db.QueryRow("SELECT name FROM mempool.sqlite_master WHERE type='table' and name='migrations';").Scan(&tableName)
-	if tableName != "migrations" {
-		if _, err := db.Exec("CREATE TABLE mempool.migrations (version integer PRIMARY KEY);"); err != nil {
-			log.Error("migrations CREATE TABLE mempoool.migrations error", "err", err.Error())
-		}
-		if _, err := db.Exec("INSERT INTO mempool.migrations(version) VALUES (0);"); err != nil {
-			log.Error("migrations INSERT INTO mempool.migrations error", "err", err.Error())
-		}
-	}
-	var schemaVersion uint
-	db.QueryRow("SELECT version FROM mempool.migrations;").Scan(&schemaVersion)
-	if schemaVersion < 1 {
-		log.Info("Applying mempool v1 migration")
-		if _, err := db.Exec(`CREATE TABLE mempool.transactions (
-			gas BIGINT,
-			gasPrice BIGINT,
-			gasFeeCap varchar(32),
-			gasTipCap varchar(32),
-			hash varchar(32) UNIQUE,
-			input blob,
-			nonce BIGINT,
-			recipient varchar(20),
-			value varchar(32),
-			v SMALLINT,
-			r varchar(32),
-			s varchar(32),
-			sender varchar(20),
-			type TINYINT,
-			access_list blob);`); err != nil {
-			log.Error("migrationsCREATE TABLE mempool.transactions error", "err", err.Error())
-			}
-
-		if _, err := db.Exec(`CREATE INDEX mempool.sender ON transactions(sender, nonce);`); err != nil {
-			log.Error("migrations CREATE INDEX mempool.sender error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX mempool.recipient ON transactions(recipient);`); err != nil {
-			log.Error("migrations CREATE INDEX mempool.recipient rror", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX mempool.gasPrice ON transactions(gasPrice);`); err != nil {
-			log.Error("migrations CREATE INDEX mempool.gasPrice error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`UPDATE mempool.migrations SET version = 1;`); err != nil {
-			log.Error("migrationsUPDATE mempool.migraitons error", "err", err.Error())
-		}
-		log.Info("mempool migrations v1 done")
-	}
-
-	if schemaVersion < 2 {
-		log.Info("Applying mempool v2 migration")
-
-		if _, err := db.Exec(`ALTER TABLE mempool.transactions ADD time BIGINT;`); err != nil {
-			log.Error("migrations, mempool ALTER TABLE ADD time error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX mempool.time ON transactions(time);`); err != nil {
-			log.Error("migrations CREATE INDEX mempool.time error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE mempool.migrations SET version = 2;"); err != nil {
-			log.Error("migrations UPDATE mempool.migrations v2 error", "err", err.Error())
-		}
-		log.Info("mempool v2 migrations done")
-	}
-
-	log.Info("mempool migrations up to date")
-	return nil
+	return Mempool.Apply(db, chainid)
 }
 
-
 // *1: Previous versions of this migration had a UINIQUE constriant put on transaction hash. We found that this was redundant in practice when considered
-// alongside the txHash index, which is added below, and added considerable lag to block uptake. As of tag v1.3.0-removing-uinique-txHash0 all newer databases will have the schema 
+// alongside the txHash index, which is added below, and added considerable lag to block uptake. As of tag v1.3.0-removing-uinique-txHash0 all newer databases will have the schema
 // below while previously existing databases will retain the UNIQUE constraint but will be missing the txHash index.
@@ -0,0 +1,182 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Step migrates a database to Version from the version before it. Its
+// statements run in order, followed by Apply if it is set, all in one
+// transaction along with the version update.
+type Step struct {
+	Version    uint
+	Statements []string
+	Apply      func(tx *sql.Tx, chainid uint64) error
+}
+
+// Set is the ordered steps that migrate one attached database, tracking its
+// version in the database's migrations table. Plugins provide their own sets
+// by exporting a Migrations variable of type []migrations.Set. A set with a
+// Name migrates tables in a database another set owns, and tracks its
+// version in <Name>_migrations instead.
+type Set struct {
+	Schema string
+	Name   string
+	Steps  []Step
+}
+
+// Status reports the version of a database and the steps it has not applied.
+type Status struct {
+	Schema  string
+	Name    string
+	Current uint
+	Latest  uint
+	Pending []uint
+}
+
+// Label identifies the set a Status describes.
+func (s *Status) Label() string {
+	if s.Name == "" {
+		return s.Schema
+	}
+	return fmt.Sprintf("%v.%v", s.Schema, s.Name)
+}
+
+func (s Set) label() string {
+	return (&Status{Schema: s.Schema, Name: s.Name}).Label()
+}
+
+// table returns the name of the table tracking the set's version.
+func (s Set) table() string {
+	if s.Name == "" {
+		return "migrations"
+	}
+	return s.Name + "_migrations"
+}
+
+// Latest returns the version of the set's last step.
+func (s Set) Latest() uint {
+	if len(s.Steps) == 0 {
+		return 0
+	}
+	return s.Steps[len(s.Steps)-1].Version
+}
+
+// Version returns the current version of the set's database, which is 0 if it
+// has no migrations table.
+func (s Set) Version(db *sql.DB) (uint, error) {
+	var name string
+	db.QueryRow(fmt.Sprintf("SELECT name FROM %v.sqlite_master WHERE type='table' and name=?;", s.Schema), s.table()).Scan(&name)
+	if name != s.table() {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow(fmt.Sprintf("SELECT max(version) FROM %v.%v;", s.Schema, s.table())).Scan(&version); err != nil {
+		return 0, fmt.Errorf("%v: %w", s.label(), err)
+	}
+	return uint(version.Int64), nil
+}
+
+// Status reports the set's progress without changing the database.
+func (s Set) Status(db *sql.DB) (*Status, error) {
+	current, err := s.Version(db)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{Schema: s.Schema, Name: s.Name, Current: current, Latest: s.Latest(), Pending: []uint{}}
+	for _, step := range s.Steps {
+		if step.Version > current {
+			status.Pending = append(status.Pending, step.Version)
+		}
+	}
+	return status, nil
+}
+
+// Apply runs the steps the database has not applied, stopping at the first
+// that fails. Steps before it remain applied.
+func (s Set) Apply(db *sql.DB, chainid uint64) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v.%v (version integer PRIMARY KEY);", s.Schema, s.table())); err != nil {
+		return fmt.Errorf("%v: creating migrations table: %w", s.label(), err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("INSERT INTO %[1]v.%[2]v(version) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM %[1]v.%[2]v);", s.Schema, s.table())); err != nil {
+		return fmt.Errorf("%v: initializing migrations table: %w", s.label(), err)
+	}
+	current, err := s.Version(db)
+	if err != nil {
+		return err
+	}
+	for _, step := range s.Steps {
+		if step.Version <= current {
+			continue
+		}
+		log.Info("Applying migration", "schema", s.label(), "version", step.Version)
+		if err := s.apply(db, step, chainid); err != nil {
+			return fmt.Errorf("%v v%v migration: %w", s.label(), step.Version, err)
+		}
+		log.Info("Migration done", "schema", s.label(), "version", step.Version)
+	}
+	log.Info("Migrations up to date", "schema", s.label(), "version", s.Latest())
+	return nil
+}
+
+func (s Set) apply(db *sql.DB, step Step, chainid uint64) error {
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range step.Statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if step.Apply != nil {
+		if err := step.Apply(tx, chainid); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %v.%v SET version = ?;", s.Schema, s.table()), step.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s Set) validate() error {
+	var previous uint
+	for _, step := range s.Steps {
+		if step.Version <= previous {
+			return fmt.Errorf("%v: migration v%v follows v%v", s.label(), step.Version, previous)
+		}
+		previous = step.Version
+	}
+	return nil
+}
+
+// Migrate applies sets in order, returning the first error.
+func Migrate(db *sql.DB, sets []Set, chainid uint64) error {
+	for _, set := range sets {
+		if err := set.Apply(db, chainid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Report returns the status of each set in order.
+func Report(db *sql.DB, sets []Set) ([]*Status, error) {
+	statuses := make([]*Status, 0, len(sets))
+	for _, set := range sets {
+		status, err := set.Status(db)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,147 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDatabase(t *testing.T, schemas ...string) *sql.DB {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	db.SetMaxOpenConns(1)
+	for _, schema := range schemas {
+		if _, err := db.Exec("ATTACH DATABASE ? AS "+schema, filepath.Join(dir, schema+".sqlite")); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	return db
+}
+
+func TestCoreMigrations(t *testing.T) {
+	db := openDatabase(t, "blocks", "transactions", "logs", "mempool")
+	defer db.Close()
+	sets := Core(map[string]string{"blocks": "", "transactions": "", "logs": "", "mempool": ""})
+	if err := Migrate(db, sets, 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	statuses, err := Report(db, sets)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, status := range statuses {
+		if status.Current != status.Latest || len(status.Pending) != 0 {
+			t.Errorf("%v not fully migrated: %+v", status.Schema, status)
+		}
+	}
+	// Applying again is a no-op.
+	if err := Migrate(db, sets, 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestUnversionedDatabases(t *testing.T) {
+	db := openDatabase(t, "transactions", "logs", "mempool")
+	defer db.Close()
+	// Databases created before the migrations table hold the first version's
+	// tables and indexes.
+	sets := []Set{Transactions, Logs, Mempool}
+	for _, set := range sets {
+		for _, statement := range set.Steps[0].Statements {
+			if _, err := db.Exec(statement); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+	}
+	if err := Migrate(db, sets, 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestFailedStep(t *testing.T) {
+	db := openDatabase(t, "test")
+	defer db.Close()
+	set := Set{Schema: "test", Steps: []Step{
+		{Version: 1, Statements: []string{"CREATE TABLE test.items (id INTEGER PRIMARY KEY, name TEXT)"}},
+		{Version: 2, Statements: []string{
+			"CREATE INDEX test.itemName ON items(name)",
+			"ALTER TABLE test.missing ADD COLUMN value BIGINT",
+		}},
+		{Version: 3, Statements: []string{"ALTER TABLE test.items ADD COLUMN value BIGINT"}},
+	}}
+	status, err := set.Status(db)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if status.Current != 0 || !reflect.DeepEqual(status.Pending, []uint{1, 2, 3}) {
+		t.Errorf("unexpected status before migrating: %+v", status)
+	}
+	if err := set.Apply(db, 1); err == nil {
+		t.Fatalf("expected the second step to fail")
+	}
+	if status, _ = set.Status(db); status.Current != 1 || !reflect.DeepEqual(status.Pending, []uint{2, 3}) {
+		t.Errorf("unexpected status after failure: %+v", status)
+	}
+	var name string
+	db.QueryRow("SELECT name FROM test.sqlite_master WHERE name = 'itemName'").Scan(&name)
+	if name != "" {
+		t.Errorf("index from the failed step was not rolled back")
+	}
+
+	set.Steps[1].Statements = set.Steps[1].Statements[:1]
+	if err := set.Apply(db, 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if status, _ = set.Status(db); status.Current != 3 || len(status.Pending) != 0 {
+		t.Errorf("unexpected status after migrating: %+v", status)
+	}
+}
+
+func TestNamedSet(t *testing.T) {
+	db := openDatabase(t, "test")
+	defer db.Close()
+	owner := Set{Schema: "test", Steps: []Step{
+		{Version: 1, Statements: []string{"CREATE TABLE test.items (id INTEGER PRIMARY KEY, name TEXT)"}},
+	}}
+	plugin := Set{Schema: "test", Name: "plugin", Steps: []Step{
+		{Version: 1, Apply: func(tx *sql.Tx, chainid uint64) error {
+			_, err := tx.Exec("INSERT INTO test.items(name) VALUES (?)", "seed")
+			return err
+		}},
+		{Version: 2, Statements: []string{"CREATE INDEX test.itemName ON items(name)"}},
+	}}
+	sets := []Set{owner, plugin}
+	if err := Migrate(db, sets, 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	statuses, err := Report(db, sets)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if statuses[0].Current != 1 || statuses[1].Current != 2 || statuses[1].Label() != "test.plugin" {
+		t.Errorf("unexpected statuses %+v, %+v", statuses[0], statuses[1])
+	}
+	if err := Migrate(db, sets, 1); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var count int
+	db.QueryRow("SELECT count(*) FROM test.items").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected the plugin's step to run once, got %v rows", count)
+	}
+}
+
+func TestStepOrder(t *testing.T) {
+	db := openDatabase(t, "test")
+	defer db.Close()
+	set := Set{Schema: "test", Steps: []Step{{Version: 2}, {Version: 1}}}
+	if err := set.Apply(db, 1); err == nil {
+		t.Errorf("expected out of order steps to be rejected")
+	}
+}
@@ -19,6 +19,9 @@ import (
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-types/hexutil"
 	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/migrations"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 	"github.com/openrelayxyz/cardinal-evm/rlp"
 )
 
@@ -165,19 +168,26 @@ func accountTxList(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	if headBlockNumber > uint64(endBlock) {
 		endBlock = int(headBlockNumber)
 	}
+	params := []interface{}{}
+	matches := []interface{}{}
+	for _, column := range []string{"sender", "recipient", "contractAddress"} {
+		match, matchParams := intern.MatchAddress(column, plugins.TrimPrefix(addr.Bytes()))
+		matches = append(matches, match)
+		params = append(append(params, matchParams...), startBlock, endBlock)
+	}
 	rows, err := db.QueryContext(
 		r.Context(),
 		fmt.Sprintf(`SELECT
-      transactions.block, blocks.time, transactions.hash, transactions.nonce, blocks.hash, transactions.transactionIndex, transactions.recipient, transactions.sender, transactions.value, transactions.gas, transactions.gasPrice, transactions.status, transactions.input, transactions.contractAddress, transactions.cumulativeGasUsed, transactions.gasUsed
-    FROM transactions.transactions
+      transactions.block, blocks.time, transactions.hash, transactions.nonce, blocks.hash, transactions.transactionIndex, %[3]v, %[4]v, transactions.value, transactions.gas, transactions.gasPrice, transactions.status, transactions.input, %[5]v, transactions.cumulativeGasUsed, transactions.gasUsed
+    FROM %[1]v
     INNER JOIN blocks on blocks.number = transactions.block
-    WHERE transactions.rowid in (
-      SELECT rowid FROM transactions.transactions WHERE sender = ? AND (block >= ? AND block <= ?)
-      UNION SELECT rowid FROM transactions.transactions WHERE recipient = ? AND (block >= ? AND block <= ?)
-      UNION SELECT rowid FROM transactions.transactions WHERE contractAddress = ? AND (block >= ? AND block <= ?)
-      ORDER BY rowid %v LIMIT ? OFFSET ?
-    ) ORDER BY transactions.block %v, transactions.transactionIndex %v;`, sort, sort, sort),
-		plugins.TrimPrefix(addr.Bytes()), startBlock, endBlock, plugins.TrimPrefix(addr.Bytes()), startBlock, endBlock, plugins.TrimPrefix(addr.Bytes()), startBlock, endBlock, offset, (page-1)*offset)
+    WHERE (transactions.block, transactions.transactionIndex) in (
+      SELECT block, transactionIndex FROM %[1]v WHERE %[6]v AND (block >= ? AND block <= ?)
+      UNION SELECT block, transactionIndex FROM %[1]v WHERE %[7]v AND (block >= ? AND block <= ?)
+      UNION SELECT block, transactionIndex FROM %[1]v WHERE %[8]v AND (block >= ? AND block <= ?)
+      ORDER BY block %[2]v, transactionIndex %[2]v LIMIT ? OFFSET ?
+    ) ORDER BY transactions.block %[2]v, transactions.transactionIndex %[2]v;`, shards.Source("transactions", "transactions", uint64(startBlock), uint64(endBlock), ""), sort, intern.Address("transactions.recipient"), intern.Address("transactions.sender"), intern.Address("transactions.contractAddress"), matches[0], matches[1], matches[2]),
+		append(params, offset, (page-1)*offset)...)
 	if handleApiError(err, w, "database error", "Error! Database error", "Error querying", 500) {
 		return
 	}
@@ -295,20 +305,21 @@ func accountTokenTransferList(w http.ResponseWriter, r *http.Request, db *sql.DB
 	if nft {
 		topic3Comparison = "IS NOT"
 	}
+	transferTopic := intern.MatchTopicTo("event_logs.topic0", "X'ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef'")
 	rows, err := db.QueryContext(
 		r.Context(),
 		fmt.Sprintf(`SELECT
-      blocks.number, blocks.time, transactions.hash, transactions.nonce, blocks.hash, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.address, event_logs.data, transactions.transactionIndex, transactions.gas, transactions.gasPrice, transactions.input, transactions.cumulativeGasUsed, transactions.gasUsed
-    FROM event_logs NOT INDEXED
+      blocks.number, blocks.time, transactions.hash, transactions.nonce, blocks.hash, event_logs.topic1, event_logs.topic2, event_logs.topic3, %[1]v, event_logs.data, transactions.transactionIndex, transactions.gas, transactions.gasPrice, transactions.input, transactions.cumulativeGasUsed, transactions.gasUsed
+    FROM %[2]v
     INNER JOIN blocks on blocks.number = event_logs.block
-    INNER JOIN transactions.transactions on event_logs.transactionHash = transactions.hash
+    INNER JOIN %[3]v on transactions.block = event_logs.block AND event_logs.transactionHash = transactions.hash
     WHERE
-      event_logs.rowid IN (
-        SELECT rowid FROM event_logs INDEXED BY topic1_partial WHERE event_logs.topic0 = X'ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef' AND event_logs.topic1 = ? AND event_logs.topic3 %v NULL AND (block >= ? AND block <= ?)
-        UNION SELECT rowid FROM event_logs INDEXED BY topic2_partial WHERE event_logs.topic0 = X'ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef' AND event_logs.topic2 = ? AND event_logs.topic3 %v NULL AND (block >= ? AND block <= ?)
-        ORDER BY rowid %v LIMIT ? OFFSET ?
+      (event_logs.block, event_logs.logIndex) IN (
+        SELECT block, logIndex FROM %[4]v WHERE %[6]v AND event_logs.topic1 = ? AND event_logs.topic3 %[7]v NULL AND (block >= ? AND block <= ?)
+        UNION SELECT block, logIndex FROM %[5]v WHERE %[6]v AND event_logs.topic2 = ? AND event_logs.topic3 %[7]v NULL AND (block >= ? AND block <= ?)
+        ORDER BY block %[8]v, logIndex %[8]v LIMIT ? OFFSET ?
       )
-    ORDER BY blocks.number %v, event_logs.logIndex %v`, topic3Comparison, topic3Comparison, sort, sort, sort),
+    ORDER BY blocks.number %[8]v, event_logs.logIndex %[8]v`, intern.Address("event_logs.address"), shards.Source("logs", "event_logs", uint64(startBlock), uint64(endBlock), ""), shards.Source("transactions", "transactions", uint64(startBlock), uint64(endBlock), ""), shards.Source("logs", "event_logs", uint64(startBlock), uint64(endBlock), "INDEXED BY topic1_partial"), shards.Source("logs", "event_logs", uint64(startBlock), uint64(endBlock), "INDEXED BY topic2_partial"), transferTopic, topic3Comparison, sort),
 		plugins.TrimPrefix(addr.Bytes()), startBlock, endBlock, plugins.TrimPrefix(addr.Bytes()), startBlock, endBlock, offset, (page-1)*offset)
 	if handleApiError(err, w, "database error", "Error! Database error", "Error processing", 500) {
 		return
@@ -399,8 +410,8 @@ func accountBlocksMined(w http.ResponseWriter, r *http.Request, db *sql.DB, netw
 	blocks.number, blocks.time, blocks.baseFee, blocks.gasUsed, blocks.uncles, issuance.value, (CASE WHEN COUNT(transactions.gasUsed) > 0 THEN GROUP_CONCAT(transactions.gasUsed) ELSE 0 END), (CASE WHEN COUNT(transactions.gasUsed) > 0 THEN GROUP_CONCAT(transactions.gasPrice) ELSE 0 END)
 	FROM blocks
 	INNER JOIN issuance on blocks.number > issuance.startBlock AND blocks.number < issuance.endBlock
-	LEFT JOIN transactions.transactions on transactions.block = blocks.number
-	WHERE coinbase = ? AND (blocks.number >= ? AND blocks.number <= ?) GROUP BY blocks.number ORDER BY blocks.number %v LIMIT ? OFFSET ?;`, sort),
+	LEFT JOIN %v on transactions.block = blocks.number
+	WHERE coinbase = ? AND (blocks.number >= ? AND blocks.number <= ?) GROUP BY blocks.number ORDER BY blocks.number %v LIMIT ? OFFSET ?;`, shards.Source("transactions", "transactions", uint64(startBlock), uint64(endBlock), ""), sort),
 	plugins.TrimPrefix(addr.Bytes()), startBlock, endBlock, offset, (page-1)*offset)
 	if handleApiError(err, w, "database error", "Error! Database error", "Error querying", 500) {
 		return
@@ -576,68 +587,82 @@ func getTokenInfo(w http.ResponseWriter, r *http.Request, db *sql.DB, chainToken
 	}, 200, false)
 }
 
-func Migrate(db *sql.DB, chainid uint64) error {
+// Migrations seeds the issuance schedule used by the compat API into the
+// blocks database.
+var Migrations = []migrations.Set{{
+	Schema: "blocks",
+	Name:   "compat",
+	Steps: []migrations.Step{
+		{Version: 1, Apply: seedIssuance},
+	},
+}}
+
+// seedIssuance adds the issuance periods of chainid that start after those
+// already present, which databases from before versioned migrations may hold.
+func seedIssuance(tx *sql.Tx, chainid uint64) error {
 	var maxStartBlock int64
-	db.QueryRow(`SELECT max(startBlock) FROM issuance;`).Scan(&maxStartBlock)
+	tx.QueryRow(`SELECT max(startBlock) FROM issuance;`).Scan(&maxStartBlock)
 	switch chainid {
 	case 1:
 		if maxStartBlock < 1 {
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 4369999, 5000000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 4369999, 5000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 4370000 {
-			db.Exec(`UPDATE issuance SET endBlock = 4369999 WHERE endBlock = ?;`, maxInt);
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 4370000, 7279999, 3000000000000000000); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = 4369999 WHERE endBlock = ?;`, maxInt);
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 4370000, 7279999, 3000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 7280000 {
-			db.Exec(`UPDATE issuance SET endBlock = 7279999 WHERE endBlock = ?;`, maxInt);
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 7280000, 15537392, 2000000000000000000); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = 7279999 WHERE endBlock = ?;`, maxInt);
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 7280000, 15537392, 2000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 15537393 {
-			db.Exec(`UPDATE issuance SET endBlock = 15537392 WHERE endBlock = ?;`, maxInt);
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 15537393, maxInt, 0); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = 15537392 WHERE endBlock = ?;`, maxInt);
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 15537393, maxInt, 0); err != nil { return err }
 		}
 	case 61:
 		// ETC's issuance is planned out indefinitely
 		if maxStartBlock < 1 {
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 5000000, 5000000000000000000); err != nil { return err }
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 5000001, 10000000, 4000000000000000000); err != nil { return err }
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 10000001, 15000000, 3200000000000000000); err != nil { return err }
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 15000001, 20000000, 2560000000000000000); err != nil { return err }
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 20000001, 25000000, 2048000000000000000); err != nil { return err }
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 25000001, maxInt, 0); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 5000000, 5000000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 5000001, 10000000, 4000000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 10000001, 15000000, 3200000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 15000001, 20000000, 2560000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 20000001, 25000000, 2048000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 25000001, maxInt, 0); err != nil { return err }
 		}
 	case 3:
 		if maxStartBlock < 1 {
-			db.Exec(`UPDATE issuance SET endBlock = 1699999 WHERE endBlock = ?;`, maxInt)
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 1699999, 5000000000000000000); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = 1699999 WHERE endBlock = ?;`, maxInt)
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 1699999, 5000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 1700000 {
-			db.Exec(`UPDATE issuance SET endBlock = 4229999 WHERE endBlock = ?;`, maxInt)
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1700000, 4229999, 3000000000000000000); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = 4229999 WHERE endBlock = ?;`, maxInt)
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1700000, 4229999, 3000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 4230000 {
-			db.Exec(`UPDATE issuance SET endBlock = maxInt WHERE endBlock = ?;`, maxInt)
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 4230000, 12350712, 2000000000000000000); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = maxInt WHERE endBlock = ?;`, maxInt)
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 4230000, 12350712, 2000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 12350713 {
-			db.Exec(`UPDATE issuance SET endBlock = maxInt WHERE endBlock = ?;`, maxInt)
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 12350712, maxInt, 0); err != nil { return err }
+			tx.Exec(`UPDATE issuance SET endBlock = maxInt WHERE endBlock = ?;`, maxInt)
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 12350712, maxInt, 0); err != nil { return err }
 		}
 	case 5:
 		if maxStartBlock < 1 {
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 7382818, 5000000000000000000); err != nil { return err }
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 7382819, maxInt, 0); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 7382818, 5000000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 7382819, maxInt, 0); err != nil { return err }
 		}
 	case 11155111:
 		if maxStartBlock < 1 {
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 1450408, 2000000000000000000); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, 1450408, 2000000000000000000); err != nil { return err }
 		}
 		if maxStartBlock < 1450409 {
-			if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1450409, maxInt, 0); err != nil { return err }
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1450409, maxInt, 0); err != nil { return err }
 		}
 	default:
 		// Covers polygon, mumbai, rinkeby, and probably others.
-		if _, err := db.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, maxInt, 0); err != nil { return err }
+		if maxStartBlock < 1 {
+			if _, err := tx.Exec(`INSERT INTO issuance(startBlock, endBlock, value) VALUES (?, ?, ?)`, 1, maxInt, 0); err != nil { return err }
+		}
 	}
 	return nil
 }
@@ -263,7 +263,7 @@ func TestPolygonApi(t *testing.T) {
 			t.Fatalf(err.Error())
 		}
 	}
-	if err := Migrate(db, cfg.Chainid); err != nil {
+	if err := migrations.Migrate(db, Migrations, cfg.Chainid); err != nil {
 		t.Fatalf(err.Error())
 	}
 	batches, err := pendingBatchDecompress()
@@ -273,8 +273,8 @@ func TestPolygonApi(t *testing.T) {
 	}
 	indexers := []indexer.Indexer{}
 	indexers = append(indexers, indexer.NewBlockIndexer(cfg.Chainid))
-	indexers = append(indexers, indexer.NewTxIndexer(cfg.Chainid, cfg.Eip155Block, cfg.HomesteadBlock, mempool))
-	indexers = append(indexers, indexer.NewLogIndexer(cfg.Chainid))
+	indexers = append(indexers, indexer.NewTxIndexer(cfg.Chainid, cfg.Eip155Block, cfg.HomesteadBlock, mempool, false))
+	indexers = append(indexers, indexer.NewLogIndexer(cfg.Chainid, cfg.InternLogs))
 	indexers = append(indexers, Indexer(cfg))
 
 	statements := []string{}
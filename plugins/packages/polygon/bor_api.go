@@ -18,8 +18,10 @@ import (
 	"github.com/openrelayxyz/cardinal-types/hexutil"
 	"github.com/openrelayxyz/cardinal-evm/crypto"
 	"github.com/openrelayxyz/cardinal-flume/heavy"
+	"github.com/openrelayxyz/cardinal-flume/intern"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
 	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 	"golang.org/x/crypto/sha3"
 
 )
@@ -157,7 +159,7 @@ var (
 
 func (service *PolygonBorService) GetRootHash(ctx context.Context, start uint64, end uint64) (string, error) {
 
-	if len(service.cfg.HeavyServer) > 0 && start < service.cfg.EarliestBlock {
+	if len(service.cfg.HeavyServer) > 0 && start < service.cfg.GetEarliestBlock() {
 		log.Debug("bor_getRootHash sent to flume heavy")
 		polygonMissMeter.Mark(1)
 		bgrhMissMeter.Mark(1)
@@ -355,7 +357,7 @@ func AppendBorLogs(indexClause, whereClause string, params []interface{}) (strin
 	paramsDoubled = append(paramsDoubled, params...)
 	paramsDoubled = append(paramsDoubled, params...)
 	
-	standardQuery := fmt.Sprintf("SELECT address, topic0, topic1, topic2, topic3, data, block, transactionHash, transactionIndex, blockHash, logIndex FROM event_logs %v WHERE %v", indexClause, whereClause)
+	standardQuery := fmt.Sprintf("SELECT %v, %v, topic1, topic2, topic3, data, block, transactionHash, transactionIndex, blockHash, logIndex FROM %v WHERE %v", intern.Address("address"), intern.Topic("topic0"), shards.Source("logs", "event_logs", 0, math.MaxUint64, indexClause), whereClause)
 	borQuery := fmt.Sprintf("SELECT address, topic0, topic1, topic2, topic3, data, block, transactionHash, transactionIndex, blockHash, logIndex FROM bor_logs %v WHERE %v;", borIndexClause, whereClause)
 	unifiedQuery := standardQuery + " UNION ALL " + borQuery
 	
@@ -338,7 +338,7 @@ func (service *PolygonEthService) GetTransactionReceiptsByBlock(ctx context.Cont
 			}
 
 			column = plugins.TrimPrefix(hash.Bytes())
-			whereClause = "blocks.hash = ?"
+			whereClause = plugins.BlockHashClause
 			borTxQuery = "SELECT transactionHash FROM bor.bor_logs WHERE blockHash = ?;"
 			service.db.QueryRowContext(context.Background(), borTxQuery, column).Scan(&borTxHashBytes)
 		default:
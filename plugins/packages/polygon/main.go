@@ -25,6 +25,14 @@ var TrackedPrefixes = []*regexp.Regexp{
 	regexp.MustCompile("c/[0-9a-z]+/b/[0-9a-z]+/bs"),
 }
 
+// PrunedTables maps the bor tables to their block column, so that their rows
+// are removed along with the blocks they belong to.
+var PrunedTables = map[string]string{
+	"bor.bor_receipts":  "block",
+	"bor.bor_logs":      "block",
+	"bor.bor_snapshots": "block",
+}
+
 func Initialize(cfg *config.Config, pl *plugins.PluginLoader) {
 	log.Info("Polygon plugin loaded")
 }
@@ -110,7 +118,7 @@ func borBlockDataPresent(input interface{}, cfg *config.Config, db *sql.DB) bool
 	present := true
 	switch input.(type) {
 	case rpc.BlockNumber:
-		if uint64(input.(rpc.BlockNumber)) < cfg.EarliestBlock {
+		if uint64(input.(rpc.BlockNumber)) < cfg.GetEarliestBlock() {
 			present = false
 			return present
 		}
@@ -129,7 +137,7 @@ func borBlockDataPresent(input interface{}, cfg *config.Config, db *sql.DB) bool
 		blockHash, hshOk := bNumOrHsh.Hash()
 
 		if numOk{
-			if uint64(blockNumber) < cfg.EarliestBlock {
+			if uint64(blockNumber) < cfg.GetEarliestBlock() {
 				present = false
 				return present
 			}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"database/sql"
 	"encoding/binary"
 	"regexp"
@@ -18,6 +17,7 @@ import (
 	"github.com/openrelayxyz/cardinal-streams/delivery"
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/migrations"
 	"github.com/openrelayxyz/cardinal-flume/plugins"
 )
 
@@ -32,6 +32,14 @@ type PolygonIndexer struct {
 }
 
 func (pg *PolygonIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
+	statements, err := pg.IndexStatements(pb)
+	if err != nil {
+		return nil, err
+	}
+	return indexer.RenderStatements(statements), nil
+}
+
+func (pg *PolygonIndexer) IndexStatements(pb *delivery.PendingBatch) ([]indexer.Statement, error) {
 
 	encNum := make([]byte, 8)
 	binary.BigEndian.PutUint64(encNum, uint64(pb.Number))
@@ -40,16 +48,16 @@ func (pg *PolygonIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 	receiptData := make(map[int][]byte)
 	logData := make(map[int64]*evm.Log)
 
-	statements := []string{indexer.ApplyParameters("DELETE FROM bor_receipts WHERE block >= %v", pb.Number), 
-	indexer.ApplyParameters("DELETE FROM bor_logs WHERE block >= %v", pb.Number), 
-	indexer.ApplyParameters("DELETE FROM bor_snapshots WHERE block >= %v", pb.Number)}
+	statements := []indexer.Statement{indexer.NewStatement("DELETE FROM bor_receipts WHERE block >= ?", pb.Number), 
+	indexer.NewStatement("DELETE FROM bor_logs WHERE block >= ?", pb.Number), 
+	indexer.NewStatement("DELETE FROM bor_snapshots WHERE block >= ?", pb.Number)}
 
 	snapshotBytes := pb.Values[fmt.Sprintf("c/%x/b/%x/bs", pg.Chainid, pb.Hash.Bytes())]
 
 	if len(snapshotBytes) > 0 {
 		log.Debug("found bor snapshot on block", "block", pb.Number)
-		statements = append(statements, indexer.ApplyParameters(
-			"INSERT INTO bor_snapshots(block, blockHash, snapshot) VALUES (%v, %v, %v)",
+		statements = append(statements, indexer.NewStatement(
+			"INSERT INTO bor_snapshots(block, blockHash, snapshot) VALUES (?, ?, ?)",
 			pb.Number, 
 			pb.Hash,
 			plugins.Compress(snapshotBytes),
@@ -67,7 +75,7 @@ func (pg *PolygonIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 		log.Error("getBlockAuthor error", "err", err.Error())
 	}
 
-	stmt := indexer.ApplyParameters("UPDATE blocks.blocks SET coinbase = %v WHERE number = %v", author, pb.Number)
+	stmt := indexer.NewStatement("UPDATE blocks.blocks SET coinbase = ? WHERE number = ?", author, pb.Number)
 	statements = append(statements, stmt)
 
 	for k, v := range pb.Values {
@@ -93,8 +101,8 @@ func (pg *PolygonIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 		}
 	
 		for txIndex, logsBloom := range receiptData {
-			statements = append(statements, indexer.ApplyParameters(
-				"INSERT INTO bor_receipts(hash, transactionIndex, logsBloom, block) VALUES (%v, %v, %v, %v)",
+			statements = append(statements, indexer.NewStatement(
+				"INSERT INTO bor_receipts(hash, transactionIndex, logsBloom, block) VALUES (?, ?, ?, ?)",
 				txHash,
 				txIndex,
 				plugins.Compress(logsBloom),
@@ -102,8 +110,8 @@ func (pg *PolygonIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 			))
 		}
 		for logIndex, logRecord := range logData {
-			statements = append(statements, indexer.ApplyParameters(
-				"INSERT INTO bor_logs(address, topic0, topic1, topic2, topic3, data, transactionHash, transactionIndex, blockHash, block, logIndex) VALUES (%v, %v, %v, %v, %v, %v, %v, %v, %v, %v, %v)",
+			statements = append(statements, indexer.NewStatement(
+				"INSERT INTO bor_logs(address, topic0, topic1, topic2, topic3, data, transactionHash, transactionIndex, blockHash, block, logIndex) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 				logRecord.Address,
 				plugins.GetTopicIndex(logRecord.Topics, 0),
 				plugins.GetTopicIndex(logRecord.Topics, 1),
@@ -121,231 +129,108 @@ func (pg *PolygonIndexer) Index(pb *delivery.PendingBatch) ([]string, error) {
 }
 
 
-func Migrate(db *sql.DB, chainid uint64) error {
-	var tableName string
-	db.QueryRow("SELECT name FROM bor.sqlite_master WHERE type='table' and name='migrations';").Scan(&tableName)
-	if tableName != "migrations" {
-		if _, err := db.Exec("CREATE TABLE bor.migrations (version integer PRIMARY KEY);"); err != nil {
-			log.Error("bor migrations, CREATE TABLE bor.migrations error", "err", err.Error())
-		}
-
-		if _, err := db.Exec("INSERT INTO bor.migrations(version) VALUES (0);"); err != nil {
-			log.Error("bor migrations, INSERT INTO bor.migrations error", "err", err.Error())
-		}
-	}
-	var schemaVersion uint
-	db.QueryRow("SELECT version FROM bor.migrations;").Scan(&schemaVersion)
-	if schemaVersion < 1 {
-		log.Info("Applying bor v1 migration")
-		if _, err := db.Exec(`CREATE TABLE bor.bor_receipts (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			hash varchar(32) UNIQUE,
-			transactionIndex MEDIUMINT,
-			logsBloom blob,
-			block BIGINT
-	        );`); err != nil {
-				log.Error("bor migrations, CREATE TABLE bor.bor_receipts error", "err", err.Error())
-				return nil
-			}
-
-		if _, err := db.Exec(`CREATE INDEX bor.receiptBlock ON bor_receipts(block)`); err != nil {
-			log.Error("bor_receiptBlock CREATE INDEX error", "err", err.Error())
-			return nil
-		}
-
-		if _, err := db.Exec(`CREATE TABLE bor.bor_logs (
-			address varchar(20),
-			topic0 varchar(32),
-			topic1 varchar(32),
-			topic2 varchar(32),
-			topic3 varchar(32),
-			data blob,
-			transactionHash varchar(32),
-			transactionIndex varcahr(32),
-			blockHash varchar(32),
-			block BIGINT,
-			logIndex MEDIUMINT,
-			PRIMARY KEY (block, logIndex)
-			);`); err != nil {
-				log.Error("bor migrations, CREATE TABLE bor_logs error", "err", err.Error())
-				return nil
-			}
-			
-		if _, err := db.Exec(`CREATE INDEX bor.logsTxHash ON bor_logs(transactionHash)`); err != nil {
-			log.Error("bor migrations, CREATE INDEX error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX bor.logsBkHash ON bor_logs(blockHash)`); err != nil {
-			log.Error("bor migrations, CREATE INDEX error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec("UPDATE bor.migrations SET version = 1;"); err != nil {
-			log.Error("bor migrations, migrations SET version 1 error", "err", err.Error())
-			return nil
-		}
-		log.Info("bor migrations v1 done")
+// Migrations migrates the bor database.
+var Migrations = []migrations.Set{{
+	Schema: "bor",
+	Steps: []migrations.Step{
+		{Version: 1, Statements: []string{
+			`CREATE TABLE bor.bor_receipts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				hash varchar(32) UNIQUE,
+				transactionIndex MEDIUMINT,
+				logsBloom blob,
+				block BIGINT
+			)`,
+			`CREATE INDEX bor.receiptBlock ON bor_receipts(block)`,
+			`CREATE TABLE bor.bor_logs (
+				address varchar(20),
+				topic0 varchar(32),
+				topic1 varchar(32),
+				topic2 varchar(32),
+				topic3 varchar(32),
+				data blob,
+				transactionHash varchar(32),
+				transactionIndex varcahr(32),
+				blockHash varchar(32),
+				block BIGINT,
+				logIndex MEDIUMINT,
+				PRIMARY KEY (block, logIndex)
+			)`,
+			`CREATE INDEX bor.logsTxHash ON bor_logs(transactionHash)`,
+			`CREATE INDEX bor.logsBkHash ON bor_logs(blockHash)`,
+		}},
+		{Version: 2, Apply: migrateBlockAuthors},
+		{Version: 3, Statements: []string{
+			`CREATE TABLE bor.bor_snapshots (block BIGINT PRIMARY KEY, blockHash varchar(32) UNIQUE, snapshot blob)`,
+			`CREATE INDEX bor.bkHash ON bor_snapshots(blockHash)`,
+		}},
+		{Version: 4, Statements: []string{
+			`CREATE INDEX bor.address_compound ON bor_logs(address, block)`,
+			`CREATE INDEX bor.topic0_compound ON bor_logs(topic0, block)`,
+			`CREATE INDEX bor.topic1_partial ON bor_logs(topic1, topic0, address, block) WHERE topic1 IS NOT NULL`,
+			`CREATE INDEX bor.topic2_partial ON bor_logs(topic2, topic0, address, block) WHERE topic2 IS NOT NULL`,
+			`CREATE INDEX bor.topic3_partial ON bor_logs(topic3, topic0, address, block) WHERE topic3 IS NOT NULL`,
+			`CREATE TABLE address_hints (address varchar(20))`,
+		}},
+		{Version: 5, Statements: []string{
+			`CREATE INDEX bor.address_topic0_compound ON bor_logs(address, topic0, block)`,
+		}},
+	},
+}}
+
+// migrateBlockAuthors sets the coinbase of blocks indexed before the polygon
+// indexer recorded block authors.
+func migrateBlockAuthors(tx *sql.Tx, chainid uint64) error {
+	rows, err := tx.Query("SELECT parentHash, uncleHash, root, txRoot, receiptRoot, bloom, difficulty, number, gasLimit, gasUsed, `time`, extra, mixDigest, nonce, baseFee FROM blocks.blocks WHERE coinbase = X'00';")
+	if err != nil {
+		return err
 	}
-	if schemaVersion < 2 {
-		log.Info("Applying bor v2 migration")
-
-		var highestBlock uint64
-		db.QueryRow("SELECT MAX(number) FROM blocks.blocks;").Scan(&highestBlock)
-		terminus := highestBlock / 500 * 500
-
-		dbtx, err := db.BeginTx(context.Background(), nil)
+	defer rows.Close()
+	for rows.Next() {
+		var bloomBytes, parentHash, uncleHash, root, txRoot, receiptRoot, extra, mixDigest, baseFee []byte
+		var number, gasLimit, gasUsed, time, difficulty uint64
+		var nonce int64
+		if err := rows.Scan(&parentHash, &uncleHash, &root, &txRoot, &receiptRoot, &bloomBytes, &difficulty, &number, &gasLimit, &gasUsed, &time, &extra, &mixDigest, &nonce, &baseFee); err != nil {
+			return err
+		}
+		logsBloom, err := plugins.Decompress(bloomBytes)
 		if err != nil {
-			log.Warn("Error creating a transaction polygon plugin", "err", err.Error())
-		}
-		rows, _ := db.QueryContext(context.Background(), "SELECT parentHash, uncleHash, root, txRoot, receiptRoot, bloom, difficulty, number, gasLimit, gasUsed, `time`, extra, mixDigest, nonce, baseFee FROM blocks.blocks WHERE coinbase = X'00';")
-		defer rows.Close()
-
-		for rows.Next() {
-			var bloomBytes, parentHash, uncleHash, root, txRoot, receiptRoot, extra, mixDigest, baseFee []byte
-			var number, gasLimit, gasUsed, time, difficulty uint64
-			var nonce int64
-			err := rows.Scan(&parentHash, &uncleHash, &root, &txRoot, &receiptRoot, &bloomBytes, &difficulty, &number, &gasLimit, &gasUsed, &time, &extra, &mixDigest, &nonce, &baseFee)
-			if err != nil {
-				log.Info("scan error", "err", err.Error())
-				return nil
-			}
-			
-
-			logsBloom, _ := plugins.Decompress(bloomBytes)
-			if err != nil {
-				log.Info("Error decompressing data", "err", err.Error())
-			}
-			
-			var lb [256]byte
-			copy(lb[:], logsBloom)
-			var bn [8]byte
-			binary.BigEndian.PutUint64(bn[:], uint64(nonce))
-			dif := new(big.Int).SetUint64(difficulty)
-			num := new(big.Int).SetUint64(number)
-			hdr := &evm.Header{
-				ParentHash: plugins.BytesToHash(parentHash),
-				UncleHash: plugins.BytesToHash(uncleHash),
-				Root: plugins.BytesToHash(root),
-				TxHash: plugins.BytesToHash(txRoot),
-				ReceiptHash: plugins.BytesToHash(receiptRoot),
-				Bloom: lb,
-				Difficulty: dif,
-				Number: num,
-				GasLimit: gasLimit,
-				GasUsed: gasUsed,
-				Time: time,
-				Extra: extra,
-				MixDigest: plugins.BytesToHash(mixDigest),
-				Nonce: bn,
-			}
-			if len(baseFee) > 0 {
-				hdr.BaseFee = new(big.Int).SetBytes(baseFee)
-			}
-			var miner common.Address
-			if len(hdr.Extra) == 0 {
-				miner = common.Address{}
-			} else {
-				miner, _ = getBlockAuthor(hdr)
-			}
-
-			statement := indexer.ApplyParameters("UPDATE blocks.blocks SET coinbase = %v WHERE number = %v", miner, number) 
-
-			
-			if _, err := dbtx.Exec(statement); err != nil {
-				dbtx.Rollback()
-				log.Warn("Failed to insert statement polygong migration v2", "err", err.Error())
-				return nil
-			}
-			if number <= terminus {
-				if number%500 == 0 { 
-					if err := dbtx.Commit(); err != nil {
-						log.Error("Failed to commit statements in loop, polygon plugin", "blockNumber", number, "err", err.Error())
-						return nil
-					}
-					log.Info("bor migration in progress", "blockNumber", number)
-					dbtx, err = db.BeginTx(context.Background(), nil)
-					if err != nil {
-						log.Error("Error creating a transaction in loop, polygon plugin", "err", err.Error())
-						return nil
-					}
-				} 
-			}
-			if number == highestBlock {
-				if err := dbtx.Commit(); err != nil {
-					log.Error("Failed to insert statements at terminus, polygon plugin", "blockNumber", number, "err", err.Error())
-					return nil
-				}
-				log.Info("polygon migration v2 finished on block", "blockNumber", number)
-			}
-		}
-		if _, err := db.Exec("UPDATE bor.migrations SET version = 2;"); err != nil {
-			log.Error("bor migrations, migrations SET version 2 error", "err", err.Error())
-			return nil
-		}
-		log.Info("bor migrations v2 done")
-	}
-
-	if schemaVersion < 3 {
-		log.Info("Applying bor v3 migration")
-		if _, err := db.Exec(`CREATE TABLE bor.bor_snapshots (block BIGINT PRIMARY KEY, blockHash varchar(32) UNIQUE, snapshot blob);`); err != nil {
-			log.Error("bor migrations, CREATE TABLE bor.bor_snapshots error", "err", err.Error())
-		}
-		
-		log.Info("bor snapshot table created")
-
-		if _, err := db.Exec(`CREATE INDEX bor.bkHash ON bor_snapshots(blockHash);`); err != nil {
-			log.Error("bor migrations, CREATE INDEX bkHash error", "err", err.Error())
-			return nil
+			return err
+		}
+		var lb [256]byte
+		copy(lb[:], logsBloom)
+		var bn [8]byte
+		binary.BigEndian.PutUint64(bn[:], uint64(nonce))
+		hdr := &evm.Header{
+			ParentHash: plugins.BytesToHash(parentHash),
+			UncleHash: plugins.BytesToHash(uncleHash),
+			Root: plugins.BytesToHash(root),
+			TxHash: plugins.BytesToHash(txRoot),
+			ReceiptHash: plugins.BytesToHash(receiptRoot),
+			Bloom: lb,
+			Difficulty: new(big.Int).SetUint64(difficulty),
+			Number: new(big.Int).SetUint64(number),
+			GasLimit: gasLimit,
+			GasUsed: gasUsed,
+			Time: time,
+			Extra: extra,
+			MixDigest: plugins.BytesToHash(mixDigest),
+			Nonce: bn,
+		}
+		if len(baseFee) > 0 {
+			hdr.BaseFee = new(big.Int).SetBytes(baseFee)
+		}
+		var miner common.Address
+		if len(hdr.Extra) > 0 {
+			miner, _ = getBlockAuthor(hdr)
+		}
+		stmt := indexer.NewStatement("UPDATE blocks.blocks SET coinbase = ? WHERE number = ?", miner, number)
+		if _, err := tx.Exec(stmt.Query, stmt.Args...); err != nil {
+			return err
+		}
+		if number%100000 == 0 {
+			log.Info("bor migration in progress", "blockNumber", number)
 		}
-		if _, err := db.Exec("UPDATE bor.migrations SET version = 3;"); err != nil {
-			log.Error("bor migrations, migrations SET version 3 error", "err", err.Error())
-			return nil
-		}
-		log.Info("bor migrations v3 done")
 	}
-
-	if schemaVersion < 4 {
-		log.Info("Applying bor v4 migration")
-
-		if _, err := db.Exec(`CREATE INDEX bor.address_compound ON bor_logs(address, block);`); err != nil {
-			log.Error("bor migrations, CREATE INDEX address_compound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX bor.topic0_compound ON bor_logs(topic0, block);`); err != nil {
-			log.Error("bor migrations, CREATE INDEX topic0_compound error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX bor.topic1_partial ON bor_logs(topic1, topic0, address, block) WHERE topic1 IS NOT NULL;`); err != nil {
-			log.Error("bor migrations, CREATE INDEX topic1_partial error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX bor.topic2_partial ON bor_logs(topic2, topic0, address, block) WHERE topic2 IS NOT NULL;`); err != nil {
-			log.Error("bor migrations, CREATE INDEX topic2_partial error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE INDEX bor.topic3_partial ON bor_logs(topic3, topic0, address, block) WHERE topic3 IS NOT NULL;`); err != nil {
-			log.Error("bor migrations, CREATE INDEX topic3_partial error", "err", err.Error())
-			return nil
-		}
-		if _, err := db.Exec(`CREATE TABLE address_hints (address varchar(20));`); err != nil {
-			log.Error("bor migrations, CREATE TABLE address_hints error", "err", err.Error())
-			return nil
-		}
-
-		if _, err := db.Exec("UPDATE bor.migrations SET version = 4;"); err != nil {
-			log.Error("bor migrations, migrations SET version 4 error", "err", err.Error())
-			return nil
-		}
-		log.Info("bor migrations v4 done")
-	}
-	if schemaVersion < 5 {
-		if _, err := db.Exec(`CREATE INDEX bor.address_topic0_compound ON bor_logs(address, topic0, block);`); err != nil {
-			log.Error("Migrate bor_logs CREATE INDEX bor.addresstopic0_compound error", "err", err.Error())
-			return nil
-		}
-		db.Exec(`UPDATE bor.migrations SET version = 5;`)
-		log.Info("bor migrations v5 done")
-	}
-	
-	log.Info("bor migrations up to date")
-	return nil
-}
\ No newline at end of file
+	return rows.Err()
+}
@@ -102,7 +102,7 @@ func (service *PolygonBorService) GetSnapshot(ctx context.Context, blockNrOrHash
 		blockNumber = uint64(number)
 		requiredSnapshot := blockNumber - (blockNumber % 64)
 
-		if len(service.cfg.HeavyServer) > 0 && requiredSnapshot < service.cfg.EarliestBlock {
+		if len(service.cfg.HeavyServer) > 0 && requiredSnapshot < service.cfg.GetEarliestBlock() {
 			log.Debug("bor_getSnapshot sent to flume heavy")
 			polygonMissMeter.Mark(1)
 			bgssMissMeter.Mark(1)
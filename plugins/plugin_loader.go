@@ -4,7 +4,7 @@ import (
 	// "io/ioutil"
 	"path"
 	"plugin"
-	// "strings"
+	"strings"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/openrelayxyz/cardinal-flume/config"
@@ -38,6 +38,18 @@ func (pl *PluginLoader) Lookup(name string, validate func(interface{}) bool) []i
 	return results
 }
 
+// LookupNamed is like Lookup, but keys each match by the name of the plugin
+// providing it, as listed in the config.
+func (pl *PluginLoader) LookupNamed(name string, validate func(interface{}) bool) map[string]interface{} {
+	results := make(map[string]interface{})
+	for _, plugin := range pl.Plugins {
+		if v, err := plugin.p.Lookup(name); err == nil && validate(v) {
+			results[strings.TrimSuffix(path.Base(plugin.Name), ".so")] = v
+		}
+	}
+	return results
+}
+
 func NewPluginLoader(cfg *config.Config) (*PluginLoader, error) {
 	pl := &PluginLoader{
 		Plugins:     []pluginDetails{},
@@ -5,19 +5,25 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"math/big"
 
 	"github.com/openrelayxyz/cardinal-evm/common"
 	"github.com/openrelayxyz/cardinal-types"
 	"github.com/openrelayxyz/cardinal-types/hexutil"
+	"github.com/openrelayxyz/cardinal-flume/codec"
+	"github.com/openrelayxyz/cardinal-flume/intern"
+	"github.com/openrelayxyz/cardinal-flume/shards"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/klauspost/compress/zlib"
-	"io"
-	"io/ioutil"
 	"sort"
 )
 
+// BlockHashClause matches the transactions of the block with a bound hash. It
+// constrains transactions.block rather than joining on blocks.hash so that
+// SQLite applies it to each shard's txblock index.
+const BlockHashClause = "transactions.block = (SELECT number FROM blocks.blocks WHERE hash = ?)"
+
 func BytesToHash(data []byte) types.Hash {
 	result := types.Hash{}
 	copy(result[32-len(data):], data[:])
@@ -39,18 +45,7 @@ func bytesToAddressPtr(data []byte) *common.Address {
 }
 
 func Decompress(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return data, nil
-	}
-	r, err := zlib.NewReader(bytes.NewBuffer(data))
-	if err != nil {
-		return []byte{}, err
-	}
-	raw, err := ioutil.ReadAll(r)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return raw, nil
-	}
-	return raw, err
+	return codec.Decompress(data)
 }
 
 func TrimPrefix(data []byte) []byte {
@@ -73,23 +68,11 @@ func UintToHexBig(a uint64) *hexutil.Big {
 	return &x
 }
 
-var compressor *zlib.Writer
-var compressionBuffer = bytes.NewBuffer(make([]byte, 0, 5*1024*1024))
 // var extraSeal = 65
 
+// Compress zlib compresses plugin blobs.
 func Compress(data []byte) []byte {
-	if len(data) == 0 {
-		return data
-	}
-	compressionBuffer.Reset()
-	if compressor == nil {
-		compressor = zlib.NewWriter(compressionBuffer)
-	} else {
-		compressor.Reset(compressionBuffer)
-	}
-	compressor.Write(data)
-	compressor.Close()
-	return compressionBuffer.Bytes()
+	return codec.CompressZlib(data)
 }
 
 func GetLogs(db *sql.DB, blockNumber uint64, bkHash types.Hash, txIndex uint64) (SortLogs, error) {
@@ -166,15 +149,15 @@ func GetLogsBloom(db *sql.DB, blockNumber uint64) ([]byte, error) {
 } 
 
 func GetTransactionReceiptsBlock(ctx context.Context, db *sql.DB, offset, limit int, chainid uint64, whereClause string, params ...interface{}) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, transactions.recipient, transactions.transactionIndex, transactions.sender, transactions.contractAddress, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM transactions.transactions INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %v ORDER BY transactions.rowid LIMIT ? OFFSET ?;", whereClause)
+	query := fmt.Sprintf("SELECT blocks.hash, transactions.block, transactions.gasUsed, transactions.cumulativeGasUsed, transactions.hash, %[3]v, transactions.transactionIndex, %[4]v, %[5]v, transactions.logsBloom, transactions.status, transactions.type, transactions.gasPrice FROM %[1]v INNER JOIN blocks.blocks ON blocks.number = transactions.block WHERE %[2]v ORDER BY transactions.id LIMIT ? OFFSET ?;", shards.Source("transactions", "transactions", 0, math.MaxUint64, ""), whereClause, intern.Address("transactions.recipient"), intern.Address("transactions.sender"), intern.Address("transactions.contractAddress"))
 	logsQuery := fmt.Sprintf(`
-		SELECT event_logs.transactionHash, event_logs.block, event_logs.address, event_logs.topic0, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.data, event_logs.logIndex
-		FROM event_logs
+		SELECT event_logs.transactionHash, event_logs.block, %v, %v, event_logs.topic1, event_logs.topic2, event_logs.topic3, event_logs.data, event_logs.logIndex
+		FROM %v
 		WHERE (transactionHash, block) IN (
 			SELECT transactions.hash, block
-			FROM transactions.transactions INNER JOIN blocks.blocks ON transactions.block = blocks.number
+			FROM %v INNER JOIN blocks.blocks ON transactions.block = blocks.number
 			WHERE %v
-		);`, whereClause)
+		);`, intern.Address("event_logs.address"), intern.Topic("event_logs.topic0"), shards.Source("logs", "event_logs", 0, math.MaxUint64, ""), shards.Source("transactions", "transactions", 0, math.MaxUint64, ""), whereClause)
 	return getTransactionReceiptsQuery(ctx, db, offset, limit, chainid, query, logsQuery, params...)
 }
 
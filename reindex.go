@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openrelayxyz/cardinal-flume/config"
+	"github.com/openrelayxyz/cardinal-flume/indexer"
+	"github.com/openrelayxyz/cardinal-flume/plugins"
+)
+
+// newReindexer builds a reindexer for the FROM-TO range in spec, running the
+// comma separated indexers in names: blocks, transactions, logs, or the name
+// of a plugin providing an indexer.
+func newReindexer(db *sql.DB, cfg *config.Config, pl *plugins.PluginLoader, mut *sync.RWMutex, spec, names string, concurrency int, hasMempool bool) (*indexer.Reindexer, error) {
+	from, to, err := parseRange(spec)
+	if err != nil {
+		return nil, err
+	}
+	pluginIndexers := pl.LookupNamed("Indexer", func(v interface{}) bool {
+		_, ok := v.(func(*config.Config) indexer.Indexer)
+		return ok
+	})
+	list := strings.Split(names, ",")
+	indexers := make([]indexer.Indexer, 0, len(list))
+	for i, name := range list {
+		name = strings.TrimSpace(name)
+		list[i] = name
+		if _, ok := cfg.Databases[name]; !ok && (name == "blocks" || name == "transactions" || name == "logs") {
+			return nil, fmt.Errorf("cannot reindex %v, the database is not configured", name)
+		}
+		switch name {
+		case "blocks":
+			indexers = append(indexers, indexer.NewBlockIndexer(cfg.Chainid))
+		case "transactions":
+			indexers = append(indexers, indexer.NewTxIndexer(cfg.Chainid, cfg.Eip155Block, cfg.HomesteadBlock, hasMempool, cfg.InternTransactions))
+		case "logs":
+			indexers = append(indexers, indexer.NewLogIndexer(cfg.Chainid, cfg.InternLogs))
+		default:
+			fn, ok := pluginIndexers[name]
+			if !ok {
+				return nil, fmt.Errorf("no indexer named %v", name)
+			}
+			idx := fn.(func(*config.Config) indexer.Indexer)(cfg)
+			if idx == nil {
+				return nil, fmt.Errorf("plugin %v provided no indexer", name)
+			}
+			indexers = append(indexers, idx)
+		}
+	}
+	return indexer.NewReindexer(db, cfg, mut, list, indexers, from, to, concurrency)
+}
+
+// parseRange parses a FROM-TO block range.
+func parseRange(spec string) (int64, int64, error) {
+	bounds := strings.SplitN(spec, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("range must be FROM-TO, got %v", spec)
+	}
+	from, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from < 0 || to < from {
+		return 0, 0, fmt.Errorf("invalid range %v", spec)
+	}
+	return from, to, nil
+}
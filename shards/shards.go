@@ -0,0 +1,201 @@
+// Package shards splits the logs and transactions databases into files that
+// each hold a range of blocks.
+//
+// The database configured under the logical name holds the rows of blocks
+// before the first shard, along with the tables that are not sharded. Each
+// shard is attached as <name>_<n> and holds the rows from its From block until
+// the next shard begins, so the last shard is the one being indexed. Shards
+// are attached to every connection, so adding one requires a restart.
+package shards
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// maxAttached is SQLite's default limit on the number of attached databases.
+const maxAttached = 10
+
+// Tables lists the table held by each database that can be sharded.
+var Tables = map[string]string{
+	"logs":         "event_logs",
+	"transactions": "transactions",
+}
+
+// Shard is a database file holding the rows of a logical database from a
+// block onwards.
+type Shard struct {
+	Path string `yaml:"path"`
+	From uint64 `yaml:"from"`
+}
+
+type shardSet struct {
+	schemas []string
+	from    []uint64
+	paths   []string
+}
+
+var sets = make(map[string]*shardSet)
+
+// Configure validates the configured shards and records their ranges. It
+// must be called before the database is opened.
+func Configure(databases map[string]string, shards map[string][]Shard) error {
+	sets = make(map[string]*shardSet)
+	attached := len(databases)
+	for name, list := range shards {
+		if len(list) == 0 {
+			continue
+		}
+		if _, ok := Tables[name]; !ok {
+			return fmt.Errorf("database %v cannot be sharded", name)
+		}
+		if _, ok := databases[name]; !ok {
+			return fmt.Errorf("shards configured for database %v, which is not configured", name)
+		}
+		set := &shardSet{schemas: []string{name}, from: []uint64{0}, paths: []string{databases[name]}}
+		for i, shard := range list {
+			if shard.Path == "" {
+				return fmt.Errorf("%v shard %v has no path", name, i)
+			}
+			if shard.From <= set.from[len(set.from)-1] {
+				return fmt.Errorf("%v shards must begin at increasing blocks above 0", name)
+			}
+			set.schemas = append(set.schemas, fmt.Sprintf("%v_%v", name, i+1))
+			set.from = append(set.from, shard.From)
+			set.paths = append(set.paths, shard.Path)
+		}
+		attached += len(list)
+		sets[name] = set
+	}
+	if attached > maxAttached {
+		return fmt.Errorf("%v databases configured, sqlite can attach at most %v", attached, maxAttached)
+	}
+	return nil
+}
+
+// Attachments returns the path of each shard keyed by the schema it should
+// be attached as.
+func Attachments() map[string]string {
+	attachments := make(map[string]string)
+	for _, set := range sets {
+		for i := 1; i < len(set.schemas); i++ {
+			attachments[set.schemas[i]] = set.paths[i]
+		}
+	}
+	return attachments
+}
+
+// Sharded reports whether the named database has shards.
+func Sharded(name string) bool {
+	_, ok := sets[name]
+	return ok
+}
+
+// schemas returns the schemas of the named database holding rows for blocks
+// from through to.
+func schemas(name string, from, to uint64) []string {
+	set, ok := sets[name]
+	if !ok {
+		return []string{name}
+	}
+	result := []string{}
+	for i, schema := range set.schemas {
+		end := uint64(math.MaxUint64)
+		if i+1 < len(set.from) {
+			end = set.from[i+1] - 1
+		}
+		if set.from[i] <= to && end >= from {
+			result = append(result, schema)
+		}
+	}
+	if len(result) == 0 {
+		return []string{name}
+	}
+	return result
+}
+
+// Table returns the qualified table holding the rows of block.
+func Table(name, table string, block uint64) string {
+	return Range(name, table, block, block)[0]
+}
+
+// Range returns the qualified tables holding rows for blocks from through to.
+func Range(name, table string, from, to uint64) []string {
+	schemas := schemas(name, from, to)
+	tables := make([]string, len(schemas))
+	for i, schema := range schemas {
+		tables[i] = fmt.Sprintf("%v.%v", schema, table)
+	}
+	return tables
+}
+
+// All returns every qualified table holding rows of the named database.
+func All(name, table string) []string {
+	return Range(name, table, 0, math.MaxUint64)
+}
+
+// Source returns a FROM clause source for the rows of blocks from through to.
+// When they span several shards the source is a union of the shards aliased
+// as the table, which SQLite filters shard by shard, so the where clause of
+// the enclosing query can still use each shard's indexes. hint, such as an
+// INDEXED BY clause, is applied to every shard.
+func Source(name, table string, from, to uint64, hint string) string {
+	tables := Range(name, table, from, to)
+	if len(tables) == 1 {
+		return strings.TrimSpace(fmt.Sprintf("%v %v", tables[0], hint))
+	}
+	selects := make([]string, len(tables))
+	for i, t := range tables {
+		selects[i] = strings.TrimSpace(fmt.Sprintf("SELECT * FROM %v %v", t, hint))
+	}
+	return fmt.Sprintf("(%v) AS %v", strings.Join(selects, " UNION ALL "), table)
+}
+
+var createPattern = regexp.MustCompile(`^(CREATE (?:UNIQUE )?(?:TABLE|INDEX) )(?:IF NOT EXISTS )?`)
+
+// Migrate creates the sharded tables and their indexes in any shard missing
+// them, copying their definitions from the logical database. Migrations that
+// alter the columns of a sharded table must alter every shard.
+func Migrate(db *sql.DB) error {
+	for name, set := range sets {
+		table := Tables[name]
+		rows, err := db.Query(fmt.Sprintf("SELECT type, name, sql FROM %v.sqlite_master WHERE tbl_name = ? AND sql IS NOT NULL ORDER BY type = 'index';", name), table)
+		if err != nil {
+			return err
+		}
+		type definition struct{ kind, name, sql string }
+		definitions := []definition{}
+		for rows.Next() {
+			var d definition
+			if err := rows.Scan(&d.kind, &d.name, &d.sql); err != nil {
+				rows.Close()
+				return err
+			}
+			definitions = append(definitions, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, schema := range set.schemas[1:] {
+			for _, d := range definitions {
+				var existing string
+				db.QueryRow(fmt.Sprintf("SELECT name FROM %v.sqlite_master WHERE type = ? AND name = ?;", schema), d.kind, d.name).Scan(&existing)
+				if existing == d.name {
+					continue
+				}
+				statement := createPattern.ReplaceAllString(d.sql, fmt.Sprintf("${1}%v.", schema))
+				if _, err := db.Exec(statement); err != nil {
+					return fmt.Errorf("%v: %w", schema, err)
+				}
+				log.Info("Created shard schema", "shard", schema, d.kind, d.name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package shards
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestRange(t *testing.T) {
+	databases := map[string]string{"logs": "logs.sqlite", "transactions": "transactions.sqlite"}
+	if err := Configure(databases, map[string][]Shard{"logs": {{Path: "b.sqlite", From: 200}, {Path: "a.sqlite", From: 100}}}); err == nil {
+		t.Errorf("expected shards out of order to be rejected")
+	}
+	if err := Configure(databases, map[string][]Shard{"blocks": {{Path: "a.sqlite", From: 100}}}); err == nil {
+		t.Errorf("expected blocks shards to be rejected")
+	}
+	if err := Configure(databases, map[string][]Shard{"logs": {{Path: "a.sqlite", From: 100}, {Path: "b.sqlite", From: 200}}}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer Configure(nil, nil)
+
+	for _, c := range []struct {
+		from, to uint64
+		tables   []string
+	}{
+		{0, 99, []string{"logs.event_logs"}},
+		{99, 100, []string{"logs.event_logs", "logs_1.event_logs"}},
+		{150, 250, []string{"logs_1.event_logs", "logs_2.event_logs"}},
+		{300, 400, []string{"logs_2.event_logs"}},
+	} {
+		if tables := Range("logs", "event_logs", c.from, c.to); !reflect.DeepEqual(tables, c.tables) {
+			t.Errorf("blocks %v-%v: expected %v, got %v", c.from, c.to, c.tables, tables)
+		}
+	}
+	if table := Table("transactions", "transactions", 150); table != "transactions.transactions" {
+		t.Errorf("unexpected unsharded table %v", table)
+	}
+	if source := Source("logs", "event_logs", 150, 250, "INDEXED BY x"); source != "(SELECT * FROM logs_1.event_logs INDEXED BY x UNION ALL SELECT * FROM logs_2.event_logs INDEXED BY x) AS event_logs" {
+		t.Errorf("unexpected source %v", source)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{"logs": filepath.Join(dir, "logs.sqlite")}
+	if err := Configure(databases, map[string][]Shard{"logs": {{Path: filepath.Join(dir, "logs-1.sqlite"), From: 100}}}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer Configure(nil, nil)
+	sql.Register("sqlite3_shards_test", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for name, path := range databases {
+				if _, err := conn.Exec(fmt.Sprintf("ATTACH DATABASE '%v' AS '%v';", path, name), nil); err != nil {
+					return err
+				}
+			}
+			for name, path := range Attachments() {
+				if _, err := conn.Exec(fmt.Sprintf("ATTACH DATABASE '%v' AS '%v';", path, name), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	db, err := sql.Open("sqlite3_shards_test", ":memory:")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE logs.event_logs (address varchar(20), block BIGINT, logIndex MEDIUMINT, PRIMARY KEY (block, logIndex))",
+		"CREATE INDEX logs.address_compound ON event_logs(address, block)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("second migration failed: %v", err.Error())
+	}
+	for _, block := range []uint64{99, 100, 101} {
+		if _, err := db.Exec(fmt.Sprintf("INSERT INTO %v(address, block, logIndex) VALUES (X'01', ?, 0)", Table("logs", "event_logs", block)), block); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM logs_1.event_logs").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 rows in the shard, got %v (%v)", count, err)
+	}
+	if err := db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %v WHERE address = X'01' AND block >= ? AND block <= ?", Source("logs", "event_logs", 99, 100, "INDEXED BY address_compound")), 99, 100).Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 rows across shards, got %v (%v)", count, err)
+	}
+}
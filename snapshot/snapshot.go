@@ -0,0 +1,353 @@
+// Package snapshot copies flume's databases into a directory while indexing
+// continues, using SQLite's online backup API.
+//
+// Alongside the copies it writes a manifest recording the block the snapshot
+// was taken at, the cardinal offsets to resume from, the migration version of
+// each database and a checksum of each file. The manifest is written last, so
+// a directory without one holds an incomplete snapshot.
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/mattn/go-sqlite3"
+	"github.com/openrelayxyz/cardinal-flume/shards"
+	"github.com/openrelayxyz/cardinal-types"
+)
+
+// ManifestFile is the name of the manifest within a snapshot directory.
+const ManifestFile = "manifest.json"
+
+// stepPages is the number of pages copied per backup step.
+const stepPages = 1024
+
+type Offset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Block      uint64           `json:"block"`
+	Hash       types.Hash       `json:"hash"`
+	Created    int64            `json:"created"`
+	Offsets    []Offset         `json:"offsets"`
+	Migrations map[string]uint  `json:"migrations"`
+	Files      map[string]*File `json:"files"`
+}
+
+// Create copies the databases attached to db, keyed by schema, into dir,
+// which must not already hold a snapshot, and returns the manifest it wrote.
+//
+// The copies are read inside a single read transaction. When lock is the
+// indexer's lock, the transaction begins between commits and every copy
+// reflects the same block. Without it, as when another process is indexing,
+// the blocks database is read first, so the others are at least as recent and
+// the rows past the manifest's block are replaced once indexing resumes.
+func Create(ctx context.Context, db *sql.DB, lock sync.Locker, databases map[string]string, dir string) (*Manifest, error) {
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err == nil {
+		return nil, fmt.Errorf("%v already holds a snapshot", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	schemas := make([]string, 0, len(databases))
+	for schema := range databases {
+		schemas = append(schemas, schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		if (schemas[i] == "blocks") != (schemas[j] == "blocks") {
+			return schemas[i] == "blocks"
+		}
+		return schemas[i] < schemas[j]
+	})
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN;"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK;")
+	if err := begin(ctx, conn, lock, schemas); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Created:    time.Now().Unix(),
+		Offsets:    []Offset{},
+		Migrations: make(map[string]uint),
+		Files:      make(map[string]*File),
+	}
+	if err := manifest.read(ctx, conn, schemas); err != nil {
+		return nil, err
+	}
+	for _, schema := range schemas {
+		start := time.Now()
+		path := filepath.Join(dir, schema+".sqlite")
+		if err := backup(ctx, conn, schema, path); err != nil {
+			return nil, fmt.Errorf("%v: %w", schema, err)
+		}
+		file, err := checksum(path)
+		if err != nil {
+			return nil, err
+		}
+		file.Path = filepath.Base(path)
+		manifest.Files[schema] = file
+		log.Info("Database copied", "schema", schema, "size", file.Size, "elapsed", time.Since(start))
+	}
+	if err := manifest.write(dir); err != nil {
+		return nil, err
+	}
+	log.Info("Snapshot created", "dir", dir, "block", manifest.Block)
+	return manifest, nil
+}
+
+// begin opens the read transaction on each schema, holding lock while it
+// does so.
+func begin(ctx context.Context, conn *sql.Conn, lock sync.Locker, schemas []string) error {
+	if lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	for _, schema := range schemas {
+		var count int
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %v.sqlite_master;", schema)).Scan(&count); err != nil {
+			return fmt.Errorf("%v: %w", schema, err)
+		}
+	}
+	return nil
+}
+
+func hasTable(ctx context.Context, conn *sql.Conn, schema, table string) bool {
+	var name string
+	conn.QueryRowContext(ctx, fmt.Sprintf("SELECT name FROM %v.sqlite_master WHERE type = 'table' AND name = ?;", schema), table).Scan(&name)
+	return name == table
+}
+
+func (m *Manifest) read(ctx context.Context, conn *sql.Conn, schemas []string) error {
+	for _, schema := range schemas {
+		if !hasTable(ctx, conn, schema, "migrations") {
+			continue
+		}
+		var version uint
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT max(version) FROM %v.migrations;", schema)).Scan(&version); err != nil {
+			return fmt.Errorf("%v: %w", schema, err)
+		}
+		m.Migrations[schema] = version
+	}
+	if hasTable(ctx, conn, "blocks", "blocks") {
+		var hash []byte
+		err := conn.QueryRowContext(ctx, "SELECT number, hash FROM blocks.blocks ORDER BY number DESC LIMIT 1;").Scan(&m.Block, &hash)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		m.Hash = types.BytesToHash(hash)
+	}
+	if hasTable(ctx, conn, "blocks", "cardinal_offsets") {
+		rows, err := conn.QueryContext(ctx, "SELECT topic, partition, offset FROM blocks.cardinal_offsets ORDER BY topic, partition;")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var o Offset
+			if err := rows.Scan(&o.Topic, &o.Partition, &o.Offset); err != nil {
+				return err
+			}
+			m.Offsets = append(m.Offsets, o)
+		}
+		return rows.Err()
+	}
+	return nil
+}
+
+// backup copies schema into a new database at path through conn, so the copy
+// reflects conn's open read transaction.
+func backup(ctx context.Context, conn *sql.Conn, schema, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%v already exists", path)
+	}
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	return destConn.Raw(func(d interface{}) error {
+		return conn.Raw(func(s interface{}) error {
+			bk, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), schema)
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := bk.Step(stepPages)
+				if err != nil {
+					bk.Finish()
+					return err
+				}
+				if done {
+					return bk.Finish()
+				}
+				if err := ctx.Err(); err != nil {
+					bk.Finish()
+					return err
+				}
+			}
+		})
+	})
+}
+
+func checksum(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (m *Manifest) write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestFile))
+}
+
+// ReadManifest loads the manifest of the snapshot in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Databases returns the configured databases along with their shards.
+func Databases(databases map[string]string) map[string]string {
+	result := make(map[string]string)
+	for schema, path := range databases {
+		result[schema] = path
+	}
+	for schema, path := range shards.Attachments() {
+		result[schema] = path
+	}
+	return result
+}
+
+// Verify checks each file in dir against the manifest's size and checksum.
+func (m *Manifest) Verify(dir string) error {
+	for schema, expected := range m.Files {
+		file, err := checksum(filepath.Join(dir, expected.Path))
+		if err != nil {
+			return fmt.Errorf("%v: %w", schema, err)
+		}
+		if file.Size != expected.Size || file.SHA256 != expected.SHA256 {
+			return fmt.Errorf("%v: %v does not match the manifest", schema, expected.Path)
+		}
+	}
+	return nil
+}
+
+// Restore verifies the snapshot in dir and copies its files to the paths of
+// the configured databases, keyed by schema, which must not already exist.
+// Databases in the snapshot that are not configured are skipped, unless they
+// are shards of a configured database, whose rows would be lost.
+func Restore(dir string, databases map[string]string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Verify(dir); err != nil {
+		return nil, err
+	}
+	for schema := range m.Files {
+		if _, ok := databases[schema]; ok {
+			continue
+		}
+		for name := range shards.Tables {
+			if _, ok := databases[name]; ok && strings.HasPrefix(schema, name+"_") {
+				return nil, fmt.Errorf("snapshot holds shard %v, which is not configured", schema)
+			}
+		}
+	}
+	for schema, path := range databases {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%v database %v already exists", schema, path)
+		}
+	}
+	for schema, file := range m.Files {
+		path, ok := databases[schema]
+		if !ok {
+			log.Warn("Skipping database that is not configured", "schema", schema)
+			continue
+		}
+		if err := copyFile(filepath.Join(dir, file.Path), path); err != nil {
+			return nil, fmt.Errorf("%v: %w", schema, err)
+		}
+		log.Info("Database restored", "schema", schema, "path", path)
+	}
+	return m, nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,195 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func openDatabase(t *testing.T, name string, databases map[string]string) *sql.DB {
+	driver := fmt.Sprintf("sqlite3_snapshot_%v", name)
+	sql.Register(driver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for name, path := range databases {
+				if _, err := conn.Exec(fmt.Sprintf("ATTACH DATABASE '%v' AS '%v'; PRAGMA %v.journal_mode = WAL;", path, name, name), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	db, err := sql.Open(driver, ":memory:")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return db
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"blocks": filepath.Join(dir, "blocks.sqlite"),
+		"logs":   filepath.Join(dir, "logs.sqlite"),
+	}
+	db := openDatabase(t, "source", databases)
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE blocks.migrations (version integer PRIMARY KEY)",
+		"INSERT INTO blocks.migrations(version) VALUES (8)",
+		"CREATE TABLE blocks.blocks (number BIGINT PRIMARY KEY, hash varchar(32))",
+		"CREATE TABLE blocks.cardinal_offsets (partition INT, offset BIGINT, topic STRING, PRIMARY KEY (topic, partition))",
+		"CREATE TABLE logs.event_logs (block BIGINT, data blob)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	mut := &sync.RWMutex{}
+	insert := func(n int) {
+		mut.Lock()
+		defer mut.Unlock()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		defer tx.Rollback()
+		for _, statement := range []string{
+			"INSERT INTO blocks.blocks(number, hash) VALUES (?, randomblob(32))",
+			"INSERT INTO logs.event_logs(block, data) VALUES (?, randomblob(4096))",
+			"INSERT OR REPLACE INTO blocks.cardinal_offsets(partition, offset, topic) VALUES (0, ?, 'blocks')",
+		} {
+			if _, err := tx.Exec(statement, n); err != nil {
+				t.Errorf(err.Error())
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf(err.Error())
+		}
+	}
+	for i := 1; i <= 200; i++ {
+		insert(i)
+	}
+
+	done := make(chan struct{})
+	writing := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 201; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				insert(i)
+			}
+			if i == 210 {
+				close(writing)
+			}
+		}
+	}()
+	<-writing
+	target := filepath.Join(dir, "snapshot")
+	manifest, err := Create(context.Background(), db, mut.RLocker(), databases, target)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if manifest.Block < 210 {
+		t.Errorf("unexpected block %v", manifest.Block)
+	}
+	if len(manifest.Offsets) != 1 || manifest.Offsets[0].Offset != int64(manifest.Block) {
+		t.Errorf("offsets %v do not match block %v", manifest.Offsets, manifest.Block)
+	}
+	if manifest.Migrations["blocks"] != 8 {
+		t.Errorf("unexpected migrations %v", manifest.Migrations)
+	}
+	if len(manifest.Files) != 2 {
+		t.Errorf("unexpected files %v", manifest.Files)
+	}
+
+	read, err := ReadManifest(target)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if read.Hash != manifest.Hash || read.Files["logs"].SHA256 != manifest.Files["logs"].SHA256 {
+		t.Errorf("manifest read back as %v", read)
+	}
+	copied := openDatabase(t, "copy", map[string]string{
+		"blocks": filepath.Join(target, read.Files["blocks"].Path),
+		"logs":   filepath.Join(target, read.Files["logs"].Path),
+	})
+	defer copied.Close()
+	var blocks, logs uint64
+	if err := copied.QueryRow("SELECT max(number) FROM blocks.blocks").Scan(&blocks); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := copied.QueryRow("SELECT max(block) FROM logs.event_logs").Scan(&logs); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if blocks != manifest.Block || logs != manifest.Block {
+		t.Errorf("copies hold blocks to %v and logs to %v, manifest is at %v", blocks, logs, manifest.Block)
+	}
+
+	if _, err := Create(context.Background(), db, nil, databases, target); err == nil {
+		t.Errorf("expected an error creating a snapshot over an existing one")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{
+		"blocks":  filepath.Join(dir, "blocks.sqlite"),
+		"mempool": filepath.Join(dir, "mempool.sqlite"),
+	}
+	db := openDatabase(t, "restore", databases)
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE blocks.blocks (number BIGINT PRIMARY KEY, hash varchar(32))",
+		"INSERT INTO blocks.blocks(number, hash) VALUES (7, randomblob(32))",
+		"CREATE TABLE mempool.transactions (hash varchar(32))",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	target := filepath.Join(dir, "snapshot")
+	manifest, err := Create(context.Background(), db, nil, databases, target)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	restored := map[string]string{"blocks": filepath.Join(dir, "restored.sqlite")}
+	if _, err := Restore(target, map[string]string{"blocks": databases["blocks"]}); err == nil {
+		t.Errorf("expected an error restoring over an existing database")
+	}
+	if _, err := Restore(target, map[string]string{"logs": filepath.Join(dir, "logs.sqlite"), "logs_1": filepath.Join(dir, "logs_1.sqlite")}); err != nil {
+		t.Errorf("unexpected error skipping unconfigured databases: %v", err)
+	}
+	read, err := Restore(target, restored)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if read.Block != 7 || read.Hash != manifest.Hash {
+		t.Errorf("unexpected manifest %v", read)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "restored.sqlite")); err != nil {
+		t.Errorf("blocks database not restored: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(target, manifest.Files["mempool"].Path), []byte("corrupt"), 0644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := manifest.Verify(target); err == nil {
+		t.Errorf("expected a checksum error")
+	}
+}
@@ -1,8 +1,6 @@
 package txfeed
 
 import (
-	"fmt"
-
 	log "github.com/inconshreveable/log15"
 	"github.com/openrelayxyz/cardinal-evm/rlp"
 	evm "github.com/openrelayxyz/cardinal-evm/types"
@@ -27,13 +25,17 @@ func (f *TxFeed) start(ch chan *evm.Transaction) {
 	}()
 }
 
+// ResolveTransactionFeed returns the feed of pending transactions from the
+// broker at feedURL, which is disabled when topic is empty. For Kafka brokers
+// topic names the topic carrying the transactions, and for websocket brokers
+// the eth_subscribe subscription, usually newPendingTransactions.
 func ResolveTransactionFeed(feedURL, topic string) (*TxFeed, error) {
 	feedURL = strings.TrimPrefix(feedURL, "cardinal://")
 	feedURL = strings.Split(feedURL, ";")[0]
 	if topic == "" {
 		return &TxFeed{}, nil
 	} else if strings.HasPrefix(feedURL, "ws://") || strings.HasPrefix(feedURL, "wss://") {
-		return nil, fmt.Errorf("transactions are not currently supported with websockets")
+		return WebsocketTxFeed(feedURL, topic)
 	} else if strings.HasPrefix(feedURL, "kafka://") {
 		return KafkaTxFeed(feedURL, topic)
 	}
@@ -0,0 +1,190 @@
+package txfeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/inconshreveable/log15"
+	evm "github.com/openrelayxyz/cardinal-evm/types"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+)
+
+// DefaultSubscription is the pending transaction subscription used by
+// websocket feeds when no topic is configured.
+const DefaultSubscription = "newPendingTransactions"
+
+var (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	// readTimeout is how long a session waits for a message or a pong before
+	// treating the connection as dead.
+	readTimeout  = time.Minute
+	pingInterval = 20 * time.Second
+)
+
+type wsCall struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type wsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type wsMessage struct {
+	ID     *uint64         `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *wsError        `json:"error"`
+}
+
+type wsNotification struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// WebsocketTxFeed subscribes to the pending transaction hashes of the node at
+// url with eth_subscribe, and fetches each transaction with
+// eth_getRawTransactionByHash over the same connection. Transactions that
+// leave the pool before they are fetched are skipped. The connection is
+// re-established with exponential backoff whenever it drops.
+func WebsocketTxFeed(url, subscription string) (*TxFeed, error) {
+	if subscription == "" {
+		subscription = DefaultSubscription
+	}
+	ch := make(chan *evm.Transaction, 200)
+	c := &wsTxClient{url: url, subscription: subscription, ch: ch}
+	go c.run()
+	txFeed := &TxFeed{}
+	txFeed.start(ch)
+	return txFeed, nil
+}
+
+type wsTxClient struct {
+	url          string
+	subscription string
+	ch           chan<- *evm.Transaction
+}
+
+func (c *wsTxClient) run() {
+	log.Info("Starting websocket tx feed", "url", c.url, "subscription", c.subscription)
+	backoff := minBackoff
+	for {
+		subscribed, err := c.session()
+		if subscribed {
+			backoff = minBackoff
+		}
+		log.Warn("Websocket tx feed disconnected", "url", c.url, "err", err.Error(), "retry", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session runs one connection until it fails, reporting whether the
+// subscription was established. The node is pinged periodically, and the
+// connection fails if neither a message nor a pong arrives within readTimeout
+// of starting to read. The deadline is set before each read, so time spent
+// waiting on a slow consumer of the feed does not count against it.
+func (c *wsTxClient) session() (bool, error) {
+	dialer := &websocket.Dialer{
+		EnableCompression: true,
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  45 * time.Second,
+	}
+	conn, _, err := dialer.Dial(c.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	timeout := readTimeout
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func(interval time.Duration) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// WriteControl may be called concurrently with the session's
+				// other writes.
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+					return
+				}
+			}
+		}
+	}(pingInterval)
+	var id uint64
+	call := func(method string, params ...interface{}) (uint64, error) {
+		id++
+		return id, conn.WriteJSON(wsCall{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	}
+	subID, err := call("eth_subscribe", c.subscription)
+	if err != nil {
+		return false, err
+	}
+	var subscription string
+	pending := make(map[uint64]struct{})
+	for {
+		var msg wsMessage
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		if err := conn.ReadJSON(&msg); err != nil {
+			return subscription != "", err
+		}
+		switch {
+		case msg.Method == "eth_subscription":
+			var n wsNotification
+			if err := json.Unmarshal(msg.Params, &n); err != nil || n.Subscription != subscription {
+				continue
+			}
+			var hash types.Hash
+			if err := json.Unmarshal(n.Result, &hash); err != nil {
+				log.Warn("Unexpected pending transaction notification", "result", string(n.Result))
+				continue
+			}
+			requestID, err := call("eth_getRawTransactionByHash", hash)
+			if err != nil {
+				return true, err
+			}
+			pending[requestID] = struct{}{}
+		case msg.ID == nil:
+		case *msg.ID == subID:
+			if msg.Error != nil {
+				return false, fmt.Errorf("eth_subscribe %v: %v", c.subscription, msg.Error.Message)
+			}
+			if err := json.Unmarshal(msg.Result, &subscription); err != nil {
+				return false, err
+			}
+			log.Info("Websocket tx feed subscribed", "url", c.url, "id", subscription)
+		default:
+			if _, ok := pending[*msg.ID]; !ok {
+				continue
+			}
+			delete(pending, *msg.ID)
+			var raw hexutil.Bytes
+			if msg.Error != nil || json.Unmarshal(msg.Result, &raw) != nil || len(raw) == 0 {
+				continue
+			}
+			transaction := &evm.Transaction{}
+			if err := transaction.UnmarshalBinary(raw); err != nil {
+				log.Error("Failed to decode transaction", "err", err.Error())
+				continue
+			}
+			c.ch <- transaction
+		}
+	}
+}
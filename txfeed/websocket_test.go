@@ -0,0 +1,216 @@
+package txfeed
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openrelayxyz/cardinal-evm/common"
+	evm "github.com/openrelayxyz/cardinal-evm/types"
+	"github.com/openrelayxyz/cardinal-types"
+	"github.com/openrelayxyz/cardinal-types/hexutil"
+)
+
+// fakeNode serves one pending transaction per connection and then drops it.
+func fakeNode(t *testing.T, txs []*evm.Transaction) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	connections := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		defer conn.Close()
+		tx := txs[connections%len(txs)]
+		connections++
+		for {
+			var call wsCall
+			if err := conn.ReadJSON(&call); err != nil {
+				return
+			}
+			switch call.Method {
+			case "eth_subscribe":
+				conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": "0xcafe"})
+				conn.WriteJSON(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"method":  "eth_subscription",
+					"params":  map[string]interface{}{"subscription": "0xcafe", "result": tx.Hash()},
+				})
+			case "eth_getRawTransactionByHash":
+				raw, _ := tx.MarshalBinary()
+				conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": hexutil.Bytes(raw)})
+				return
+			}
+		}
+	}))
+}
+
+// quietNode acknowledges the subscription and then sends nothing until done
+// is closed. With answer set it keeps reading, so pings are answered.
+func quietNode(t *testing.T, answer bool, done <-chan struct{}) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		defer conn.Close()
+		var call wsCall
+		if err := conn.ReadJSON(&call); err != nil {
+			return
+		}
+		conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": "0xcafe"})
+		if answer {
+			go func() {
+				for {
+					if _, _, err := conn.NextReader(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		<-done
+	}))
+}
+
+func TestWebsocketSessionTimeout(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		readTimeout, pingInterval = timeout, interval
+	}(readTimeout, pingInterval)
+	readTimeout, pingInterval = 200*time.Millisecond, 50*time.Millisecond
+	for _, answer := range []bool{true, false} {
+		done := make(chan struct{})
+		server := quietNode(t, answer, done)
+		c := &wsTxClient{url: strings.Replace(server.URL, "http://", "ws://", 1), subscription: DefaultSubscription}
+		result := make(chan error, 1)
+		go func() {
+			subscribed, err := c.session()
+			if !subscribed {
+				t.Errorf("expected the session to subscribe")
+			}
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			if answer {
+				t.Errorf("session with a responsive node ended: %v", err)
+			}
+			close(done)
+		case <-time.After(5 * readTimeout):
+			if !answer {
+				t.Errorf("session with an unresponsive node did not time out")
+			}
+			// The session ends once the node closes the connection.
+			close(done)
+			<-result
+		}
+		server.Close()
+	}
+}
+
+func TestWebsocketSlowConsumer(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		readTimeout, pingInterval = timeout, interval
+	}(readTimeout, pingInterval)
+	readTimeout, pingInterval = 200*time.Millisecond, 50*time.Millisecond
+	to := common.HexToAddress("0x01")
+	tx := evm.NewTx(&evm.LegacyTx{Nonce: 1, To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)})
+	done := make(chan struct{})
+	defer close(done)
+	// The node serves one transaction and then stays connected, answering
+	// pings.
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				var call wsCall
+				if err := conn.ReadJSON(&call); err != nil {
+					return
+				}
+				switch call.Method {
+				case "eth_subscribe":
+					conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": "0xcafe"})
+					conn.WriteJSON(map[string]interface{}{
+						"jsonrpc": "2.0",
+						"method":  "eth_subscription",
+						"params":  map[string]interface{}{"subscription": "0xcafe", "result": tx.Hash()},
+					})
+				case "eth_getRawTransactionByHash":
+					raw, _ := tx.MarshalBinary()
+					conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": hexutil.Bytes(raw)})
+				}
+			}
+		}()
+		<-done
+	}))
+	defer server.Close()
+
+	ch := make(chan *evm.Transaction)
+	c := &wsTxClient{url: strings.Replace(server.URL, "http://", "ws://", 1), subscription: DefaultSubscription, ch: ch}
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.session()
+		result <- err
+	}()
+	// Stall for longer than readTimeout before taking the transaction.
+	time.Sleep(3 * readTimeout)
+	select {
+	case <-ch:
+	case err := <-result:
+		t.Fatalf("session ended before delivering: %v", err)
+	}
+	select {
+	case err := <-result:
+		t.Errorf("session ended after a slow delivery: %v", err)
+	case <-time.After(3 * readTimeout):
+	}
+}
+
+func TestWebsocketTxFeed(t *testing.T) {
+	minBackoff = 10 * time.Millisecond
+	to := common.HexToAddress("0x01")
+	txs := []*evm.Transaction{
+		evm.NewTx(&evm.LegacyTx{Nonce: 1, To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)}),
+		evm.NewTx(&evm.DynamicFeeTx{ChainID: big.NewInt(1), Nonce: 2, To: &to, Value: big.NewInt(2), Gas: 21000, GasFeeCap: big.NewInt(2), GasTipCap: big.NewInt(1)}),
+	}
+	server := fakeNode(t, txs)
+	defer server.Close()
+
+	feed, err := ResolveTransactionFeed("cardinal://"+strings.Replace(server.URL, "http://", "ws://", 1), "newPendingTransactions")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ch := make(chan *evm.Transaction, 10)
+	sub := feed.Subscribe(ch)
+	defer sub.Unsubscribe()
+	// Each connection serves one transaction, so receiving both means the feed
+	// reconnected. The node cycles through them, in case the first was sent
+	// before subscribing.
+	seen := make(map[types.Hash]bool)
+	timeout := time.After(5 * time.Second)
+	for len(seen) < len(txs) {
+		select {
+		case tx := <-ch:
+			seen[tx.Hash()] = true
+		case <-timeout:
+			t.Fatalf("timed out with %v of %v transactions", len(seen), len(txs))
+		}
+	}
+	for _, tx := range txs {
+		if !seen[tx.Hash()] {
+			t.Errorf("missing transaction %x", tx.Hash())
+		}
+	}
+}